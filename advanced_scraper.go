@@ -1,25 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
-	"regexp"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AdvancedScraper maneja el scraping con técnicas anti-detección
 type AdvancedScraper struct {
-	client      *http.Client
-	userAgents  []string
+	client      *http.Client // configuración común; cada sesión lo copia con su propio cookie jar
+	sessions    *SessionPool
 	rateLimiter chan time.Time
-	retryDelays []time.Duration
-	currentUA   int
+	inflight    *requestGroup
+	stop        chan struct{} // cierra el goroutine del rate limiter
+	closeOnce   sync.Once
 }
 
 // ScrapingResult contiene el resultado del scraping
@@ -27,221 +34,522 @@ type ScrapingResult struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
 	Error   string      `json:"error,omitempty"`
+	Err     error       `json:"-"`      // error tipado (ScraperError) cuando Success es false
 	Source  string      `json:"source"` // "api", "scraping", "cache"
 }
 
-// NewAdvancedScraper crea un nuevo scraper avanzado
-func NewAdvancedScraper() *AdvancedScraper {
-	// Crear jar de cookies
-	jar, _ := cookiejar.New(nil)
+// failure retorna el error tipado del resultado, o uno genérico si no se asignó
+func (r *ScrapingResult) failure() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return errors.New(r.Error)
+}
 
-	// Cliente HTTP con configuración avanzada
-	client := &http.Client{
-		Jar:     jar,
-		Timeout: 45 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        30,
-			IdleConnTimeout:     90 * time.Second,
-			TLSHandshakeTimeout: 10 * time.Second,
-			DisableCompression:  false,
-		},
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			// Detectar redirecciones a queue-it
-			if strings.Contains(req.URL.Host, "queue-it.net") {
-				return fmt.Errorf("blocked by anti-bot protection (queue-it)")
-			}
-			if len(via) >= 5 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
+// err retorna nil si el resultado fue exitoso y su error si no
+func (r *ScrapingResult) err() error {
+	if r.Success {
+		return nil
 	}
+	return r.failure()
+}
 
-	// User agents realistas
-	userAgents := []string{
-		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
-		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
-		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:121.0) Gecko/20100101 Firefox/121.0",
-		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+// scraperTransport es el transport por defecto del AdvancedScraper: sale por el pool de
+// proxies y respeta UPSTREAM_CASSETTE_MODE y UPSTREAM_MOCK_URL
+func scraperTransport() http.RoundTripper {
+	return upstreamTransport(&proxyTransport{base: &http.Transport{
+		Proxy:               proxyFromContext,
+		MaxIdleConns:        30,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		DisableCompression:  false,
+	}})
+}
+
+// NewAdvancedScraper crea un nuevo scraper avanzado que hace sus peticiones con transport.
+// Cada sesión del pool copia el cliente, así que el transport queda fijo desde aquí.
+func NewAdvancedScraper(transport http.RoundTripper) *AdvancedScraper {
+	// Cliente HTTP con configuración avanzada
+	client := &http.Client{
+		Timeout:       45 * time.Second,
+		Transport:     &instrumentedTransport{base: transport},
+		CheckRedirect: queueItCheckRedirect,
 	}
 
 	// Rate limiter: máximo 1 request cada 2 segundos
+	interval := 2 * time.Second
+	if cassetteReplaying() {
+		// Reproduciendo cassettes no hay servidor que proteger (los reintentos tampoco esperan)
+		interval = 0
+	}
 	rateLimiter := make(chan time.Time, 1)
+	stop := make(chan struct{})
 	go func() {
 		for {
-			rateLimiter <- time.Now()
-			time.Sleep(2 * time.Second)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case rateLimiter <- time.Now():
+			case <-stop:
+				return
+			}
+			select {
+			case <-time.After(interval):
+			case <-stop:
+				return
+			}
 		}
 	}()
 
 	return &AdvancedScraper{
 		client:      client,
-		userAgents:  userAgents,
+		sessions:    newSessionPool(client),
 		rateLimiter: rateLimiter,
-		retryDelays: []time.Duration{1 * time.Second, 3 * time.Second, 7 * time.Second, 15 * time.Second},
-		currentUA:   0,
+		inflight:    newRequestGroup(),
+		stop:        stop,
 	}
 }
 
-// makeRequest hace una petición HTTP con todas las técnicas anti-detección
-func (s *AdvancedScraper) makeRequest(method, url string, headers map[string]string) (*http.Response, []byte, error) {
+// Close detiene el rate limiter. Las peticiones en curso terminan, pero las que esperan
+// turno quedan esperando hasta que su contexto se cancele.
+func (s *AdvancedScraper) Close() {
+	s.closeOnce.Do(func() { close(s.stop) })
+}
+
+// makeRequest hace una petición HTTP con todas las técnicas anti-detección.
+// Los errores reintentables se repiten según la política de reintentos de la operación.
+// Si el contexto se cancela se abandonan la espera del rate limiter y los reintentos.
+// Cada intento queda como un span hijo de "upstream.request".
+func (s *AdvancedScraper) makeRequest(ctx context.Context, method, url string, headers map[string]string) (resp *http.Response, body []byte, err error) {
+	ctx, span := tracer.Start(ctx, "upstream.request", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", method),
+		attribute.String("url.full", url),
+		attribute.String("upstream.source", upstreamSource(ctx)),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Con la fila de queue-it activa no vale la pena esperar turno si no se abrirá a tiempo
+	if _, err := queueItDelay(ctx, 0); err != nil {
+		span.SetAttributes(attribute.Bool("upstream.queueit", true))
+		return nil, nil, err
+	}
+
 	// Rate limiting
-	<-s.rateLimiter
+	if err := s.waitRateLimit(ctx); err != nil {
+		return nil, nil, err
+	}
 
+	logger := loggerFrom(ctx).With("method", method, "url", url)
+	policy := retryPolicyFrom(ctx)
 	var lastErr error
 
-	for attempt := 0; attempt < len(s.retryDelays)+1; attempt++ {
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		var delay time.Duration
 		if attempt > 0 {
-			time.Sleep(s.retryDelays[attempt-1])
+			delay = policy.Backoff(attempt, lastErr)
+			if exceedsDeadline(ctx, delay) {
+				logger.Info("Upstream request not retried, the wait exceeds the deadline", "attempt", attempt+1, "delay", delay, "error", lastErr)
+				return nil, nil, lastErr
+			}
 		}
 
-		req, err := http.NewRequest(method, url, nil)
+		// Con la fila de queue-it activa se espera lo que indicó en vez de insistir
+		delay, err := queueItDelay(ctx, delay)
 		if err != nil {
-			return nil, nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		// Rotar user agent
-		s.currentUA = (s.currentUA + 1) % len(s.userAgents)
-		req.Header.Set("User-Agent", s.userAgents[s.currentUA])
-
-		// Headers básicos para parecer un navegador real
-		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8")
-		req.Header.Set("Accept-Language", "es-CL,es;q=0.9,en;q=0.8")
-		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-		req.Header.Set("Cache-Control", "no-cache")
-		req.Header.Set("Pragma", "no-cache")
-		req.Header.Set("Sec-Fetch-Dest", "document")
-		req.Header.Set("Sec-Fetch-Mode", "navigate")
-		req.Header.Set("Sec-Fetch-Site", "none")
-		req.Header.Set("Sec-Fetch-User", "?1")
-		req.Header.Set("Upgrade-Insecure-Requests", "1")
-
-		// Headers personalizados
-		for key, value := range headers {
-			req.Header.Set(key, value)
+			logger.Info("Upstream request not sent, queue-it wait exceeds the deadline", "attempt", attempt+1, "error", err)
+			span.SetAttributes(attribute.Bool("upstream.queueit", true))
+			return nil, nil, err
 		}
 
-		// Referrer aleatorio para parecer más natural
-		if strings.Contains(url, "lider.cl") {
-			req.Header.Set("Referer", "https://www.lider.cl/")
+		if attempt > 0 {
+			upstreamRetriesTotal.WithLabelValues(requestHost(url)).Inc()
+			logger.Debug("Retrying upstream request", "attempt", attempt+1, "delay", delay, "last_error", lastErr)
+			span.AddEvent("retry", trace.WithAttributes(
+				attribute.Int("attempt", attempt+1),
+				attribute.String("delay", delay.String()),
+			))
+		}
+		if delay > 0 {
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, nil, fmt.Errorf("request cancelled after %d attempts, last error: %v: %w", attempt, lastErr, contextError(ctx))
+			}
 		}
 
-		resp, err := s.client.Do(req)
+		// Cada intento toma una sesión y la devuelve antes de la espera del siguiente, para que
+		// otras solicitudes la usen mientras tanto; si Lider la bloqueó, el siguiente intento
+		// sale con la próxima sesión libre
+		session, err := s.sessions.Acquire(ctx)
 		if err != nil {
-			lastErr = err
-			continue
+			return nil, nil, err
+		}
+		s.warmUpSession(ctx, session)
+		resp, body, err := s.doAttempt(ctx, logger, session, method, url, headers, attempt)
+		s.sessions.Release(ctx, session, sessionBlocked(err))
+		if err == nil {
+			span.SetAttributes(attribute.Int("upstream.attempts", attempt+1))
+			return resp, body, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, fmt.Errorf("request cancelled: %w", contextError(ctx))
+		}
+		if !retryable(err) {
+			return nil, nil, err
 		}
+		lastErr = err
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
+	logger.Error("Upstream request exhausted retries", "attempts", policy.MaxAttempts, "error", lastErr)
+	return nil, nil, fmt.Errorf("max retries exceeded, last error: %w", lastErr)
+}
 
-		if err != nil {
-			lastErr = err
-			continue
-		}
+// sessionBlocked indica si el error de un intento cuenta como bloqueo de la sesión que lo hizo
+func sessionBlocked(err error) bool {
+	switch errorKindOf(err) {
+	case ErrUpstreamBlocked, ErrUpstreamQueued, ErrUpstreamRateLimited:
+		return err != nil
+	}
+	return false
+}
 
-		// Verificar si fuimos bloqueados
-		if resp.StatusCode == 429 || resp.StatusCode == 503 {
-			lastErr = fmt.Errorf("rate limited or service unavailable (status %d)", resp.StatusCode)
-			continue
+// doAttempt ejecuta un intento de makeRequest dentro de su propio span. Los status
+// reintentables (RETRY_STATUSES) se retornan como error; el resto, como respuesta.
+func (s *AdvancedScraper) doAttempt(ctx context.Context, logger *slog.Logger, session *scraperSession, method, url string, headers map[string]string, attempt int) (resp *http.Response, body []byte, err error) {
+	ctx, span := tracer.Start(ctx, "upstream.attempt", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.Int("attempt", attempt+1),
+	))
+	defer func() { endSpan(span, err) }()
+
+	// Las peticiones de una sesión salen por el mismo proxy (PROXY_STICKY_SESSIONS)
+	req, err := http.NewRequestWithContext(withProxySession(ctx, session), method, url, nil)
+	if err != nil {
+		return nil, nil, wrapScraperError(ErrInternal, err, "failed to create request")
+	}
+
+	// User agent y headers del perfil de navegador de la sesión
+	session.setHeaders(req)
+
+	// Headers básicos para parecer un navegador real
+	req.Header.Set("Accept-Language", "es-CL,es;q=0.9,en;q=0.8")
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Pragma", "no-cache")
+	req.Header.Set("Sec-Fetch-Dest", "document")
+	req.Header.Set("Sec-Fetch-Mode", "navigate")
+	req.Header.Set("Sec-Fetch-Site", "none")
+	req.Header.Set("Sec-Fetch-User", "?1")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+
+	// Headers personalizados
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	// Referrer para parecer más natural
+	req.Header.Set("Referer", getUpstreamEndpoints().Referer)
+
+	start := time.Now()
+	resp, err = session.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Warn("Upstream request failed", "attempt", attempt+1, "error", err)
 		}
+		var scraperErr *ScraperError
+		if errors.As(err, &scraperErr) {
+			return nil, nil, err
+		}
+		return nil, nil, wrapScraperError(ErrUpstreamFailure, err, "request failed")
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
-		// Verificar contenido de queue-it en el body
-		bodyStr := string(body)
-		if strings.Contains(bodyStr, "queue-it.net") || strings.Contains(bodyStr, "Queue-it") {
-			lastErr = fmt.Errorf("blocked by anti-bot protection")
-			continue
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		return nil, nil, wrapScraperError(ErrUpstreamFailure, err, "failed to read response body")
+	}
+	span.SetAttributes(attribute.Int("http.response.body.size", len(body)))
+
+	// Verificar si fuimos bloqueados o si Lider falló de forma transitoria
+	if getRetryPolicies().RetryStatus(resp.StatusCode) {
+		statusErr := statusError("upstream", resp.StatusCode)
+		statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		logger.Warn("Upstream request returned a retryable status", "attempt", attempt+1, "status", resp.StatusCode,
+			"retry_after", statusErr.RetryAfter)
+		return nil, nil, statusErr
+	}
+
+	// Verificar contenido de queue-it en el body
+	if isQueueItPage(body) {
+		queueItBlocksTotal.WithLabelValues(req.URL.Host, "body").Inc()
+		wait := getQueueItGate().Block(ctx, queueItWait(resp.Header, body), "body")
+		logger.Warn("Upstream request blocked by queue-it", "attempt", attempt+1, "status", resp.StatusCode, "wait", wait)
+		span.SetAttributes(attribute.Bool("upstream.queueit", true))
+		return nil, nil, queuedError(wait)
+	}
+
+	logger.Debug("Upstream request completed", "attempt", attempt+1, "status", resp.StatusCode,
+		"bytes", len(body), "duration", time.Since(start))
+	return resp, body, nil
+}
+
+// queueItCheckRedirect detecta las redirecciones a queue-it, cierra la compuerta y corta la
+// petición con ErrUpstreamQueued; las demás redirecciones se siguen hasta 5 veces
+func queueItCheckRedirect(req *http.Request, via []*http.Request) error {
+	if strings.Contains(req.URL.Host, "queue-it.net") {
+		queueItBlocksTotal.WithLabelValues(via[0].URL.Host, "redirect").Inc()
+		var header http.Header
+		if req.Response != nil {
+			header = req.Response.Header
 		}
+		return queuedError(getQueueItGate().Block(req.Context(), queueItWait(header, nil), "redirect"))
+	}
+	if len(via) >= 5 {
+		return fmt.Errorf("too many redirects")
+	}
+	return nil
+}
+
+// isQueueItPage indica si el body es la sala de espera de queue-it
+func isQueueItPage(body []byte) bool {
+	return bytes.Contains(body, []byte("queue-it.net")) || bytes.Contains(body, []byte("Queue-it"))
+}
 
-		return resp, body, nil
+// waitRateLimit espera un turno del rate limiter o retorna si el contexto se cancela
+func (s *AdvancedScraper) waitRateLimit(ctx context.Context) error {
+	_, span := tracer.Start(ctx, "rate_limiter.wait")
+	defer span.End()
+
+	start := time.Now()
+	select {
+	case <-s.rateLimiter:
+		rateLimiterWait.Observe(time.Since(start).Seconds())
+		return nil
+	case <-ctx.Done():
+		err := fmt.Errorf("request cancelled while waiting for rate limiter: %w", ctx.Err())
+		span.SetStatus(codes.Error, err.Error())
+		return err
 	}
+}
 
-	return nil, nil, fmt.Errorf("max retries exceeded, last error: %w", lastErr)
+// requestHost extrae el host de una URL para usarlo como label de métricas
+func requestHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Host
+	}
+	return "unknown"
+}
+
+// sleepContext duerme la duración indicada o retorna antes si el contexto se cancela
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // FetchProductsAdvanced busca productos con técnicas avanzadas
-func (s *AdvancedScraper) FetchProductsAdvanced(query string) *ScrapingResult {
+func (s *AdvancedScraper) FetchProductsAdvanced(ctx context.Context, query string, page PageRequest) *ScrapingResult {
 	if query == "" {
 		return &ScrapingResult{
 			Success: false,
 			Error:   "query parameter cannot be empty",
+			Err:     newScraperError(ErrInvalidInput, "se requiere parámetro 'q'"),
 		}
 	}
 
-	// Intentar primero con API interna
-	apiResult := s.tryAPIEndpoint(fmt.Sprintf("https://apps.lider.cl/supermercado/search?query=%s", url.QueryEscape(query)))
-	if apiResult.Success {
-		apiResult.Source = "api"
-		return apiResult
-	}
+	targets := getUpstreamEndpoints().targets(endpointSearch, query, page)
+	result, _ := s.inflight.Do(ctx, "search:"+normalizeUpstreamURL(targets[0].URL), func(ctx context.Context) *ScrapingResult {
+		result := s.fetchProducts(ctx, targets)
+		// La cobertura se registra una vez por consulta a Lider, aunque la compartan varios llamadores
+		if !result.Success {
+			recordExtractionFailure(ctx, endpointSearch, result.failure())
+		} else if products, err := convertToProducts(result.Data); err == nil {
+			recordProductCoverage(ctx, endpointSearch, result.Source, products)
+		}
+		return result
+	})
+	return result
+}
 
-	// Fallback: scraping de la página de búsqueda
-	searchURL := fmt.Sprintf("https://www.lider.cl/supermercado/search?query=%s", url.QueryEscape(query))
-	scrapingResult := s.scrapeSearchPage(searchURL)
-	if scrapingResult.Success {
-		scrapingResult.Source = "scraping"
-		return scrapingResult
+// fetchProducts ejecuta la búsqueda sin deduplicación, probando los endpoints del catálogo
+// en orden (por defecto la API interna y luego el scraping de la página de búsqueda)
+func (s *AdvancedScraper) fetchProducts(ctx context.Context, targets []upstreamTarget) (result *ScrapingResult) {
+	ctx, span := tracer.Start(ctx, "scraper.search")
+	defer func() { endResultSpan(span, result) }()
+
+	breakers := getCircuitBreakers()
+	var failures, skipped []string
+	var failure error
+	for _, target := range targets {
+		if !breakers.Allow(ctx, target.Name) {
+			skipped = append(skipped, target.Name)
+			continue
+		}
+		var attempt *ScrapingResult
+		if target.Kind == upstreamKindPage {
+			attempt = s.scrapeSearchPage(ctx, target.URL)
+		} else {
+			attempt = s.tryAPIEndpoint(ctx, target.URL)
+		}
+		breakers.Record(ctx, target.Name, attempt.err())
+		if attempt.Success {
+			attempt.Source = target.source()
+			return attempt
+		}
+		if ctx.Err() != nil {
+			return cancelledResult(ctx)
+		}
+		failures = append(failures, fmt.Sprintf("%s failed: %s", target.Name, attempt.Error))
+		failure = moreSpecific(failure, attempt.failure())
 	}
 
+	if failure == nil {
+		return circuitOpenResult(skipped)
+	}
 	return &ScrapingResult{
 		Success: false,
-		Error:   fmt.Sprintf("API failed: %s, Scraping failed: %s", apiResult.Error, scrapingResult.Error),
+		Error:   strings.Join(failures, ", "),
+		Err:     failure,
 		Source:  "none",
 	}
 }
 
 // FetchProductDetailAdvanced obtiene detalles de producto
-func (s *AdvancedScraper) FetchProductDetailAdvanced(sku string) *ScrapingResult {
+func (s *AdvancedScraper) FetchProductDetailAdvanced(ctx context.Context, sku string) *ScrapingResult {
 	if sku == "" {
 		return &ScrapingResult{
 			Success: false,
 			Error:   "SKU parameter cannot be empty",
+			Err:     newScraperError(ErrInvalidInput, "se requiere parámetro 'sku'"),
+		}
+	}
+
+	targets := getUpstreamEndpoints().targets(endpointDetail, sku, PageRequest{})
+	// Como en la búsqueda, la clave es la URL del primer endpoint: dos SKUs que Lider resuelve
+	// a la misma URL comparten la petición, y un catálogo recargado no mezcla resultados
+	result, _ := s.inflight.Do(ctx, "detail:"+normalizeUpstreamURL(targets[0].URL), func(ctx context.Context) *ScrapingResult {
+		result := s.fetchProductDetail(ctx, sku, targets)
+		// Una sola observación de precio y de cobertura por consulta a Lider, aunque la
+		// compartan varios llamadores
+		if !result.Success {
+			recordExtractionFailure(ctx, endpointDetail, result.failure())
+		} else if detail, err := convertToProductDetail(result.Data); err == nil {
+			result.Data = detail
+			recordDetailCoverage(ctx, endpointDetail, result.Source, detail)
+			recordPriceObservation(ctx, detail, result.Source)
+		}
+		return result
+	})
+	return result
+}
+
+// fetchProductDetail ejecuta la obtención del detalle sin deduplicación, probando los endpoints
+// del catálogo en orden (por defecto las APIs internas y luego la página del producto)
+func (s *AdvancedScraper) fetchProductDetail(ctx context.Context, sku string, targets []upstreamTarget) (result *ScrapingResult) {
+	ctx, span := tracer.Start(ctx, "scraper.product_detail", trace.WithAttributes(attribute.String("product.sku", sku)))
+	defer func() { endResultSpan(span, result) }()
+
+	breakers := getCircuitBreakers()
+	var skipped []string
+	var failure error
+	for _, target := range targets {
+		if !breakers.Allow(ctx, target.Name) {
+			skipped = append(skipped, target.Name)
+			continue
+		}
+		var attempt *ScrapingResult
+		if target.Kind == upstreamKindPage {
+			// Scraping de la página del producto
+			fallbackCtx, fallbackSpan := tracer.Start(ctx, "scraper.html_fallback", trace.WithAttributes(attribute.String("url.full", target.URL)))
+			attempt = s.scrapeProductPage(fallbackCtx, target.URL)
+			endResultSpan(fallbackSpan, attempt)
+		} else {
+			attempt = s.tryAPIEndpoint(ctx, target.URL)
+		}
+		breakers.Record(ctx, target.Name, attempt.err())
+		if attempt.Success {
+			attempt.Source = target.source()
+			return attempt
 		}
+		if ctx.Err() != nil {
+			return cancelledResult(ctx)
+		}
+		failure = moreSpecific(failure, attempt.failure())
 	}
 
-	// Intentar endpoints de API
-	apiEndpoints := []string{
-		fmt.Sprintf("https://apps.lider.cl/supermercado/product?sku=%s", sku),
-		fmt.Sprintf("https://apps.lider.cl/supermercado/product/%s", sku),
-		fmt.Sprintf("https://www.lider.cl/catalogo/api/products/%s", sku),
+	if failure == nil {
+		return circuitOpenResult(skipped)
 	}
-
-	for _, endpoint := range apiEndpoints {
-		result := s.tryAPIEndpoint(endpoint)
-		if result.Success {
-			result.Source = "api"
-			return result
-		}
+	return &ScrapingResult{
+		Success: false,
+		Error:   "all methods failed - product may not exist or be blocked",
+		Err:     failure,
+		Source:  "none",
 	}
+}
 
-	// Fallback: scraping de la página del producto
-	productURL := fmt.Sprintf("https://www.lider.cl/supermercado/product/sku/%s", sku)
-	scrapingResult := s.scrapeProductPage(productURL)
-	if scrapingResult.Success {
-		scrapingResult.Source = "scraping"
-		return scrapingResult
+// circuitOpenResult construye el resultado cuando todos los endpoints se saltaron por tener
+// el circuito abierto
+func circuitOpenResult(skipped []string) *ScrapingResult {
+	err := circuitOpenError(skipped)
+	return &ScrapingResult{
+		Success: false,
+		Error:   err.Error(),
+		Err:     err,
+		Source:  "none",
 	}
+}
 
+// cancelledResult construye el resultado para una operación cancelada o vencida
+func cancelledResult(ctx context.Context) *ScrapingResult {
 	return &ScrapingResult{
 		Success: false,
-		Error:   "all methods failed - product may not exist or be blocked",
+		Error:   fmt.Sprintf("operation cancelled: %v", ctx.Err()),
+		Err:     contextError(ctx),
 		Source:  "none",
 	}
 }
 
-// tryAPIEndpoint intenta hacer una petición a un endpoint de API
-func (s *AdvancedScraper) tryAPIEndpoint(endpoint string) *ScrapingResult {
+// tryAPIEndpoint intenta hacer una petición a un endpoint de API,
+// compartiendo el resultado entre llamadores concurrentes a la misma URL
+func (s *AdvancedScraper) tryAPIEndpoint(ctx context.Context, endpoint string) *ScrapingResult {
+	ctx, span := tracer.Start(ctx, "scraper.api_endpoint", trace.WithAttributes(attribute.String("url.full", endpoint)))
+	result, shared := s.inflight.Do(ctx, "api:"+normalizeUpstreamURL(endpoint), func(ctx context.Context) *ScrapingResult {
+		return s.requestAPIEndpoint(ctx, endpoint)
+	})
+	span.SetAttributes(attribute.Bool("scraper.shared", shared))
+	endResultSpan(span, result)
+
+	logger := loggerFrom(ctx).With("endpoint", endpoint, "shared", shared)
+	if result.Success {
+		logger.Debug("API endpoint succeeded")
+	} else {
+		logger.Info("API endpoint failed", "error", result.Error)
+	}
+	return result
+}
+
+// requestAPIEndpoint hace la petición al endpoint de API y parsea el JSON
+func (s *AdvancedScraper) requestAPIEndpoint(ctx context.Context, endpoint string) *ScrapingResult {
+	ctx = withUpstreamSource(ctx, "api")
 	headers := map[string]string{
 		"Accept": "application/json, text/plain, */*",
 	}
 
-	resp, body, err := s.makeRequest("GET", endpoint, headers)
+	resp, body, err := s.makeRequest(ctx, "GET", endpoint, headers)
 	if err != nil {
 		return &ScrapingResult{
 			Success: false,
 			Error:   err.Error(),
+			Err:     err,
 		}
 	}
 	defer resp.Body.Close()
@@ -250,6 +558,7 @@ func (s *AdvancedScraper) tryAPIEndpoint(endpoint string) *ScrapingResult {
 		return &ScrapingResult{
 			Success: false,
 			Error:   fmt.Sprintf("API returned status %d", resp.StatusCode),
+			Err:     statusError("API", resp.StatusCode),
 		}
 	}
 
@@ -259,6 +568,7 @@ func (s *AdvancedScraper) tryAPIEndpoint(endpoint string) *ScrapingResult {
 		return &ScrapingResult{
 			Success: false,
 			Error:   "failed to parse JSON response",
+			Err:     wrapScraperError(ErrParseFailure, err, "failed to parse JSON response"),
 		}
 	}
 
@@ -268,13 +578,24 @@ func (s *AdvancedScraper) tryAPIEndpoint(endpoint string) *ScrapingResult {
 	}
 }
 
-// scrapeSearchPage hace scraping de la página de búsqueda
-func (s *AdvancedScraper) scrapeSearchPage(searchURL string) *ScrapingResult {
-	resp, body, err := s.makeRequest("GET", searchURL, nil)
+// scrapeSearchPage hace scraping de la página de búsqueda,
+// compartiendo el resultado entre llamadores concurrentes a la misma URL
+func (s *AdvancedScraper) scrapeSearchPage(ctx context.Context, searchURL string) *ScrapingResult {
+	result, _ := s.inflight.Do(ctx, "page:"+normalizeUpstreamURL(searchURL), func(ctx context.Context) *ScrapingResult {
+		return s.requestSearchPage(ctx, searchURL)
+	})
+	return result
+}
+
+// requestSearchPage descarga la página de búsqueda y extrae los productos
+func (s *AdvancedScraper) requestSearchPage(ctx context.Context, searchURL string) *ScrapingResult {
+	ctx = withUpstreamSource(ctx, "scraping")
+	resp, body, err := s.makeRequest(ctx, "GET", searchURL, nil)
 	if err != nil {
 		return &ScrapingResult{
 			Success: false,
 			Error:   err.Error(),
+			Err:     err,
 		}
 	}
 	defer resp.Body.Close()
@@ -283,15 +604,17 @@ func (s *AdvancedScraper) scrapeSearchPage(searchURL string) *ScrapingResult {
 		return &ScrapingResult{
 			Success: false,
 			Error:   fmt.Sprintf("search page returned status %d", resp.StatusCode),
+			Err:     statusError("search page", resp.StatusCode),
 		}
 	}
 
 	// Extraer productos del HTML
-	products := s.extractProductsFromHTML(string(body))
+	products := s.extractProductsFromHTML(ctx, string(body))
 	if len(products) == 0 {
 		return &ScrapingResult{
 			Success: false,
 			Error:   "no products found in search results",
+			Err:     newScraperError(ErrParseFailure, "no products found in search results"),
 		}
 	}
 
@@ -302,12 +625,14 @@ func (s *AdvancedScraper) scrapeSearchPage(searchURL string) *ScrapingResult {
 }
 
 // scrapeProductPage hace scraping de la página de un producto específico
-func (s *AdvancedScraper) scrapeProductPage(productURL string) *ScrapingResult {
-	resp, body, err := s.makeRequest("GET", productURL, nil)
+func (s *AdvancedScraper) scrapeProductPage(ctx context.Context, productURL string) *ScrapingResult {
+	ctx = withUpstreamSource(ctx, "scraping")
+	resp, body, err := s.makeRequest(ctx, "GET", productURL, nil)
 	if err != nil {
 		return &ScrapingResult{
 			Success: false,
 			Error:   err.Error(),
+			Err:     err,
 		}
 	}
 	defer resp.Body.Close()
@@ -316,15 +641,17 @@ func (s *AdvancedScraper) scrapeProductPage(productURL string) *ScrapingResult {
 		return &ScrapingResult{
 			Success: false,
 			Error:   fmt.Sprintf("product page returned status %d", resp.StatusCode),
+			Err:     statusError("product page", resp.StatusCode),
 		}
 	}
 
 	// Extraer detalles del producto del HTML
-	product := s.extractProductDetailFromHTML(string(body))
+	product := s.extractProductDetailFromHTML(ctx, string(body))
 	if product == nil {
 		return &ScrapingResult{
 			Success: false,
 			Error:   "could not extract product details from page",
+			Err:     newScraperError(ErrParseFailure, "could not extract product details from page"),
 		}
 	}
 
@@ -334,227 +661,180 @@ func (s *AdvancedScraper) scrapeProductPage(productURL string) *ScrapingResult {
 	}
 }
 
-// extractProductsFromHTML extrae productos del HTML de búsqueda
-func (s *AdvancedScraper) extractProductsFromHTML(html string) []Product {
-	var products []Product
+// extractProductsFromHTML extrae productos del HTML de búsqueda probando, en el orden de las
+// reglas de extracción, el estado embebido (__INITIAL_STATE__ y __NEXT_DATA__), JSON-LD y el DOM
+func (s *AdvancedScraper) extractProductsFromHTML(ctx context.Context, body string) []Product {
+	doc, err := parseHTMLDocument(body)
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to parse search page", "error", err)
+		return nil
+	}
 
-	// Buscar datos JSON incrustados en el HTML
-	jsonRegex := regexp.MustCompile(`window\.__INITIAL_STATE__\s*=\s*({.+?});`)
-	matches := jsonRegex.FindStringSubmatch(html)
-
-	if len(matches) > 1 {
-		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(matches[1]), &data); err == nil {
-			// Extraer productos del estado inicial
-			if searchData, ok := data["search"].(map[string]interface{}); ok {
-				if results, ok := searchData["results"].([]interface{}); ok {
-					for _, item := range results {
-						if productMap, ok := item.(map[string]interface{}); ok {
-							product := s.mapToProduct(productMap)
-							if product.ID != "" {
-								products = append(products, product)
-							}
-						}
-					}
+	rules := getExtractionRules().Search
+	strategies := map[string]func() []Product{
+		"initial_state": func() []Product { return s.productsFromState(rules, doc.initialState()) },
+		"next_data":     func() []Product { return s.productsFromState(rules, doc.nextData()) },
+		"json_ld": func() []Product {
+			var products []Product
+			for _, obj := range doc.jsonLDProducts() {
+				if product := productFromJSONLD(obj); product.ID != "" && product.DisplayName != "" {
+					products = append(products, product)
 				}
 			}
-		}
-	}
-
-	// Fallback: extraer usando patrones HTML
-	if len(products) == 0 {
-		products = s.extractProductsFromHTMLPatterns(html)
+			return products
+		},
+		"dom": func() []Product { return productsFromDOM(rules, doc) },
 	}
 
-	return products
-}
-
-// extractProductDetailFromHTML extrae detalles de producto del HTML
-func (s *AdvancedScraper) extractProductDetailFromHTML(html string) *ProductDetail {
-	// Buscar datos JSON del producto
-	jsonRegex := regexp.MustCompile(`window\.__INITIAL_STATE__\s*=\s*({.+?});`)
-	matches := jsonRegex.FindStringSubmatch(html)
-
-	if len(matches) > 1 {
-		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(matches[1]), &data); err == nil {
-			if productData, ok := data["product"].(map[string]interface{}); ok {
-				return s.mapToProductDetail(productData)
-			}
+	for _, name := range rules.Strategies {
+		_, span := startExtractionSpan(ctx, name)
+		products := strategies[name]()
+		endExtractionSpan(span, len(products))
+		if len(products) > 0 {
+			loggerFrom(ctx).Debug("Extracted products from HTML", "strategy", name, "count", len(products))
+			return products
 		}
 	}
-
-	// Fallback: extraer usando patrones HTML
-	return s.extractProductDetailFromHTMLPatterns(html)
+	return nil
 }
 
-// mapToProduct convierte un map a Product
-func (s *AdvancedScraper) mapToProduct(data map[string]interface{}) Product {
-	product := Product{}
-
-	if id, ok := data["id"].(string); ok {
-		product.ID = id
-	}
-	if brand, ok := data["brand"].(string); ok {
-		product.Brand = brand
-	}
-	if desc, ok := data["description"].(string); ok {
-		product.Description = desc
+// extractProductDetailFromHTML extrae el detalle del producto probando, en el orden de las reglas
+// de extracción, el estado embebido, JSON-LD, OpenGraph y el DOM. Los campos faltantes se
+// completan con OpenGraph.
+func (s *AdvancedScraper) extractProductDetailFromHTML(ctx context.Context, body string) *ProductDetail {
+	doc, err := parseHTMLDocument(body)
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to parse product page", "error", err)
+		return nil
 	}
-	if name, ok := data["displayName"].(string); ok {
-		product.DisplayName = name
+
+	rules := getExtractionRules().Detail
+	openGraph := productDetailFromOpenGraph(doc.metas)
+	strategies := map[string]func() *ProductDetail{
+		"initial_state": func() *ProductDetail { return s.productDetailFromState(rules, doc.initialState()) },
+		"next_data":     func() *ProductDetail { return s.productDetailFromState(rules, doc.nextData()) },
+		"json_ld": func() *ProductDetail {
+			for _, obj := range doc.jsonLDProducts() {
+				if detail := productDetailFromJSONLD(obj); detail != nil {
+					return detail
+				}
+			}
+			return nil
+		},
+		"opengraph": func() *ProductDetail {
+			if !usableDetail(openGraph) {
+				return nil
+			}
+			return openGraph
+		},
+		"dom": func() *ProductDetail { return productDetailFromDOM(rules, doc) },
 	}
 
-	// Extraer precios
-	if priceData, ok := data["price"].(map[string]interface{}); ok {
-		if baseRef, ok := priceData["BasePriceReference"].(float64); ok {
-			product.Price.BasePriceReference = baseRef
-		}
-		if baseSales, ok := priceData["BasePriceSales"].(float64); ok {
-			product.Price.BasePriceSales = baseSales
+	for _, name := range rules.Strategies {
+		_, span := startExtractionSpan(ctx, name)
+		detail := strategies[name]()
+		if detail == nil {
+			endExtractionSpan(span, 0)
+			continue
 		}
-	}
+		endExtractionSpan(span, 1)
 
-	// Extraer imágenes
-	if imageData, ok := data["images"].(map[string]interface{}); ok {
-		if defaultImg, ok := imageData["defaultImage"].(string); ok {
-			product.Images.DefaultImage = defaultImg
+		if name != "opengraph" {
+			fillProductDetail(detail, openGraph)
 		}
-		if mediumImg, ok := imageData["mediumImage"].(string); ok {
-			product.Images.MediumImage = mediumImg
+		if detail.URL == "" && detail.SKU != "" {
+			detail.URL = getUpstreamEndpoints().productURL(detail.SKU)
 		}
+		loggerFrom(ctx).Debug("Extracted product detail from HTML", "strategy", name, "sku", detail.SKU)
+		return detail
 	}
-
-	return product
+	return nil
 }
 
-// mapToProductDetail convierte un map a ProductDetail
-func (s *AdvancedScraper) mapToProductDetail(data map[string]interface{}) *ProductDetail {
-	detail := &ProductDetail{}
-
-	if sku, ok := data["sku"].(string); ok {
-		detail.SKU = sku
-	}
-	if name, ok := data["name"].(string); ok {
-		detail.Name = name
-	}
-	if brand, ok := data["brand"].(string); ok {
-		detail.Brand = brand
-	}
-	if desc, ok := data["description"].(string); ok {
-		detail.Description = desc
-	}
+// productsFromState extrae los resultados de búsqueda de __INITIAL_STATE__ o de __NEXT_DATA__
+// usando las state_paths de las reglas
+func (s *AdvancedScraper) productsFromState(rules *compiledPageRules, state map[string]interface{}) []Product {
+	results, _ := rules.stateValue(state).([]interface{})
 
-	// Extraer precios
-	if priceData, ok := data["price"].(map[string]interface{}); ok {
-		if current, ok := priceData["current"].(float64); ok {
-			detail.Price.Current = current
-		}
-		if original, ok := priceData["original"].(float64); ok {
-			detail.Price.Original = original
-		}
-		detail.Price.Currency = "CLP"
-	}
-
-	// Extraer imágenes
-	if images, ok := data["images"].([]interface{}); ok {
-		for _, img := range images {
-			if imgStr, ok := img.(string); ok {
-				detail.Images = append(detail.Images, imgStr)
+	var products []Product
+	for _, item := range results {
+		if productMap, ok := item.(map[string]interface{}); ok {
+			product := s.mapToProduct(rules, productMap)
+			if product.ID != "" {
+				products = append(products, product)
 			}
 		}
 	}
+	return products
+}
 
-	if avail, ok := data["availability"].(bool); ok {
-		detail.Availability = avail
-	}
-	if stock, ok := data["stock"].(float64); ok {
-		detail.Stock = int(stock)
-	}
-	if rating, ok := data["rating"].(float64); ok {
-		detail.Rating = rating
-	}
-	if category, ok := data["category"].(string); ok {
-		detail.Category = category
+// productDetailFromState extrae el producto de __INITIAL_STATE__ o de __NEXT_DATA__
+// usando las state_paths de las reglas
+func (s *AdvancedScraper) productDetailFromState(rules *compiledPageRules, state map[string]interface{}) *ProductDetail {
+	productData, ok := rules.stateValue(state).(map[string]interface{})
+	if !ok {
+		return nil
 	}
-
-	detail.URL = fmt.Sprintf("https://www.lider.cl/supermercado/product/sku/%s", detail.SKU)
-
-	return detail
+	return s.mapToProductDetail(rules, productData)
 }
 
-// extractProductsFromHTMLPatterns extrae productos usando patrones HTML
-func (s *AdvancedScraper) extractProductsFromHTMLPatterns(html string) []Product {
-	var products []Product
-
-	// Patrones regex para extraer información básica
-	productRegex := regexp.MustCompile(`data-testid="product-item"[^>]*>(.*?)</div>`)
-	matches := productRegex.FindAllStringSubmatch(html, -1)
-
-	for _, match := range matches {
-		if len(match) > 1 {
-			productHTML := match[1]
-			product := Product{}
+// startExtractionSpan abre el span de una estrategia de extracción
+func startExtractionSpan(ctx context.Context, strategy string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "extract."+strategy, trace.WithAttributes(attribute.String("extract.strategy", strategy)))
+}
 
-			// Extraer ID del producto
-			if idMatch := regexp.MustCompile(`data-product-id="([^"]+)"`).FindStringSubmatch(productHTML); len(idMatch) > 1 {
-				product.ID = idMatch[1]
-			}
+// endExtractionSpan cierra el span de extracción con la cantidad de elementos encontrados
+func endExtractionSpan(span trace.Span, items int) {
+	span.SetAttributes(attribute.Int("extract.items", items))
+	span.End()
+}
 
-			// Extraer nombre
-			if nameMatch := regexp.MustCompile(`data-testid="product-title"[^>]*>([^<]+)`).FindStringSubmatch(productHTML); len(nameMatch) > 1 {
-				product.DisplayName = strings.TrimSpace(nameMatch[1])
-			}
+// mapToProduct convierte un map a Product según los campos de las reglas
+func (s *AdvancedScraper) mapToProduct(rules *compiledPageRules, data map[string]interface{}) Product {
+	product := Product{
+		ID:          valueString(rules.field(data, "id")),
+		Brand:       valueString(rules.field(data, "brand")),
+		Description: valueString(rules.field(data, "description")),
+		DisplayName: valueString(rules.field(data, "display_name")),
+	}
 
-			// Extraer precio
-			if priceMatch := regexp.MustCompile(`data-testid="product-price"[^>]*>.*?\$([0-9,.]+)`).FindStringSubmatch(productHTML); len(priceMatch) > 1 {
-				priceStr := strings.ReplaceAll(priceMatch[1], ".", "")
-				priceStr = strings.ReplaceAll(priceStr, ",", ".")
-				if price, err := strconv.ParseFloat(priceStr, 64); err == nil {
-					product.Price.BasePriceSales = price
-					product.Price.BasePriceReference = price
-				}
-			}
+	// Extraer precios
+	product.Price.BasePriceReference = valueNumber(rules.field(data, "price_reference"))
+	product.Price.BasePriceSales = valueNumber(rules.field(data, "price_sales"))
 
-			if product.ID != "" && product.DisplayName != "" {
-				products = append(products, product)
-			}
-		}
-	}
+	// Extraer imágenes
+	product.Images.DefaultImage = valueString(rules.field(data, "image_default"))
+	product.Images.MediumImage = valueString(rules.field(data, "image_medium"))
 
-	return products
+	return product
 }
 
-// extractProductDetailFromHTMLPatterns extrae detalles usando patrones HTML
-func (s *AdvancedScraper) extractProductDetailFromHTMLPatterns(html string) *ProductDetail {
-	detail := &ProductDetail{}
-
-	// Extraer SKU de la URL o metadatos
-	if skuMatch := regexp.MustCompile(`"sku":"([^"]+)"`).FindStringSubmatch(html); len(skuMatch) > 1 {
-		detail.SKU = skuMatch[1]
+// mapToProductDetail convierte un map a ProductDetail según los campos de las reglas
+func (s *AdvancedScraper) mapToProductDetail(rules *compiledPageRules, data map[string]interface{}) *ProductDetail {
+	detail := &ProductDetail{
+		SKU:         valueString(rules.field(data, "sku")),
+		Name:        valueString(rules.field(data, "name")),
+		Brand:       valueString(rules.field(data, "brand")),
+		Description: valueString(rules.field(data, "description")),
+		Images:      valueStrings(rules.field(data, "images")),
+		Stock:       int(valueNumber(rules.field(data, "stock"))),
+		Rating:      valueNumber(rules.field(data, "rating")),
+		Category:    valueString(rules.field(data, "category")),
 	}
 
-	// Extraer nombre del producto
-	if nameMatch := regexp.MustCompile(`<h1[^>]*>([^<]+)</h1>`).FindStringSubmatch(html); len(nameMatch) > 1 {
-		detail.Name = strings.TrimSpace(nameMatch[1])
+	// Extraer precios
+	current, original := rules.field(data, "price_current"), rules.field(data, "price_original")
+	detail.Price.Current = valueNumber(current)
+	detail.Price.Original = valueNumber(original)
+	if current != nil || original != nil {
+		detail.Price.Currency = firstNonEmpty(valueString(rules.field(data, "currency")), "CLP")
 	}
 
-	// Extraer precio
-	if priceMatch := regexp.MustCompile(`"price":\s*([0-9,.]+)`).FindStringSubmatch(html); len(priceMatch) > 1 {
-		priceStr := strings.ReplaceAll(priceMatch[1], ".", "")
-		priceStr = strings.ReplaceAll(priceStr, ",", ".")
-		if price, err := strconv.ParseFloat(priceStr, 64); err == nil {
-			detail.Price.Current = price
-			detail.Price.Original = price
-			detail.Price.Currency = "CLP"
-		}
+	if avail, ok := valueBool(rules.field(data, "availability")); ok {
+		detail.Availability = avail
 	}
 
-	// Si no pudimos extraer información básica, retornar nil
-	if detail.SKU == "" && detail.Name == "" {
-		return nil
-	}
+	detail.URL = getUpstreamEndpoints().productURL(detail.SKU)
 
-	detail.Availability = true
 	return detail
 }
@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tipos de condición soportados por las alertas
+const (
+	alertPriceBelow    = "price_below"
+	alertDiscountAbove = "discount_above"
+	alertBackInStock   = "back_in_stock"
+)
+
+// AlertCondition define cuándo se dispara una alerta
+type AlertCondition struct {
+	Type  string  `json:"type"`
+	Value float64 `json:"value,omitempty"`
+}
+
+// AlertSubscription es una alerta registrada para un SKU
+type AlertSubscription struct {
+	ID            string         `json:"id"`
+	SKU           string         `json:"sku"`
+	Condition     AlertCondition `json:"condition"`
+	WebhookURL    string         `json:"webhook_url"`
+	Secret        string         `json:"secret,omitempty"`
+	CreatedBy     string         `json:"created_by,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	LastCheckedAt *time.Time     `json:"last_checked_at,omitempty"`
+	LastTriggered *time.Time     `json:"last_triggered_at,omitempty"`
+	Tripped       bool           `json:"tripped"`
+	LastAvailable *bool          `json:"last_available,omitempty"`
+}
+
+// AlertPayload es el JSON enviado al webhook cuando una alerta se dispara
+type AlertPayload struct {
+	Event       string         `json:"event"`
+	AlertID     string         `json:"alert_id"`
+	SKU         string         `json:"sku"`
+	Condition   AlertCondition `json:"condition"`
+	Product     *ProductDetail `json:"product"`
+	TriggeredAt time.Time      `json:"triggered_at"`
+}
+
+// detailFetcher obtiene el detalle actual de un producto
+type detailFetcher func(ctx context.Context, sku string) (*ProductDetail, error)
+
+// AlertManager guarda las suscripciones, revisa periódicamente los SKUs y envía los webhooks
+type AlertManager struct {
+	mu            sync.RWMutex
+	path          string
+	subscriptions map[string]*AlertSubscription
+	fetch         detailFetcher
+	client        *http.Client
+	interval      time.Duration
+	retryDelays   []time.Duration
+	deliveries    sync.WaitGroup
+}
+
+// NewAlertManager crea el manager y carga las suscripciones guardadas en path (si existe).
+// Un path vacío mantiene las suscripciones solo en memoria.
+func NewAlertManager(path string, fetch detailFetcher, client *http.Client, interval time.Duration) (*AlertManager, error) {
+	m := &AlertManager{
+		path:          path,
+		subscriptions: make(map[string]*AlertSubscription),
+		fetch:         fetch,
+		client:        client,
+		interval:      interval,
+		retryDelays:   []time.Duration{1 * time.Second, 5 * time.Second, 30 * time.Second, 2 * time.Minute},
+	}
+
+	if path == "" {
+		return m, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, nil
+		}
+		return nil, fmt.Errorf("failed to read alerts file: %w", err)
+	}
+
+	var subscriptions []*AlertSubscription
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return nil, fmt.Errorf("failed to parse alerts file: %w", err)
+	}
+	for _, sub := range subscriptions {
+		m.subscriptions[sub.ID] = sub
+	}
+
+	return m, nil
+}
+
+// validateAlertCondition verifica que la condición sea conocida y tenga un valor razonable
+func validateAlertCondition(cond AlertCondition) error {
+	switch cond.Type {
+	case alertPriceBelow:
+		if cond.Value <= 0 {
+			return fmt.Errorf("'price_below' requiere un 'value' mayor a 0")
+		}
+	case alertDiscountAbove:
+		if cond.Value <= 0 || cond.Value >= 100 {
+			return fmt.Errorf("'discount_above' requiere un 'value' entre 0 y 100")
+		}
+	case alertBackInStock:
+	default:
+		return fmt.Errorf("tipo de condición desconocido '%s' (use price_below, discount_above o back_in_stock)", cond.Type)
+	}
+	return nil
+}
+
+// Add registra una nueva suscripción y genera su secreto de firma
+func (m *AlertManager) Add(sku string, cond AlertCondition, webhookURL, createdBy string) (*AlertSubscription, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &AlertSubscription{
+		ID:         id,
+		SKU:        sku,
+		Condition:  cond,
+		WebhookURL: webhookURL,
+		Secret:     secret,
+		CreatedBy:  createdBy,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	m.mu.Lock()
+	m.subscriptions[id] = sub
+	err = m.saveLocked()
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	copied := *sub
+	return &copied, nil
+}
+
+// List retorna las suscripciones creadas por owner (sin secretos) ordenadas por fecha de creación
+func (m *AlertManager) List(owner string) []AlertSubscription {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]AlertSubscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		if sub.CreatedBy != owner {
+			continue
+		}
+		copied := *sub
+		copied.Secret = ""
+		result = append(result, copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// Get retorna una suscripción (sin secreto) si la creó owner
+func (m *AlertManager) Get(id, owner string) (*AlertSubscription, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sub, ok := m.subscriptions[id]
+	if !ok || sub.CreatedBy != owner {
+		return nil, false
+	}
+	copied := *sub
+	copied.Secret = ""
+	return &copied, true
+}
+
+// Delete elimina una suscripción si la creó owner
+func (m *AlertManager) Delete(id, owner string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sub, ok := m.subscriptions[id]; !ok || sub.CreatedBy != owner {
+		return false, nil
+	}
+	delete(m.subscriptions, id)
+	return true, m.saveLocked()
+}
+
+// saveLocked escribe las suscripciones al archivo; requiere tener m.mu tomado
+func (m *AlertManager) saveLocked() error {
+	if m.path == "" {
+		return nil
+	}
+
+	subscriptions := make([]*AlertSubscription, 0, len(m.subscriptions))
+	for _, sub := range m.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	data, err := json.MarshalIndent(subscriptions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode alerts: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create alerts directory: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write alerts file: %w", err)
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// Start lanza el scheduler que revisa las alertas hasta que el contexto se cancele
+func (m *AlertManager) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.CheckAll(ctx)
+			}
+		}
+	}()
+}
+
+// CheckAll consulta una vez cada SKU suscrito y dispara las alertas cuya condición se cumple
+func (m *AlertManager) CheckAll(ctx context.Context) {
+	m.mu.RLock()
+	bySKU := make(map[string][]string)
+	for id, sub := range m.subscriptions {
+		bySKU[sub.SKU] = append(bySKU[sub.SKU], id)
+	}
+	m.mu.RUnlock()
+
+	for sku, ids := range bySKU {
+		if ctx.Err() != nil {
+			return
+		}
+
+		detail, err := m.fetch(ctx, sku)
+		if err != nil {
+			loggerFrom(ctx).Warn("Alert check failed", "sku", sku, "error", err)
+			continue
+		}
+
+		for _, id := range ids {
+			if payload := m.evaluate(id, detail); payload != nil {
+				m.deliveries.Add(1)
+				go func(payload *AlertPayload) {
+					defer m.deliveries.Done()
+					m.deliver(ctx, payload)
+				}(payload)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	if err := m.saveLocked(); err != nil {
+		loggerFrom(ctx).Error("Failed to save alerts", "error", err)
+	}
+	m.mu.Unlock()
+}
+
+// Wait bloquea hasta que terminen los envíos de webhooks en curso
+func (m *AlertManager) Wait() {
+	m.deliveries.Wait()
+}
+
+// evaluate actualiza el estado de la suscripción con el detalle observado y retorna
+// el payload a enviar si la condición pasó de no cumplirse a cumplirse
+func (m *AlertManager) evaluate(id string, detail *ProductDetail) *AlertPayload {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sub, ok := m.subscriptions[id]
+	if !ok {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	sub.LastCheckedAt = &now
+
+	var met bool
+	switch sub.Condition.Type {
+	case alertPriceBelow:
+		met = detail.Price.Current > 0 && detail.Price.Current < sub.Condition.Value
+	case alertDiscountAbove:
+		met = productDiscount(detail) > sub.Condition.Value
+	case alertBackInStock:
+		// Solo cuenta como "de vuelta" si antes lo vimos sin stock
+		met = detail.Availability && sub.LastAvailable != nil && !*sub.LastAvailable
+		available := detail.Availability
+		sub.LastAvailable = &available
+		if !detail.Availability {
+			sub.Tripped = false
+		}
+		if !met {
+			return nil
+		}
+	}
+
+	if !met {
+		sub.Tripped = false
+		return nil
+	}
+	if sub.Tripped {
+		// Ya se notificó y la condición sigue cumpliéndose
+		return nil
+	}
+
+	sub.Tripped = true
+	sub.LastTriggered = &now
+
+	return &AlertPayload{
+		Event:       "alert.triggered",
+		AlertID:     sub.ID,
+		SKU:         sub.SKU,
+		Condition:   sub.Condition,
+		Product:     detail,
+		TriggeredAt: now,
+	}
+}
+
+// deliver envía el payload firmado al webhook, reintentando con backoff ante fallas
+func (m *AlertManager) deliver(ctx context.Context, payload *AlertPayload) {
+	m.mu.RLock()
+	sub, ok := m.subscriptions[payload.AlertID]
+	var webhookURL, secret string
+	if ok {
+		webhookURL, secret = sub.WebhookURL, sub.Secret
+	}
+	m.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to encode alert payload", "alert_id", payload.AlertID, "error", err)
+		return
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < len(m.retryDelays)+1; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, m.retryDelays[attempt-1]); err != nil {
+				return
+			}
+		}
+
+		retryable, err := m.post(ctx, webhookURL, secret, body)
+		if err == nil {
+			loggerFrom(ctx).Info("Alert delivered", "alert_id", payload.AlertID, "webhook_url", webhookURL, "attempt", attempt+1)
+			return
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+
+	loggerFrom(ctx).Warn("Alert delivery failed", "alert_id", payload.AlertID, "webhook_url", webhookURL, "error", lastErr)
+}
+
+// post hace un intento de envío; retorna si vale la pena reintentar ante un error
+func (m *AlertManager) post(ctx context.Context, webhookURL, secret string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "LiderAPI-Alerts/1.0")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Alert-Timestamp", timestamp)
+	req.Header.Set("X-Alert-Signature", "sha256="+signPayload(secret, timestamp, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+}
+
+// signPayload calcula la firma HMAC-SHA256 (hex) de "<timestamp>.<body>" con el secreto de la
+// suscripción. Incluir el timestamp permite al receptor rechazar entregas antiguas repetidas.
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cgnatPrefix es el rango compartido de carrier-grade NAT (RFC 6598)
+var cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr indica si la dirección es enrutable en Internet: no es loopback, privada
+// (RFC 1918 / ULA), link-local, CGNAT, multicast ni no especificada
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() && !addr.IsMulticast() &&
+		!addr.IsUnspecified() && !cgnatPrefix.Contains(addr)
+}
+
+// validateWebhookURL verifica que el webhook sea una URL http(s) cuyo host resuelve solo a
+// direcciones públicas, para que las alertas no sirvan para llegar a la red interna del servidor
+func validateWebhookURL(ctx context.Context, raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("se requiere campo 'webhook_url' con una URL http(s) válida")
+	}
+	if allowPrivate {
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("no se pudo resolver el host de 'webhook_url'")
+	}
+	for _, addr := range addrs {
+		if !publicAddr(addr) {
+			return fmt.Errorf("'webhook_url' no puede apuntar a una dirección privada, local o reservada")
+		}
+	}
+	return nil
+}
+
+// webhookDialControl rechaza las conexiones de los webhooks a direcciones no públicas. Cubre
+// los cambios de DNS posteriores a la validación y las redirecciones.
+func webhookDialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !publicAddr(addrPort.Addr()) {
+		return fmt.Errorf("webhook address %s is not public", addrPort.Addr())
+	}
+	return nil
+}
+
+// newWebhookClient crea el cliente de los webhooks; sin allowPrivate solo se conecta a
+// direcciones públicas
+func newWebhookClient(allowPrivate bool) *http.Client {
+	if allowPrivate {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: webhookDialControl}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// productDiscount retorna el descuento informado o lo calcula desde los precios
+func productDiscount(detail *ProductDetail) float64 {
+	if detail.Price.Discount > 0 {
+		return detail.Price.Discount
+	}
+	if detail.Price.Original > 0 && detail.Price.Current > 0 && detail.Price.Original > detail.Price.Current {
+		return ((detail.Price.Original - detail.Price.Current) / detail.Price.Original) * 100
+	}
+	return 0
+}
+
+// randomHex genera n bytes aleatorios codificados en hexadecimal
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate random id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Global alert manager instance
+var (
+	alertManager     *AlertManager
+	alertManagerOnce sync.Once
+)
+
+// getAlertManager returns the singleton alert manager (nil if its store could not be loaded)
+func getAlertManager() *AlertManager {
+	alertManagerOnce.Do(func() {
+		path := os.Getenv("ALERTS_PATH")
+		if path == "" {
+			path = filepath.Join("data", "alerts.json")
+		}
+
+		interval := 15 * time.Minute
+		if raw := os.Getenv("ALERTS_CHECK_INTERVAL"); raw != "" {
+			if parsed, err := time.ParseDuration(raw); err == nil && parsed > 0 {
+				interval = parsed
+			} else {
+				slog.Warn("Invalid ALERTS_CHECK_INTERVAL, using default", "value", raw, "default", interval)
+			}
+		}
+
+		fetch := func(ctx context.Context, sku string) (*ProductDetail, error) {
+			detail, _, err := fetchProductDetailAdvanced(ctx, sku)
+			return detail, err
+		}
+
+		manager, err := NewAlertManager(path, fetch, newWebhookClient(alertsAllowPrivateWebhooks()), interval)
+		if err != nil {
+			slog.Error("Alerts disabled", "error", err)
+			return
+		}
+		alertManager = manager
+		slog.Info("Alert manager initialized", "path", path, "check_interval", interval)
+	})
+	return alertManager
+}
+
+// alertsAllowPrivateWebhooks indica si ALERTS_ALLOW_PRIVATE_WEBHOOKS permite webhooks en
+// direcciones locales o privadas (solo para desarrollo)
+func alertsAllowPrivateWebhooks() bool {
+	return strings.EqualFold(os.Getenv("ALERTS_ALLOW_PRIVATE_WEBHOOKS"), "true")
+}
+
+// createAlertRequest es el body esperado por POST /alerts
+type createAlertRequest struct {
+	SKU        string         `json:"sku"`
+	Condition  AlertCondition `json:"condition"`
+	WebhookURL string         `json:"webhook_url"`
+}
+
+// handleCreateAlert registra una nueva alerta
+func handleCreateAlert(c *gin.Context) {
+	manager := getAlertManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "alertas no disponibles", "code": ErrUnavailable})
+		return
+	}
+
+	var req createAlertRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "body JSON inválido",
+			"code":    ErrInvalidInput,
+			"example": `{"sku":"4522432","condition":{"type":"price_below","value":990},"webhook_url":"https://example.com/hook"}`,
+		})
+		return
+	}
+
+	if req.SKU == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "se requiere campo 'sku'", "code": ErrInvalidInput})
+		return
+	}
+	if err := validateAlertCondition(req.Condition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": ErrInvalidInput})
+		return
+	}
+	if err := validateWebhookURL(c.Request.Context(), req.WebhookURL, alertsAllowPrivateWebhooks()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": ErrInvalidInput})
+		return
+	}
+
+	sub, err := manager.Add(req.SKU, req.Condition, req.WebhookURL, apiKeyName(c))
+	if err != nil {
+		loggerFrom(c.Request.Context()).Error("Error creating alert", "sku", req.SKU, "key", apiKeyName(c), "error", err)
+		respondError(c, err)
+		return
+	}
+
+	// El secreto solo se entrega al crear la alerta
+	c.JSON(http.StatusCreated, sub)
+}
+
+// handleListAlerts lista las alertas registradas por la API key que consulta
+func handleListAlerts(c *gin.Context) {
+	manager := getAlertManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "alertas no disponibles", "code": ErrUnavailable})
+		return
+	}
+
+	alerts := manager.List(apiKeyName(c))
+	c.JSON(http.StatusOK, gin.H{
+		"count":  len(alerts),
+		"alerts": alerts,
+	})
+}
+
+// handleGetAlert retorna una alerta por ID; las de otras API keys responden 404
+func handleGetAlert(c *gin.Context) {
+	manager := getAlertManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "alertas no disponibles", "code": ErrUnavailable})
+		return
+	}
+
+	sub, ok := manager.Get(c.Param("id"), apiKeyName(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alerta no encontrada", "code": ErrNotFound})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+// handleDeleteAlert elimina una alerta por ID; las de otras API keys responden 404
+func handleDeleteAlert(c *gin.Context) {
+	manager := getAlertManager()
+	if manager == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "alertas no disponibles", "code": ErrUnavailable})
+		return
+	}
+
+	deleted, err := manager.Delete(c.Param("id"), apiKeyName(c))
+	if err != nil {
+		loggerFrom(c.Request.Context()).Error("Error deleting alert", "id", c.Param("id"), "key", apiKeyName(c), "error", err)
+		respondError(c, err)
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alerta no encontrada", "code": ErrNotFound})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookDelivery es un POST recibido por el webhook de prueba
+type webhookDelivery struct {
+	header http.Header
+	body   []byte
+}
+
+func TestAlertDeliveryIsSignedAndRetried(t *testing.T) {
+	var mu sync.Mutex
+	var deliveries []webhookDelivery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, webhookDelivery{header: r.Header.Clone(), body: body})
+		attempt := len(deliveries)
+		mu.Unlock()
+		if attempt == 1 {
+			// El primer intento falla: el envío se debe reintentar
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	price := 1200.0
+	fetch := func(ctx context.Context, sku string) (*ProductDetail, error) {
+		return &ProductDetail{SKU: sku, Price: DetailPrice{Current: price, Currency: "CLP"}, Availability: true}, nil
+	}
+	manager, err := NewAlertManager("", fetch, server.Client(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.retryDelays = []time.Duration{10 * time.Millisecond, 10 * time.Millisecond}
+
+	sub, err := manager.Add("4522432", AlertCondition{Type: alertPriceBelow, Value: 1000}, server.URL+"/hook", "mobile-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	manager.CheckAll(ctx)
+	manager.Wait()
+	if len(deliveries) != 0 {
+		t.Fatalf("got %d deliveries before the price dropped, want 0", len(deliveries))
+	}
+
+	price = 990
+	manager.CheckAll(ctx)
+	manager.Wait()
+	if len(deliveries) != 2 {
+		t.Fatalf("got %d delivery attempts, want 2 (one failure and one retry)", len(deliveries))
+	}
+
+	delivered := deliveries[1]
+	timestamp := delivered.header.Get("X-Alert-Timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Fatalf("X-Alert-Timestamp = %q, want the current unix time", timestamp)
+	}
+	want := "sha256=" + signPayload(sub.Secret, timestamp, delivered.body)
+	if got := delivered.header.Get("X-Alert-Signature"); got != want {
+		t.Errorf("X-Alert-Signature = %q, want %q", got, want)
+	}
+	if got := signPayload(sub.Secret, "0", delivered.body); "sha256="+got == want {
+		t.Error("signature does not depend on the timestamp")
+	}
+
+	var payload AlertPayload
+	if err := json.Unmarshal(delivered.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.AlertID != sub.ID || payload.Product.Price.Current != 990 {
+		t.Errorf("payload = %+v, want alert %s at price 990", payload, sub.ID)
+	}
+
+	// La condición sigue cumpliéndose: no se vuelve a notificar
+	manager.CheckAll(ctx)
+	manager.Wait()
+	if len(deliveries) != 2 {
+		t.Errorf("got %d delivery attempts after a repeated check, want 2", len(deliveries))
+	}
+}
+
+func TestAlertsAreScopedToTheirKey(t *testing.T) {
+	manager, err := NewAlertManager("", nil, http.DefaultClient, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := manager.Add("4522432", AlertCondition{Type: alertBackInStock}, "https://example.com/hook", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if alerts := manager.List("other"); len(alerts) != 0 {
+		t.Errorf("other key lists %d alerts, want 0", len(alerts))
+	}
+	if _, ok := manager.Get(sub.ID, "other"); ok {
+		t.Error("other key can read the alert")
+	}
+	if deleted, _ := manager.Delete(sub.ID, "other"); deleted {
+		t.Error("other key can delete the alert")
+	}
+
+	if alerts := manager.List("owner"); len(alerts) != 1 || alerts[0].Secret != "" {
+		t.Errorf("owner lists %+v, want the alert without its secret", alerts)
+	}
+	if deleted, err := manager.Delete(sub.ID, "owner"); err != nil || !deleted {
+		t.Errorf("owner delete = %v, %v, want true", deleted, err)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{"https://93.184.216.34/hook", false, false},
+		{"ftp://93.184.216.34/hook", false, true},
+		{"http://127.0.0.1:8080/hook", false, true},
+		{"http://localhost/hook", false, true},
+		{"http://10.0.0.5/hook", false, true},
+		{"http://192.168.1.10/hook", false, true},
+		{"http://169.254.169.254/latest/meta-data", false, true},
+		{"http://100.64.0.1/hook", false, true},
+		{"http://[::1]/hook", false, true},
+		{"http://[fd00::1]/hook", false, true},
+		{"http://0.0.0.0/hook", false, true},
+		{"http://127.0.0.1:8080/hook", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := validateWebhookURL(context.Background(), tt.url, tt.allowPrivate)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateWebhookURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhookClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	if _, err := newWebhookClient(false).Get(server.URL); err == nil {
+		t.Error("webhook client connected to a loopback address")
+	}
+	resp, err := newWebhookClient(true).Get(server.URL)
+	if err != nil {
+		t.Fatalf("webhook client with private webhooks allowed: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scopes disponibles para las API keys
+const (
+	scopeSearch = "search" // búsqueda, sugerencias, promociones y categorías
+	scopeDetail = "detail" // detalle e historial de productos
+	scopeAlerts = "alerts" // gestión de alertas de precio
+	scopeAdmin  = "admin"  // acceso a todos los endpoints
+)
+
+// ctxKeyAPIKeyName es la clave del gin.Context donde queda el nombre de la key autenticada
+const ctxKeyAPIKeyName = "apiKeyName"
+
+// routeScopes asocia cada ruta de gin con el scope requerido; las rutas no listadas requieren
+// admin y las que tienen scope vacío aceptan cualquier key válida
+var routeScopes = map[string]string{
+	"/productos":            scopeSearch,
+	"/productos/stream":     scopeSearch,
+	"/suggestions":          scopeSearch,
+	"/promotions":           scopeSearch,
+	"/categories":           scopeSearch,
+	"/categories/stream":    scopeSearch,
+	"/product":              scopeDetail,
+	"/product/:sku":         scopeDetail,
+	"/product/:sku/history": scopeDetail,
+	"/alerts":               scopeAlerts,
+	"/alerts/:id":           scopeAlerts,
+	"/queue/:id":            "", // solo la key que estacionó la solicitud ve su resultado
+}
+
+// keyHashScheme es el prefijo de los hashes generados por hashAPIKey
+const keyHashScheme = "sha256"
+
+// APIKey es una credencial registrada con su nombre, scopes y vigencia.
+// Se recomienda configurar KeyHash (generado con `lider-api genkey`) en lugar de Key en texto plano.
+type APIKey struct {
+	Name      string     `json:"name"`
+	Key       string     `json:"key,omitempty"`
+	KeyHash   string     `json:"key_hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Disabled  bool       `json:"disabled,omitempty"`
+
+	// Límites propios de la key; 0 usa los valores por defecto del servidor
+	RateLimitPerMinute float64 `json:"rate_limit_per_minute,omitempty"`
+	Burst              int     `json:"burst,omitempty"`
+	DailyQuota         int     `json:"daily_quota,omitempty"`
+}
+
+// Allows indica si la key tiene el scope pedido (admin permite todo)
+func (k *APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scopeAdmin || s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired indica si la key venció
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && now.After(*k.ExpiresAt)
+}
+
+// APIKeyRegistry contiene todas las keys aceptadas por la API
+type APIKeyRegistry struct {
+	keys []*APIKey
+}
+
+// apiKeyFile es el formato del archivo API_KEYS_FILE
+type apiKeyFile struct {
+	Keys []*APIKey `json:"keys"`
+}
+
+// loadAPIKeyRegistry arma el registro desde API_KEYS_FILE, API_KEYS (lista JSON)
+// y API_KEY_HASH o, por compatibilidad, API_KEY como key "default" con scope admin
+func loadAPIKeyRegistry() (*APIKeyRegistry, error) {
+	registry := &APIKeyRegistry{}
+
+	if path := os.Getenv("API_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API_KEYS_FILE: %w", err)
+		}
+		var file apiKeyFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse API_KEYS_FILE: %w", err)
+		}
+		registry.keys = append(registry.keys, file.Keys...)
+	}
+
+	if raw := os.Getenv("API_KEYS"); raw != "" {
+		var keys []*APIKey
+		if err := json.Unmarshal([]byte(raw), &keys); err != nil {
+			return nil, fmt.Errorf("failed to parse API_KEYS: %w", err)
+		}
+		registry.keys = append(registry.keys, keys...)
+	}
+
+	if keyHash := os.Getenv("API_KEY_HASH"); keyHash != "" {
+		registry.keys = append(registry.keys, &APIKey{
+			Name:    "default",
+			KeyHash: keyHash,
+			Scopes:  []string{scopeAdmin},
+		})
+	} else if apiKey := os.Getenv("API_KEY"); apiKey != "" {
+		registry.keys = append(registry.keys, &APIKey{
+			Name:   "default",
+			Key:    apiKey,
+			Scopes: []string{scopeAdmin},
+		})
+	}
+
+	if err := registry.validate(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// validate verifica que las keys tengan nombre, secreto y scopes conocidos
+func (r *APIKeyRegistry) validate() error {
+	if len(r.keys) == 0 {
+		return fmt.Errorf("no API keys configured (set API_KEY_HASH, API_KEY, API_KEYS or API_KEYS_FILE)")
+	}
+
+	names := make(map[string]bool)
+	for i, key := range r.keys {
+		if key.Name == "" {
+			return fmt.Errorf("API key #%d has no name", i+1)
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicate API key name '%s'", key.Name)
+		}
+		names[key.Name] = true
+
+		switch {
+		case key.KeyHash != "":
+			if _, _, err := parseKeyHash(key.KeyHash); err != nil {
+				return fmt.Errorf("API key '%s' has an invalid key_hash: %w", key.Name, err)
+			}
+			// Solo se compara contra el hash
+			key.Key = ""
+		case strings.TrimSpace(key.Key) != "":
+			// Las keys en texto plano se hashean al cargarlas para compararlas igual que las demás
+			hash, err := hashAPIKey(key.Key)
+			if err != nil {
+				return err
+			}
+			key.KeyHash = hash
+			key.Key = ""
+		default:
+			return fmt.Errorf("API key '%s' has no key or key_hash", key.Name)
+		}
+		if len(key.Scopes) == 0 {
+			return fmt.Errorf("API key '%s' has no scopes", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			switch scope {
+			case scopeSearch, scopeDetail, scopeAlerts, scopeAdmin:
+			default:
+				return fmt.Errorf("API key '%s' has unknown scope '%s'", key.Name, scope)
+			}
+		}
+	}
+	return nil
+}
+
+// Lookup busca la key que coincide con el valor recibido. Compara en tiempo constante
+// contra todas las keys registradas, sin cortar en la primera coincidencia.
+func (r *APIKeyRegistry) Lookup(value string) (*APIKey, bool) {
+	var match *APIKey
+	for _, key := range r.keys {
+		if verifyAPIKey(value, key.KeyHash) && match == nil {
+			match = key
+		}
+	}
+	return match, match != nil
+}
+
+// generateAPIKey crea una key aleatoria de 32 bytes en base64 URL-safe
+func generateAPIKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashAPIKey genera el hash "sha256$<salt>$<digest>" de una key con un salt aleatorio.
+// Las keys son secretos aleatorios de alta entropía, por lo que un SHA-256 con salt
+// es suficiente y mantiene barata la verificación en cada solicitud.
+func hashAPIKey(key string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	digest := keyDigest(salt, key)
+	return fmt.Sprintf("%s$%s$%s", keyHashScheme, hex.EncodeToString(salt), hex.EncodeToString(digest)), nil
+}
+
+// verifyAPIKey compara la key recibida con un hash en tiempo constante
+func verifyAPIKey(key, keyHash string) bool {
+	salt, expected, err := parseKeyHash(keyHash)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(keyDigest(salt, key), expected) == 1
+}
+
+// parseKeyHash separa salt y digest de un hash "sha256$<salt>$<digest>"
+func parseKeyHash(keyHash string) (salt, digest []byte, err error) {
+	parts := strings.Split(keyHash, "$")
+	if len(parts) != 3 || parts[0] != keyHashScheme {
+		return nil, nil, fmt.Errorf("expected format %s$<salt>$<digest>", keyHashScheme)
+	}
+	if salt, err = hex.DecodeString(parts[1]); err != nil || len(salt) == 0 {
+		return nil, nil, fmt.Errorf("invalid salt")
+	}
+	if digest, err = hex.DecodeString(parts[2]); err != nil || len(digest) != sha256.Size {
+		return nil, nil, fmt.Errorf("invalid digest")
+	}
+	return salt, digest, nil
+}
+
+// keyDigest calcula SHA-256(salt || key)
+func keyDigest(salt []byte, key string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(key))
+	return h.Sum(nil)
+}
+
+// requiredScope retorna el scope necesario para una ruta de gin
+func requiredScope(fullPath string) string {
+	if scope, ok := routeScopes[fullPath]; ok {
+		return scope
+	}
+	return scopeAdmin
+}
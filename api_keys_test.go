@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAPIKeyHashRoundTrip(t *testing.T) {
+	hash, err := hashAPIKey("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, keyHashScheme+"$") {
+		t.Errorf("hash = %q, want the %s scheme", hash, keyHashScheme)
+	}
+	if !verifyAPIKey("secret", hash) {
+		t.Error("the key does not verify against its own hash")
+	}
+	if verifyAPIKey("Secret", hash) || verifyAPIKey("", hash) {
+		t.Error("a wrong key verifies")
+	}
+
+	// El salt es aleatorio: la misma key produce hashes distintos que verifican igual
+	other, err := hashAPIKey("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == hash || !verifyAPIKey("secret", other) {
+		t.Errorf("second hash = %q, want a different salt that still verifies", other)
+	}
+}
+
+func TestParseKeyHashRejectsMalformedHashes(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"empty", ""},
+		{"plain key", "secret"},
+		{"unknown scheme", "md5$0011$" + digest},
+		{"missing digest", "sha256$0011"},
+		{"extra part", "sha256$0011$" + digest + "$00"},
+		{"empty salt", "sha256$$" + digest},
+		{"salt not hex", "sha256$zz$" + digest},
+		{"digest not hex", "sha256$0011$" + strings.Repeat("zz", 32)},
+		{"short digest", "sha256$0011$abcd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseKeyHash(tt.hash); err == nil {
+				t.Errorf("parseKeyHash(%q) accepted a malformed hash", tt.hash)
+			}
+			if verifyAPIKey("secret", tt.hash) {
+				t.Errorf("verifyAPIKey accepted %q", tt.hash)
+			}
+		})
+	}
+
+	if _, _, err := parseKeyHash("sha256$0011$" + digest); err != nil {
+		t.Errorf("well-formed hash rejected: %v", err)
+	}
+	registry := &APIKeyRegistry{keys: []*APIKey{{Name: "bad", KeyHash: "sha256$0011", Scopes: []string{scopeSearch}}}}
+	if err := registry.validate(); err == nil {
+		t.Error("registry accepted a key with a malformed key_hash")
+	}
+}
+
+// disabledAndExpiredKeys registra una key activa, una deshabilitada y una vencida
+func disabledAndExpiredKeys(t *testing.T) {
+	t.Helper()
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	t.Setenv("API_KEYS", fmt.Sprintf(`[
+		{"name":"active","key":"active-secret","scopes":["search"]},
+		{"name":"disabled","key":"disabled-secret","scopes":["search"],"disabled":true},
+		{"name":"expired","key":"expired-secret","scopes":["search"],"expires_at":%q}
+	]`, expired))
+	t.Setenv("API_KEY", "")
+	t.Setenv("API_KEY_HASH", "")
+	t.Setenv("API_KEYS_FILE", "")
+}
+
+func TestLookupReturnsDisabledAndExpiredKeys(t *testing.T) {
+	disabledAndExpiredKeys(t)
+	registry, err := loadAPIKeyRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lookup identifica la key; el middleware decide si está vigente
+	now := time.Now()
+	tests := []struct {
+		secret            string
+		name              string
+		disabled, expired bool
+	}{
+		{"active-secret", "active", false, false},
+		{"disabled-secret", "disabled", true, false},
+		{"expired-secret", "expired", false, true},
+	}
+	for _, tt := range tests {
+		key, ok := registry.Lookup(tt.secret)
+		if !ok || key.Name != tt.name || key.Disabled != tt.disabled || key.Expired(now) != tt.expired {
+			t.Errorf("Lookup(%q) = %+v, %v; want %s (disabled %v, expired %v)", tt.secret, key, ok, tt.name, tt.disabled, tt.expired)
+		}
+		if key != nil && key.Key != "" {
+			t.Errorf("key %s keeps its plain-text secret after loading", key.Name)
+		}
+	}
+	if _, ok := registry.Lookup("unknown"); ok {
+		t.Error("Lookup matched an unknown key")
+	}
+}
+
+func TestAuthMiddlewareRejectsDisabledAndExpiredKeys(t *testing.T) {
+	disabledAndExpiredKeys(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apiKeyAuthMiddleware())
+	router.GET("/productos", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		secret     string
+		wantStatus int
+		wantCode   string
+	}{
+		{"active-secret", http.StatusOK, ""},
+		{"disabled-secret", http.StatusForbidden, "api_key_disabled"},
+		{"expired-secret", http.StatusForbidden, "api_key_expired"},
+		{"unknown", http.StatusForbidden, "invalid_api_key"},
+		{"", http.StatusUnauthorized, "missing_api_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantCode, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/productos?q=leche", nil)
+			if tt.secret != "" {
+				req.Header.Set("X-API-Key", tt.secret)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body struct {
+				Code string `json:"code"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", body.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// captureStdout retorna lo que fn escribe en os.Stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+	fn()
+	w.Close()
+	return string(<-done)
+}
+
+func TestGenKeyOutput(t *testing.T) {
+	output := captureStdout(t, func() {
+		runGenKey([]string{"-name", "mobile-app", "-scopes", "search,detail"})
+	})
+
+	// Tres bloques: la key, su hash y la entrada JSON, cada valor en una línea con sangría
+	blocks := strings.Split(strings.TrimSpace(output), "\n\n")
+	if len(blocks) != 3 {
+		t.Fatalf("output has %d blocks, want 3:\n%s", len(blocks), output)
+	}
+	value := func(block, header string) string {
+		title, line, ok := strings.Cut(block, "\n")
+		if !ok || !strings.HasPrefix(title, header) || !strings.HasPrefix(line, "  ") {
+			t.Fatalf("block %q, want %q followed by an indented value", block, header)
+		}
+		return strings.TrimSpace(line)
+	}
+	key := value(blocks[0], "API key")
+	hash := value(blocks[1], "Hash (para API_KEY_HASH)")
+	entryJSON := value(blocks[2], "Entrada para API_KEYS_FILE / API_KEYS")
+
+	if !verifyAPIKey(key, hash) {
+		t.Errorf("the printed key %q does not verify against the printed hash %q", key, hash)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(entryJSON), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["name"] != "mobile-app" || entry["key_hash"] != hash {
+		t.Errorf("entry = %v, want name mobile-app and the printed hash", entry)
+	}
+	if scopes, _ := json.Marshal(entry["scopes"]); !bytes.Equal(scopes, []byte(`["search","detail"]`)) {
+		t.Errorf("entry scopes = %s, want [\"search\",\"detail\"]", scopes)
+	}
+	if _, ok := entry["key"]; ok || strings.Contains(entryJSON, key) {
+		t.Error("the config entry includes the plain-text key")
+	}
+
+	// La entrada se puede usar tal cual en API_KEYS
+	t.Setenv("API_KEYS", "["+entryJSON+"]")
+	t.Setenv("API_KEY", "")
+	t.Setenv("API_KEY_HASH", "")
+	t.Setenv("API_KEYS_FILE", "")
+	registry, err := loadAPIKeyRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, ok := registry.Lookup(key); !ok || found.Name != "mobile-app" {
+		t.Errorf("Lookup with the generated key = %+v, %v; want mobile-app", found, ok)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"container/list"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache es la interfaz que debe implementar cualquier backend de cache
+type ResponseCache interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+}
+
+// CacheEntry contiene un valor cacheado junto con su fecha de almacenamiento
+type CacheEntry struct {
+	Value     interface{}
+	StoredAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Age retorna el tiempo transcurrido desde que se guardó la entrada
+func (e *CacheEntry) Age() time.Duration {
+	return time.Since(e.StoredAt)
+}
+
+// FetchMeta describe de dónde vienen los datos entregados por los wrappers
+type FetchMeta struct {
+	Source   string        // "api", "scraping", "cache"
+	CacheAge time.Duration // solo relevante cuando Source es "cache"
+}
+
+// defaultCacheTTLs define cuánto vive cada tipo de respuesta en cache
+var defaultCacheTTLs = map[string]time.Duration{
+	endpointSearch:     5 * time.Minute,
+	endpointDetail:     15 * time.Minute,
+	endpointPromotions: 10 * time.Minute,
+	endpointCategory:   10 * time.Minute,
+}
+
+// MemoryCache es un cache en memoria con expiración por TTL y un máximo de entradas: al
+// llenarse descarta la entrada usada hace más tiempo (LRU)
+type MemoryCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List // la entrada usada más recientemente va al frente
+	maxEntries int
+	now        func() time.Time
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// memoryCacheItem es el valor de cada elemento de la lista LRU
+type memoryCacheItem struct {
+	key   string
+	entry *CacheEntry
+}
+
+// NewMemoryCache crea un cache en memoria de hasta maxEntries entradas (0: sin límite) y lanza
+// la limpieza periódica de entradas vencidas, que se detiene con Close
+func NewMemoryCache(cleanupInterval time.Duration, maxEntries int) *MemoryCache {
+	c := &MemoryCache{
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		maxEntries: maxEntries,
+		now:        time.Now,
+		stop:       make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cleanupInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-c.stop:
+					return
+				case <-ticker.C:
+					c.deleteExpired()
+				}
+			}
+		}()
+	}
+
+	return c
+}
+
+// Get retorna la entrada si existe y no ha expirado
+func (c *MemoryCache) Get(key string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*memoryCacheItem)
+	if c.now().After(item.entry.ExpiresAt) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return item.entry, true
+}
+
+// Set guarda un valor con el TTL indicado
+func (c *MemoryCache) Set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	now := c.now()
+	entry := &CacheEntry{
+		Value:     value,
+		StoredAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*memoryCacheItem).entry = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&memoryCacheItem{key: key, entry: entry})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete elimina una entrada del cache
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+}
+
+// Len retorna cuántas entradas guarda el cache, incluidas las vencidas que aún no se limpian
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Close detiene la limpieza periódica
+func (c *MemoryCache) Close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// remove quita un elemento de la lista y del mapa. Requiere mu.
+func (c *MemoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*memoryCacheItem).key)
+}
+
+// deleteExpired elimina todas las entradas vencidas
+func (c *MemoryCache) deleteExpired() {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, element := range c.entries {
+		if now.After(element.Value.(*memoryCacheItem).entry.ExpiresAt) {
+			c.remove(element)
+		}
+	}
+}
+
+// Global response cache instance
+var (
+	responseCache     ResponseCache
+	responseCacheTTLs map[string]time.Duration
+	cacheOnce         sync.Once
+)
+
+// getResponseCache returns the singleton response cache, creating the in-memory default if none was set
+func getResponseCache() ResponseCache {
+	cacheOnce.Do(func() {
+		responseCacheTTLs = loadCacheTTLs()
+		maxEntries := 10000
+		if raw := os.Getenv("CACHE_MAX_ENTRIES"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+				maxEntries = n
+			} else {
+				slog.Warn("Invalid CACHE_MAX_ENTRIES, using default", "value", raw, "default", maxEntries)
+			}
+		}
+		if responseCache == nil {
+			responseCache = NewMemoryCache(time.Minute, maxEntries)
+		}
+		slog.Info("Response cache initialized", "ttls", responseCacheTTLs, "max_entries", maxEntries)
+	})
+	return responseCache
+}
+
+// setResponseCache replaces the cache backend; must be called before the first request
+func setResponseCache(cache ResponseCache) {
+	responseCache = cache
+}
+
+// closeResponseCache detiene las tareas en segundo plano del backend de cache, si tiene
+func closeResponseCache() {
+	if closer, ok := getResponseCache().(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// loadCacheTTLs lee los TTL desde variables de entorno CACHE_TTL_<ENDPOINT>
+// (por ejemplo CACHE_TTL_DETAIL=30m). CACHE_DISABLED=true desactiva el cache.
+func loadCacheTTLs() map[string]time.Duration {
+	if os.Getenv("CACHE_DISABLED") == "true" {
+		return make(map[string]time.Duration)
+	}
+	return loadEndpointDurations("CACHE_TTL_", defaultCacheTTLs)
+}
+
+// cacheKey construye la clave de cache para un endpoint y sus parámetros. Los SKUs y los ids
+// de categoría se usan tal cual (sin los espacios de los extremos); los textos de búsqueda se
+// normalizan antes con cacheQuery.
+func cacheKey(endpoint string, parts ...string) string {
+	normalized := make([]string, 0, len(parts)+1)
+	normalized = append(normalized, endpoint)
+	for _, part := range parts {
+		normalized = append(normalized, strings.TrimSpace(part))
+	}
+	return strings.Join(normalized, ":")
+}
+
+// cacheQuery normaliza un texto de búsqueda libre para la clave de cache: la búsqueda de Lider
+// no distingue mayúsculas, así que "Leche" y "leche" comparten la entrada
+func cacheQuery(query string) string {
+	return strings.ToLower(strings.TrimSpace(query))
+}
+
+// cacheLookup busca una clave en el cache de respuestas y registra el hit o miss. Retorna una
+// copia del valor, para que el llamador lo pueda modificar sin cambiar lo cacheado.
+func cacheLookup(endpoint, key string) (*CacheEntry, bool) {
+	entry, ok := getResponseCache().Get(key)
+	recordCacheLookup(endpoint, ok)
+	if !ok {
+		return nil, false
+	}
+	copied := *entry
+	copied.Value = copyCacheValue(entry.Value)
+	return &copied, true
+}
+
+// cacheStore guarda una copia del valor usando el TTL configurado para el endpoint
+func cacheStore(endpoint, key string, value interface{}) {
+	cache := getResponseCache()
+	cache.Set(key, copyCacheValue(value), responseCacheTTLs[endpoint])
+}
+
+// copyCacheValue copia las páginas y los detalles que guardan los wrappers; como en
+// copyScrapingResult, ningún llamador comparte (ni muta) el valor de otro
+func copyCacheValue(value interface{}) interface{} {
+	if page, ok := value.(*ProductPage); ok && page != nil {
+		copied := *page
+		copied.Products = slices.Clone(page.Products)
+		return &copied
+	}
+	return copyResultData(value)
+}
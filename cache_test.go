@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testMemoryCache crea un cache sin limpieza periódica con un reloj controlado por la prueba
+func testMemoryCache(t *testing.T, maxEntries int) (*MemoryCache, *time.Time) {
+	t.Helper()
+	cache := NewMemoryCache(0, maxEntries)
+	t.Cleanup(cache.Close)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return cache, &now
+}
+
+// useResponseCache reemplaza el cache de respuestas y sus TTL mientras dura la prueba
+func useResponseCache(t *testing.T, cache ResponseCache, ttls map[string]time.Duration) {
+	t.Helper()
+	getResponseCache()
+	previous, previousTTLs := responseCache, responseCacheTTLs
+	responseCache, responseCacheTTLs = cache, ttls
+	t.Cleanup(func() { responseCache, responseCacheTTLs = previous, previousTTLs })
+}
+
+func TestMemoryCacheExpiresEntries(t *testing.T) {
+	cache, now := testMemoryCache(t, 0)
+	cache.Set("search:leche", "short", time.Minute)
+	cache.Set("detail:4522432", "long", 15*time.Minute)
+	cache.Set("search:pan", "never", 0)
+
+	if _, ok := cache.Get("search:pan"); ok {
+		t.Error("value stored with a zero TTL")
+	}
+	*now = now.Add(time.Minute)
+	if entry, ok := cache.Get("search:leche"); !ok || entry.Value != "short" {
+		t.Fatal("entry expired before its TTL")
+	}
+
+	*now = now.Add(time.Second)
+	if _, ok := cache.Get("search:leche"); ok {
+		t.Error("entry served after its TTL")
+	}
+	if _, ok := cache.Get("detail:4522432"); !ok {
+		t.Error("entry with a longer TTL expired")
+	}
+
+	*now = now.Add(15 * time.Minute)
+	cache.deleteExpired()
+	if cache.Len() != 0 {
+		t.Errorf("%d entries left after the cleanup, want 0", cache.Len())
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, _ := testMemoryCache(t, 2)
+	cache.Set("a", 1, time.Minute)
+	cache.Set("b", 2, time.Minute)
+	cache.Get("a")
+	cache.Set("c", 3, time.Minute)
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("least recently used entry was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("entry %s was evicted", key)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("cache has %d entries, want 2", cache.Len())
+	}
+}
+
+func TestCacheTTLsPerEndpoint(t *testing.T) {
+	t.Setenv("CACHE_DISABLED", "")
+	t.Setenv("CACHE_TTL_DETAIL", "30m")
+	t.Setenv("CACHE_TTL_SEARCH", "nope")
+
+	ttls := loadCacheTTLs()
+	want := map[string]time.Duration{
+		endpointSearch:     5 * time.Minute,
+		endpointDetail:     30 * time.Minute,
+		endpointPromotions: 10 * time.Minute,
+		endpointCategory:   10 * time.Minute,
+	}
+	for endpoint, ttl := range want {
+		if ttls[endpoint] != ttl {
+			t.Errorf("TTL of %s = %v, want %v", endpoint, ttls[endpoint], ttl)
+		}
+	}
+
+	cache, now := testMemoryCache(t, 0)
+	useResponseCache(t, cache, ttls)
+	cacheStore(endpointSearch, "search:leche", &ProductPage{})
+	cacheStore(endpointDetail, "detail:4522432", &ProductDetail{})
+	*now = now.Add(10 * time.Minute)
+	if _, ok := cacheLookup(endpointSearch, "search:leche"); ok {
+		t.Error("search entry outlived CACHE_TTL_SEARCH")
+	}
+	if _, ok := cacheLookup(endpointDetail, "detail:4522432"); !ok {
+		t.Error("detail entry expired before CACHE_TTL_DETAIL")
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	t.Setenv("CACHE_DISABLED", "true")
+	t.Setenv("CACHE_TTL_DETAIL", "30m")
+
+	cache, _ := testMemoryCache(t, 0)
+	useResponseCache(t, cache, loadCacheTTLs())
+	cacheStore(endpointDetail, "detail:4522432", &ProductDetail{SKU: "4522432"})
+	if _, ok := cacheLookup(endpointDetail, "detail:4522432"); ok || cache.Len() != 0 {
+		t.Error("value cached with CACHE_DISABLED=true")
+	}
+}
+
+func TestCacheLookupReturnsCopies(t *testing.T) {
+	cache, _ := testMemoryCache(t, 0)
+	useResponseCache(t, cache, map[string]time.Duration{endpointSearch: time.Minute, endpointDetail: time.Minute})
+
+	page := &ProductPage{Products: []Product{{ID: "1", DisplayName: "Leche"}}, Page: 1}
+	cacheStore(endpointSearch, "search:leche", page)
+	page.Products[0].DisplayName = "changed after storing"
+
+	entry, _ := cacheLookup(endpointSearch, "search:leche")
+	got := entry.Value.(*ProductPage)
+	got.Products[0].DisplayName = "changed by a caller"
+	got.Page = 2
+
+	entry, _ = cacheLookup(endpointSearch, "search:leche")
+	if again := entry.Value.(*ProductPage); again.Products[0].DisplayName != "Leche" || again.Page != 1 {
+		t.Errorf("cached page = %+v, want the stored value unchanged", again)
+	}
+
+	cacheStore(endpointDetail, "detail:1", &ProductDetail{SKU: "1", Images: []string{"a.jpg"}})
+	entry, _ = cacheLookup(endpointDetail, "detail:1")
+	entry.Value.(*ProductDetail).Images[0] = "changed.jpg"
+	entry, _ = cacheLookup(endpointDetail, "detail:1")
+	if images := entry.Value.(*ProductDetail).Images; images[0] != "a.jpg" {
+		t.Errorf("cached images = %v, want [a.jpg]", images)
+	}
+}
+
+func TestCacheKeyFoldsOnlyQueries(t *testing.T) {
+	if cacheKey(endpointSearch, cacheQuery(" Leche ")) != cacheKey(endpointSearch, cacheQuery("leche")) {
+		t.Error("search queries that differ in case use different keys")
+	}
+	if cacheKey(endpointCategory, "Lacteos") == cacheKey(endpointCategory, "lacteos") {
+		t.Error("category ids that differ in case share a key")
+	}
+	if got := cacheKey(endpointDetail, " 4522432 "); got != "detail:4522432" {
+		t.Errorf("cacheKey = %q, want detail:4522432", got)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Modos del transport de grabación y reproducción (UPSTREAM_CASSETTE_MODE)
+const (
+	cassetteOff    = ""
+	cassetteRecord = "record" // hace las peticiones reales y guarda cada intercambio
+	cassetteReplay = "replay" // responde solo con lo grabado, sin red
+)
+
+// cassetteExchange es un intercambio grabado con Lider; se guarda como un archivo JSON
+type cassetteExchange struct {
+	RecordedAt time.Time        `json:"recorded_at"`
+	Request    cassetteRequest  `json:"request"`
+	Response   cassetteResponse `json:"response"`
+}
+
+// cassetteRequest identifica la petición grabada
+type cassetteRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// cassetteResponse es la respuesta grabada. El body va como texto si es UTF-8 válido
+// (HTML y JSON) y en base64 si no (por ejemplo, comprimido).
+type cassetteResponse struct {
+	Status     int                 `json:"status"`
+	Header     map[string][]string `json:"header"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"`
+}
+
+// cassette guarda y lee los intercambios de un directorio
+type cassette struct {
+	mode string
+	dir  string
+	mu   sync.Mutex
+}
+
+// Global cassette instance
+var (
+	upstreamCassette     *cassette
+	upstreamCassetteOnce sync.Once
+)
+
+// getCassette returns the cassette configured by UPSTREAM_CASSETTE_MODE and
+// UPSTREAM_CASSETTE_DIR (nil when recording and replay are disabled)
+func getCassette() *cassette {
+	upstreamCassetteOnce.Do(func() {
+		mode := strings.ToLower(strings.TrimSpace(os.Getenv("UPSTREAM_CASSETTE_MODE")))
+		switch mode {
+		case cassetteOff, "off":
+			return
+		case cassetteRecord, cassetteReplay:
+		default:
+			slog.Error("Invalid UPSTREAM_CASSETTE_MODE value, expected record, replay or off", "value", mode)
+			os.Exit(1)
+		}
+
+		dir := os.Getenv("UPSTREAM_CASSETTE_DIR")
+		if dir == "" {
+			dir = filepath.Join("testdata", "cassettes")
+		}
+		if mode == cassetteRecord {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				slog.Error("Failed to create cassette directory", "dir", dir, "error", err)
+				os.Exit(1)
+			}
+		}
+
+		upstreamCassette = &cassette{mode: mode, dir: dir}
+		slog.Info("Upstream cassette enabled", "mode", mode, "dir", dir)
+	})
+	return upstreamCassette
+}
+
+// cassetteReplaying indica si las peticiones a Lider se responden desde cassettes
+func cassetteReplaying() bool {
+	c := getCassette()
+	return c != nil && c.mode == cassetteReplay
+}
+
+// upstreamTransport envuelve el transport hacia Lider para que respete UPSTREAM_CASSETTE_MODE
+// y UPSTREAM_MOCK_URL. Ambos se leen en la primera petición, así que los clientes se pueden
+// crear al iniciar el paquete. Las cassettes se graban con la URL original de Lider, incluso
+// cuando la petición la atiende el mock.
+func upstreamTransport(base http.RoundTripper) http.RoundTripper {
+	return &cassetteTransport{base: &mockRedirectTransport{base: base}}
+}
+
+// setUpstreamTransport reemplaza el transport de httpClient y del AdvancedScraper (por ejemplo,
+// con un fake en pruebas). El AdvancedScraper se crea de nuevo con rt para que su pool de
+// sesiones, que copia el cliente al crear cada sesión, también lo use; el anterior se cierra.
+// Las métricas de upstream se siguen registrando. Se debe llamar antes de atender solicitudes.
+func setUpstreamTransport(rt http.RoundTripper) {
+	httpClient.Transport = &instrumentedTransport{base: rt}
+	previous := advancedScraper
+	scraperOnce.Do(func() {})
+	advancedScraper = NewAdvancedScraper(rt)
+	if previous != nil {
+		previous.Close()
+	}
+}
+
+// path retorna el archivo del intercambio: host y hash del método más la URL normalizada
+func (c *cassette) path(method, rawURL string) string {
+	key := method + " " + normalizeUpstreamURL(rawURL)
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, fmt.Sprintf("%s_%s.json", requestHost(rawURL), hex.EncodeToString(sum[:6])))
+}
+
+// load lee el intercambio grabado para la petición
+func (c *cassette) load(req *http.Request) (*cassetteExchange, error) {
+	data, err := os.ReadFile(c.path(req.Method, req.URL.String()))
+	if err != nil {
+		return nil, err
+	}
+	var exchange cassetteExchange
+	if err := json.Unmarshal(data, &exchange); err != nil {
+		return nil, fmt.Errorf("invalid cassette: %w", err)
+	}
+	return &exchange, nil
+}
+
+// save graba el intercambio (reemplaza una grabación anterior de la misma petición)
+func (c *cassette) save(req *http.Request, resp *http.Response, body []byte) error {
+	exchange := cassetteExchange{
+		RecordedAt: time.Now().UTC(),
+		Request: cassetteRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+		},
+		Response: cassetteResponse{
+			Status: resp.StatusCode,
+			Header: resp.Header,
+		},
+	}
+	if utf8.Valid(body) {
+		exchange.Response.Body = string(body)
+	} else {
+		exchange.Response.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(req.Method, req.URL.String())
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// response reconstruye la respuesta grabada para la petición
+func (e *cassetteExchange) response(req *http.Request) (*http.Response, error) {
+	body := []byte(e.Response.Body)
+	if e.Response.BodyBase64 != "" {
+		decoded, err := base64.StdEncoding.DecodeString(e.Response.BodyBase64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cassette body: %w", err)
+		}
+		body = decoded
+	}
+
+	header := http.Header{}
+	for key, values := range e.Response.Header {
+		header[http.CanonicalHeaderKey(key)] = values
+	}
+	header.Del("Content-Length")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Response.Status, http.StatusText(e.Response.Status)),
+		StatusCode:    e.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// cassetteTransport graba (record) o reproduce (replay) los intercambios con Lider.
+// Cada salto de una redirección se graba por separado, así que las redirecciones a
+// queue-it también se reproducen.
+type cassetteTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip responde desde la cassette en replay, o hace la petición y la graba en record.
+// Sin UPSTREAM_CASSETTE_MODE delega en el transport base.
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := getCassette()
+	if c == nil {
+		return t.base.RoundTrip(req)
+	}
+	if c.mode == cassetteReplay {
+		return c.replay(req)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if err := c.save(req, resp, body); err != nil {
+		loggerFrom(req.Context()).Warn("Failed to record upstream exchange", "url", req.URL.String(), "error", err)
+	}
+	return resp, nil
+}
+
+// replay retorna la respuesta grabada. Una petición sin grabación responde 404 para que
+// los fallbacks sigan sin reintentos ni red.
+func (c *cassette) replay(req *http.Request) (*http.Response, error) {
+	exchange, err := c.load(req)
+	if err == nil {
+		return exchange.response(req)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to replay %s %s: %w", req.Method, req.URL, err)
+	}
+	loggerFrom(req.Context()).Warn("No recorded upstream exchange, responding 404", "method", req.Method, "url", req.URL.String(),
+		"cassette", c.path(req.Method, req.URL.String()))
+
+	missing := &cassetteExchange{
+		Response: cassetteResponse{
+			Status: http.StatusNotFound,
+			Header: map[string][]string{"Content-Type": {"text/plain; charset=utf-8"}},
+			Body:   "no recorded exchange for " + req.Method + " " + req.URL.String(),
+		},
+	}
+	return missing.response(req)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cassetteRouter registra los handlers de la API sin autenticación
+func cassetteRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/productos", handleSearch)
+	router.GET("/suggestions", handleSuggestions)
+	router.GET("/promotions", handlePromotions)
+	router.GET("/categories", handleCategories)
+	router.GET("/productos/stream", handleSearchStream)
+	router.GET("/product/:sku", handleProductDetail)
+	router.GET("/product/:sku/history", handleProductHistory)
+	router.GET("/product", handleProductDetail)
+	return router
+}
+
+// Las cassettes de testdata/cassettes se grabaron con UPSTREAM_CASSETTE_MODE=record contra
+// mock-upstream; la búsqueda de "arroz" se grabó con un 500 inyectado en la API de búsqueda.
+// Los casos corren en orden: el historial lee la observación que dejan las consultas de detalle.
+func TestHandlersReplayCassettes(t *testing.T) {
+	router := cassetteRouter()
+
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       map[string]interface{}
+	}{
+		{"/productos?q=leche", http.StatusOK, map[string]interface{}{"count": 2.0, "source": "api", "first": "4522432"}},
+		{"/productos?q=arroz", http.StatusOK, map[string]interface{}{"count": 1.0, "source": "scraping", "first": "789012"}},
+		{"/promotions?type=descuentos", http.StatusOK, map[string]interface{}{"count": 5.0, "source": "api"}},
+		{"/categories?id=lacteos", http.StatusOK, map[string]interface{}{"count": 3.0, "category_id": "lacteos"}},
+		{"/suggestions?term=lec", http.StatusOK, map[string]interface{}{"count": 2.0}},
+		{"/product/4522432", http.StatusOK, map[string]interface{}{"sku": "4522432", "name": "Leche Entera Colun 1 L"}},
+		{"/product/0000000", http.StatusNotFound, map[string]interface{}{"code": string(ErrNotFound)}},
+		{"/product?url=https://www.lider.cl/supermercado/product/sku/4522432/leche-entera", http.StatusOK, map[string]interface{}{"sku": "4522432", "source": "api"}},
+		{"/product/4522432/history", http.StatusOK, map[string]interface{}{"sku": "4522432", "last_current": 990.0}},
+		{"/productos/stream?q=leche&per_page=1", http.StatusOK, map[string]interface{}{"type": "end", "items": 2.0, "pages": 2.0, "complete": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("GET", tt.target, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			// Los streams NDJSON terminan con la línea de resumen
+			lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+			var body map[string]interface{}
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &body); err != nil {
+				t.Fatal(err)
+			}
+			if products, ok := body["products"].([]interface{}); ok && len(products) > 0 {
+				body["first"] = products[0].(map[string]interface{})["ID"]
+			}
+			if observations, ok := body["observations"].([]interface{}); ok && len(observations) > 0 {
+				body["last_current"] = observations[len(observations)-1].(map[string]interface{})["current"]
+			}
+			for key, want := range tt.want {
+				if body[key] != want {
+					t.Errorf("%s = %v, want %v", key, body[key], want)
+				}
+			}
+		})
+	}
+}
+
+// countingTransport responde 200 a todo y cuenta las peticiones
+type countingTransport struct {
+	requests atomic.Int64
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{"products":[]}`)),
+		Request:    req,
+	}, nil
+}
+
+func TestSetUpstreamTransportReachesSessions(t *testing.T) {
+	t.Cleanup(func() { setUpstreamTransport(upstreamTransport(offlineTransport{})) })
+	ctx := context.Background()
+
+	// Una sesión creada antes del cambio no debe seguir usando el transport anterior
+	session, err := getAdvancedScraper().sessions.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getAdvancedScraper().sessions.Release(ctx, session, false)
+
+	fake := &countingTransport{}
+	setUpstreamTransport(fake)
+	if _, _, err := getAdvancedScraper().makeRequest(ctx, "GET", "https://apps.lider.cl/supermercado/search?query=leche", nil); err != nil {
+		t.Fatal(err)
+	}
+	if fake.requests.Load() == 0 {
+		t.Error("the scraper sessions did not use the injected transport")
+	}
+}
+
+func TestSetUpstreamTransportClosesPreviousScraper(t *testing.T) {
+	t.Cleanup(func() { setUpstreamTransport(upstreamTransport(offlineTransport{})) })
+
+	previous := getAdvancedScraper()
+	setUpstreamTransport(&countingTransport{})
+
+	select {
+	case <-previous.stop:
+	default:
+		t.Fatal("the replaced scraper was not closed")
+	}
+	// El rate limiter cerrado entrega a lo más el turno que ya tenía en el canal y uno en
+	// carrera con el cierre; después deja de entregar turnos
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := previous.waitRateLimit(ctx)
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+	t.Error("the closed scraper still hands out rate limiter turns")
+}
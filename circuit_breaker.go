@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Estados de un circuit breaker
+const (
+	circuitClosed   = "closed"    // el endpoint se consulta normalmente
+	circuitOpen     = "open"      // el endpoint se salta hasta que pase el cool-down
+	circuitHalfOpen = "half_open" // se deja pasar una petición de prueba
+)
+
+// circuitStateValues es el valor del gauge lider_upstream_circuit_state por estado
+var circuitStateValues = map[string]float64{
+	circuitClosed:   0,
+	circuitHalfOpen: 1,
+	circuitOpen:     2,
+}
+
+// CircuitBreakerConfig es la configuración de los breakers de un endpoint
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `json:"failure_threshold"` // fallas consecutivas que abren el circuito
+	Cooldown         time.Duration `json:"-"`                 // tiempo abierto antes de probar de nuevo
+	HalfOpenProbes   int           `json:"half_open_probes"`  // peticiones de prueba simultáneas en half-open
+}
+
+// circuitBreaker lleva el estado de un endpoint del catálogo
+type circuitBreaker struct {
+	name        string
+	config      CircuitBreakerConfig
+	state       string
+	failures    int // fallas consecutivas
+	probes      int // peticiones de prueba en curso (half-open)
+	openedAt    time.Time
+	lastError   string
+	lastChange  time.Time
+	successes   int64
+	failuresSum int64
+	rejected    int64
+}
+
+// CircuitBreakers agrupa un circuit breaker por endpoint del catálogo, para que un endpoint
+// que no responde se salte automáticamente en vez de gastar todos los reintentos en cada solicitud
+type CircuitBreakers struct {
+	mu        sync.Mutex
+	defaults  CircuitBreakerConfig
+	overrides map[string]CircuitBreakerConfig
+	breakers  map[string]*circuitBreaker
+	disabled  bool
+	now       func() time.Time
+}
+
+// NewCircuitBreakers crea el registro de breakers con la configuración por defecto y la
+// de cada endpoint que la reemplaza
+func NewCircuitBreakers(defaults CircuitBreakerConfig, overrides map[string]CircuitBreakerConfig) *CircuitBreakers {
+	return &CircuitBreakers{
+		defaults:  defaults,
+		overrides: overrides,
+		breakers:  make(map[string]*circuitBreaker),
+		now:       time.Now,
+	}
+}
+
+// Global circuit breakers instance
+var (
+	circuitBreakers     *CircuitBreakers
+	circuitBreakersOnce sync.Once
+)
+
+// getCircuitBreakers returns the singleton circuit breakers configured from CIRCUIT_BREAKER_*
+// and the per-endpoint circuit settings of the upstream endpoint catalogue
+func getCircuitBreakers() *CircuitBreakers {
+	circuitBreakersOnce.Do(func() {
+		defaults := CircuitBreakerConfig{FailureThreshold: 5, Cooldown: time.Minute, HalfOpenProbes: 1}
+		if raw := os.Getenv("CIRCUIT_BREAKER_FAILURE_THRESHOLD"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				defaults.FailureThreshold = n
+			} else {
+				slog.Warn("Invalid CIRCUIT_BREAKER_FAILURE_THRESHOLD, using default", "value", raw, "default", defaults.FailureThreshold)
+			}
+		}
+		if raw := os.Getenv("CIRCUIT_BREAKER_COOLDOWN"); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				defaults.Cooldown = d
+			} else {
+				slog.Warn("Invalid CIRCUIT_BREAKER_COOLDOWN, using default", "value", raw, "default", defaults.Cooldown)
+			}
+		}
+		if raw := os.Getenv("CIRCUIT_BREAKER_HALF_OPEN_PROBES"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				defaults.HalfOpenProbes = n
+			} else {
+				slog.Warn("Invalid CIRCUIT_BREAKER_HALF_OPEN_PROBES, using default", "value", raw, "default", defaults.HalfOpenProbes)
+			}
+		}
+
+		circuitBreakers = NewCircuitBreakers(defaults, getUpstreamEndpoints().circuitOverrides(defaults))
+		if strings.EqualFold(os.Getenv("CIRCUIT_BREAKER_DISABLED"), "true") {
+			circuitBreakers.disabled = true
+			slog.Info("Upstream circuit breakers disabled")
+		}
+	})
+	return circuitBreakers
+}
+
+// breaker retorna el breaker del endpoint, creándolo cerrado si no existe. Requiere mu.
+func (b *CircuitBreakers) breaker(name string) *circuitBreaker {
+	cb, ok := b.breakers[name]
+	if !ok {
+		config, ok := b.overrides[name]
+		if !ok {
+			config = b.defaults
+		}
+		cb = &circuitBreaker{name: name, config: config, state: circuitClosed, lastChange: b.now()}
+		b.breakers[name] = cb
+		upstreamCircuitState.WithLabelValues(name).Set(circuitStateValues[circuitClosed])
+	}
+	return cb
+}
+
+// transition cambia el estado del breaker y lo registra. Requiere mu.
+func (b *CircuitBreakers) transition(ctx context.Context, cb *circuitBreaker, state string) {
+	if cb.state == state {
+		return
+	}
+	previous := cb.state
+	cb.state = state
+	cb.lastChange = b.now()
+	if state == circuitOpen {
+		cb.openedAt = cb.lastChange
+	}
+	if state != circuitHalfOpen {
+		cb.probes = 0
+	}
+
+	upstreamCircuitState.WithLabelValues(cb.name).Set(circuitStateValues[state])
+	upstreamCircuitTransitionsTotal.WithLabelValues(cb.name, state).Inc()
+
+	logger := loggerFrom(ctx).With("endpoint", cb.name, "from", previous, "to", state, "consecutive_failures", cb.failures)
+	if state == circuitOpen {
+		logger.Warn("Upstream circuit opened", "cooldown", cb.config.Cooldown.String(), "last_error", cb.lastError)
+	} else {
+		logger.Info("Upstream circuit state changed")
+	}
+}
+
+// Allow indica si se puede consultar el endpoint. Un circuito abierto pasa a half-open
+// cuando termina el cool-down y deja pasar hasta HalfOpenProbes peticiones de prueba;
+// cada petición permitida se debe cerrar con Record.
+func (b *CircuitBreakers) Allow(ctx context.Context, name string) bool {
+	if b.disabled {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb := b.breaker(name)
+	if cb.state == circuitOpen && b.now().Sub(cb.openedAt) >= cb.config.Cooldown {
+		b.transition(ctx, cb, circuitHalfOpen)
+	}
+
+	switch cb.state {
+	case circuitClosed:
+		return true
+	case circuitHalfOpen:
+		if cb.probes < cb.config.HalfOpenProbes {
+			cb.probes++
+			return true
+		}
+	}
+
+	cb.rejected++
+	upstreamCircuitRejectionsTotal.WithLabelValues(name).Inc()
+	return false
+}
+
+// Record registra el resultado de una petición permitida por Allow. Un 404 cuenta como
+// respuesta sana: el endpoint atendió y el producto no existe. Las cancelaciones y el
+// vencimiento del deadline de la solicitud no cuentan, ni los bloqueos de queue-it o 429
+// (afectan a todo el host, no al endpoint) ni las respuestas que no se pudieron interpretar.
+func (b *CircuitBreakers) Record(ctx context.Context, name string, err error) {
+	if b.disabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb := b.breaker(name)
+	probe := cb.state == circuitHalfOpen
+	if probe && cb.probes > 0 {
+		cb.probes--
+	}
+
+	answered := err == nil || errorKindOf(err) == ErrNotFound
+	if !answered && !countsAsCircuitFailure(ctx, err) {
+		return
+	}
+
+	if answered {
+		cb.successes++
+		cb.failures = 0
+		if probe {
+			b.transition(ctx, cb, circuitClosed)
+		}
+		return
+	}
+
+	cb.failuresSum++
+	cb.failures++
+	cb.lastError = err.Error()
+	if probe || (cb.state == circuitClosed && cb.failures >= cb.config.FailureThreshold) {
+		b.transition(ctx, cb, circuitOpen)
+	}
+}
+
+// countsAsCircuitFailure indica si el error significa que el endpoint no está respondiendo
+func countsAsCircuitFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	switch errorKindOf(err) {
+	case ErrUpstreamBlocked, ErrUpstreamQueued, ErrUpstreamRateLimited, ErrInvalidInput, ErrNotFound, ErrParseFailure:
+		return false
+	}
+	return true
+}
+
+// Reset cierra el breaker del endpoint, o todos si name es vacío. Retorna cuántos cerró.
+func (b *CircuitBreakers) Reset(ctx context.Context, name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reset := 0
+	for _, cb := range b.breakers {
+		if name != "" && cb.name != name {
+			continue
+		}
+		cb.failures = 0
+		b.transition(ctx, cb, circuitClosed)
+		reset++
+	}
+	return reset
+}
+
+// circuitStatus es el estado de un breaker que se muestra en /admin/circuit-breakers
+type circuitStatus struct {
+	Endpoint            string     `json:"endpoint"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	FailureThreshold    int        `json:"failure_threshold"`
+	Cooldown            string     `json:"cooldown"`
+	Since               time.Time  `json:"since"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	Successes           int64      `json:"successes"`
+	Failures            int64      `json:"failures"`
+	Rejected            int64      `json:"rejected"`
+}
+
+// Snapshot retorna el estado de cada breaker ordenado por endpoint
+func (b *CircuitBreakers) Snapshot() []circuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	statuses := make([]circuitStatus, 0, len(b.breakers))
+	for _, cb := range b.breakers {
+		status := circuitStatus{
+			Endpoint:            cb.name,
+			State:               cb.state,
+			ConsecutiveFailures: cb.failures,
+			FailureThreshold:    cb.config.FailureThreshold,
+			Cooldown:            cb.config.Cooldown.String(),
+			Since:               cb.lastChange,
+			LastError:           cb.lastError,
+			Successes:           cb.successes,
+			Failures:            cb.failuresSum,
+			Rejected:            cb.rejected,
+		}
+		if cb.state == circuitOpen {
+			retryAt := cb.openedAt.Add(cb.config.Cooldown)
+			status.RetryAt = &retryAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Endpoint < statuses[j].Endpoint })
+	return statuses
+}
+
+// handleCircuitBreakers muestra el estado del circuit breaker de cada endpoint consultado
+// GET /admin/circuit-breakers
+func handleCircuitBreakers(c *gin.Context) {
+	breakers := getCircuitBreakers()
+	c.JSON(http.StatusOK, gin.H{
+		"disabled":          breakers.disabled,
+		"failure_threshold": breakers.defaults.FailureThreshold,
+		"cooldown":          breakers.defaults.Cooldown.String(),
+		"half_open_probes":  breakers.defaults.HalfOpenProbes,
+		"breakers":          breakers.Snapshot(),
+	})
+}
+
+// handleResetCircuitBreakers cierra el breaker de ?endpoint=nombre, o todos
+// POST /admin/circuit-breakers/reset
+func handleResetCircuitBreakers(c *gin.Context) {
+	name := c.Query("endpoint")
+	reset := getCircuitBreakers().Reset(c.Request.Context(), name)
+	if name != "" && reset == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": fmt.Sprintf("no hay un circuit breaker para el endpoint '%s'", name),
+			"code":  ErrNotFound,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reset": reset})
+}
+
+// errCircuitOpen es la causa de circuitOpenError; se reconoce con errors.Is
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// circuitOpenError es el error cuando todos los endpoints de una operación se saltaron
+// por tener el circuito abierto
+func circuitOpenError(skipped []string) error {
+	return wrapScraperError(ErrUpstreamFailure, errCircuitOpen, fmt.Sprintf("every upstream endpoint skipped (%s)", strings.Join(skipped, ", ")))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testCircuitBreakers crea breakers con un reloj controlado por la prueba
+func testCircuitBreakers(threshold, probes int) (*CircuitBreakers, *time.Time) {
+	breakers := NewCircuitBreakers(CircuitBreakerConfig{FailureThreshold: threshold, Cooldown: time.Minute, HalfOpenProbes: probes}, nil)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	breakers.now = func() time.Time { return now }
+	return breakers, &now
+}
+
+// state retorna el estado del breaker del endpoint
+func state(b *CircuitBreakers, name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.breaker(name).state
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breakers, now := testCircuitBreakers(3, 1)
+	ctx := context.Background()
+	failure := newScraperError(ErrUpstreamFailure, "API returned status 500")
+
+	for i := 0; i < 2; i++ {
+		if !breakers.Allow(ctx, "apps_search") {
+			t.Fatal("closed circuit rejected a request")
+		}
+		breakers.Record(ctx, "apps_search", failure)
+	}
+	if got := state(breakers, "apps_search"); got != circuitClosed {
+		t.Fatalf("state after 2 failures = %s, want closed", got)
+	}
+	breakers.Allow(ctx, "apps_search")
+	breakers.Record(ctx, "apps_search", failure)
+	if got := state(breakers, "apps_search"); got != circuitOpen {
+		t.Fatalf("state after 3 failures = %s, want open", got)
+	}
+	if breakers.Allow(ctx, "apps_search") {
+		t.Fatal("open circuit allowed a request before the cool-down")
+	}
+
+	// Terminado el cool-down pasa una sola petición de prueba; si falla se vuelve a abrir
+	*now = now.Add(time.Minute)
+	if !breakers.Allow(ctx, "apps_search") || state(breakers, "apps_search") != circuitHalfOpen {
+		t.Fatal("circuit did not let a probe through after the cool-down")
+	}
+	breakers.Record(ctx, "apps_search", failure)
+	if got := state(breakers, "apps_search"); got != circuitOpen {
+		t.Fatalf("state after a failed probe = %s, want open", got)
+	}
+
+	// Una prueba exitosa cierra el circuito
+	*now = now.Add(time.Minute)
+	if !breakers.Allow(ctx, "apps_search") {
+		t.Fatal("circuit did not let a probe through after the second cool-down")
+	}
+	breakers.Record(ctx, "apps_search", nil)
+	if got := state(breakers, "apps_search"); got != circuitClosed {
+		t.Fatalf("state after a successful probe = %s, want closed", got)
+	}
+
+	snapshot := breakers.Snapshot()[0]
+	if snapshot.Successes != 1 || snapshot.Failures != 4 || snapshot.Rejected != 1 || snapshot.ConsecutiveFailures != 0 {
+		t.Errorf("snapshot = %+v, want 1 success, 4 failures and 1 rejection", snapshot)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbeLimit(t *testing.T) {
+	breakers, now := testCircuitBreakers(1, 2)
+	ctx := context.Background()
+	breakers.Allow(ctx, "www_search_page")
+	breakers.Record(ctx, "www_search_page", newScraperError(ErrUpstreamFailure, "request failed"))
+
+	*now = now.Add(time.Minute)
+	allowed := 0
+	for i := 0; i < 4; i++ {
+		if breakers.Allow(ctx, "www_search_page") {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("half-open circuit allowed %d probes, want 2", allowed)
+	}
+
+	// Al terminar una prueba sin veredicto (cancelada) se libera su cupo
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	breakers.Record(cancelled, "www_search_page", context.Canceled)
+	if !breakers.Allow(ctx, "www_search_page") {
+		t.Error("a finished probe did not free its slot")
+	}
+	if breakers.Allow(ctx, "www_search_page") {
+		t.Error("half-open circuit allowed a third concurrent probe")
+	}
+}
+
+func TestCircuitBreakerIgnoresHealthyAndHostWideFailures(t *testing.T) {
+	breakers, _ := testCircuitBreakers(2, 1)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"not found", newScraperError(ErrNotFound, "detail API returned status 404")},
+		{"parse failure", newScraperError(ErrParseFailure, "no products found in search results")},
+		{"blocked", newScraperError(ErrUpstreamBlocked, "API returned status 503")},
+		{"rate limited", newScraperError(ErrUpstreamRateLimited, "API returned status 429")},
+		{"queued", queuedError(0)},
+		{"invalid input", newScraperError(ErrInvalidInput, "se requiere parámetro 'sku'")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				breakers.Allow(ctx, tt.name)
+				breakers.Record(ctx, tt.name, tt.err)
+			}
+			if got := state(breakers, tt.name); got != circuitClosed {
+				t.Errorf("state after 5 %s errors = %s, want closed", tt.name, got)
+			}
+		})
+	}
+
+	// Un 404 demuestra que el endpoint responde: corta la racha de fallas
+	failure := errors.New("connection refused")
+	breakers.Record(ctx, "apps_detail", failure)
+	breakers.Record(ctx, "apps_detail", newScraperError(ErrNotFound, "detail API returned status 404"))
+	breakers.Record(ctx, "apps_detail", failure)
+	if got := state(breakers, "apps_detail"); got != circuitClosed {
+		t.Errorf("state = %s, want closed: the 404 should reset the consecutive failures", got)
+	}
+}
+
+func TestCircuitBreakerReset(t *testing.T) {
+	breakers, _ := testCircuitBreakers(1, 1)
+	ctx := context.Background()
+	for _, name := range []string{"apps_search", "www_search_page"} {
+		breakers.Allow(ctx, name)
+		breakers.Record(ctx, name, errors.New("connection refused"))
+	}
+
+	if reset := breakers.Reset(ctx, "apps_search"); reset != 1 {
+		t.Fatalf("Reset(apps_search) = %d, want 1", reset)
+	}
+	if state(breakers, "apps_search") != circuitClosed || state(breakers, "www_search_page") != circuitOpen {
+		t.Fatal("Reset with a name closed the wrong circuits")
+	}
+	if !breakers.Allow(ctx, "apps_search") {
+		t.Error("reset circuit rejected a request")
+	}
+
+	if reset := breakers.Reset(ctx, ""); reset != 2 {
+		t.Errorf("Reset() = %d, want 2", reset)
+	}
+	if got := state(breakers, "www_search_page"); got != circuitClosed {
+		t.Errorf("state after Reset() = %s, want closed", got)
+	}
+	if reset := breakers.Reset(ctx, "unknown"); reset != 0 {
+		t.Errorf("Reset(unknown) = %d, want 0", reset)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// inflightCall representa una petición upstream en curso
+type inflightCall struct {
+	done    chan struct{}
+	result  *ScrapingResult
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// requestGroup deduplica peticiones concurrentes idénticas: mientras una
+// petición para una clave está en curso, los demás llamadores esperan su resultado
+type requestGroup struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+// newRequestGroup crea un grupo de deduplicación vacío
+func newRequestGroup() *requestGroup {
+	return &requestGroup{
+		calls: make(map[string]*inflightCall),
+	}
+}
+
+// Do ejecuta fn una sola vez por clave entre llamadores concurrentes.
+// Cada llamador recibe su propia copia del resultado; shared indica si
+// el resultado provino de una petición iniciada por otro llamador.
+// La petición compartida solo se cancela cuando todos los llamadores
+// que la esperaban abandonaron (por cancelación o deadline de su contexto).
+func (g *requestGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) *ScrapingResult) (result *ScrapingResult, shared bool) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if ok {
+		call.waiters++
+		g.mu.Unlock()
+		return g.wait(ctx, key, call), true
+	}
+
+	callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	call = &inflightCall{
+		done:    make(chan struct{}),
+		waiters: 1,
+		cancel:  cancel,
+	}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		defer func() {
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(call.done)
+		}()
+		call.result = fn(callCtx)
+	}()
+
+	return g.wait(ctx, key, call), false
+}
+
+// wait espera el resultado de la petición o la cancelación del contexto del llamador
+func (g *requestGroup) wait(ctx context.Context, key string, call *inflightCall) *ScrapingResult {
+	select {
+	case <-call.done:
+		return copyScrapingResult(call.result)
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nadie más espera este resultado: liberar la petición upstream
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return &ScrapingResult{
+			Success: false,
+			Error:   ctx.Err().Error(),
+			Err:     contextError(ctx),
+		}
+	}
+}
+
+// copyScrapingResult evita que los llamadores compartan (y muten) el mismo ScrapingResult
+func copyScrapingResult(result *ScrapingResult) *ScrapingResult {
+	if result == nil {
+		return &ScrapingResult{Success: false, Error: "no result"}
+	}
+	copied := *result
+	copied.Data = copyResultData(result.Data)
+	return &copied
+}
+
+// copyResultData copia los productos y el detalle extraídos, que cada llamador puede modificar.
+// El JSON decodificado de las APIs (mapas y slices de interface{}) se comparte y es de solo
+// lectura: convertToProducts y convertToProductDetail construyen valores nuevos a partir de él.
+func copyResultData(data interface{}) interface{} {
+	switch v := data.(type) {
+	case []Product:
+		return slices.Clone(v)
+	case *ProductDetail:
+		if v == nil {
+			return v
+		}
+		detail := *v
+		detail.Images = slices.Clone(v.Images)
+		detail.Specifications = slices.Clone(v.Specifications)
+		return &detail
+	}
+	return data
+}
+
+// normalizeUpstreamURL genera una clave estable para una URL upstream:
+// esquema y host en minúsculas, sin fragmento y con los parámetros ordenados
+func normalizeUpstreamURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawQuery = u.Query().Encode()
+
+	return u.String()
+}
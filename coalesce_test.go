@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestGroupRunsOneCallForConcurrentCallers(t *testing.T) {
+	group := newRequestGroup()
+	var calls atomic.Int64
+	release := make(chan struct{})
+
+	const callers = 10
+	var wg sync.WaitGroup
+	var shared atomic.Int64
+	results := make([]*ScrapingResult, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, wasShared := group.Do(context.Background(), "search:leche", func(ctx context.Context) *ScrapingResult {
+				calls.Add(1)
+				<-release
+				return &ScrapingResult{Success: true, Data: []Product{{ID: "4522432"}}, Source: "api"}
+			})
+			results[i] = result
+			if wasShared {
+				shared.Add(1)
+			}
+		}(i)
+	}
+
+	// Todos los llamadores deben estar esperando antes de que termine la petición
+	waitFor(t, "every caller to join the call", func() bool {
+		group.mu.Lock()
+		defer group.mu.Unlock()
+		call := group.calls["search:leche"]
+		return call != nil && call.waiters == callers
+	})
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("upstream calls = %d, want 1", calls.Load())
+	}
+	if shared.Load() != callers-1 {
+		t.Errorf("shared results = %d, want %d", shared.Load(), callers-1)
+	}
+	for i, result := range results {
+		if !result.Success || len(result.Data.([]Product)) != 1 {
+			t.Errorf("caller %d got %+v", i, result)
+		}
+	}
+}
+
+func TestRequestGroupCancelsOnlyAfterEveryWaiterLeaves(t *testing.T) {
+	group := newRequestGroup()
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) *ScrapingResult {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return &ScrapingResult{Success: false, Err: contextError(ctx)}
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	firstDone := make(chan *ScrapingResult)
+	secondDone := make(chan *ScrapingResult)
+	go func() {
+		result, _ := group.Do(firstCtx, "detail:4522432", fn)
+		firstDone <- result
+	}()
+	<-started
+	go func() {
+		result, _ := group.Do(secondCtx, "detail:4522432", fn)
+		secondDone <- result
+	}()
+	waitFor(t, "the second caller to join the call", func() bool {
+		group.mu.Lock()
+		defer group.mu.Unlock()
+		return group.calls["detail:4522432"].waiters == 2
+	})
+
+	cancelFirst()
+	if result := <-firstDone; result.Success || result.Err == nil {
+		t.Errorf("first caller got %+v, want a cancellation error", result)
+	}
+	select {
+	case <-cancelled:
+		t.Fatal("the shared call was cancelled while another caller was still waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelSecond()
+	<-secondDone
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("the shared call was not cancelled after every caller left")
+	}
+}
+
+func TestRequestGroupReturnsIndependentCopies(t *testing.T) {
+	group := newRequestGroup()
+	release := make(chan struct{})
+	fn := func(ctx context.Context) *ScrapingResult {
+		<-release
+		return &ScrapingResult{Success: true, Data: &ProductDetail{SKU: "4522432", Images: []string{"a.jpg"}}}
+	}
+
+	results := make(chan *ScrapingResult, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			result, _ := group.Do(context.Background(), "detail:4522432", fn)
+			results <- result
+		}()
+	}
+	waitFor(t, "both callers to join the call", func() bool {
+		group.mu.Lock()
+		defer group.mu.Unlock()
+		call := group.calls["detail:4522432"]
+		return call != nil && call.waiters == 2
+	})
+	close(release)
+
+	first, second := <-results, <-results
+	firstDetail := first.Data.(*ProductDetail)
+	firstDetail.Price.Current = 1
+	firstDetail.Images[0] = "changed.jpg"
+	first.Source = "changed"
+
+	secondDetail := second.Data.(*ProductDetail)
+	if secondDetail == firstDetail || secondDetail.Price.Current != 0 || secondDetail.Images[0] != "a.jpg" || second.Source == "changed" {
+		t.Errorf("second caller sees the first caller's changes: %+v", secondDetail)
+	}
+}
+
+func TestDetailCoalescesOnUpstreamURL(t *testing.T) {
+	scraper := getAdvancedScraper()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	targets := getUpstreamEndpoints().targets(endpointDetail, "4522432", PageRequest{})
+	key := "detail:" + normalizeUpstreamURL(targets[0].URL)
+
+	// Una petición en curso con la clave esperada atiende la consulta del detalle
+	go scraper.inflight.Do(context.Background(), key, func(ctx context.Context) *ScrapingResult {
+		close(started)
+		<-release
+		return &ScrapingResult{Success: true, Data: &ProductDetail{SKU: "4522432", Name: "coalesced"}, Source: "api"}
+	})
+	<-started
+	done := make(chan *ScrapingResult)
+	go func() { done <- scraper.FetchProductDetailAdvanced(context.Background(), "4522432") }()
+	waitFor(t, "the detail request to join the call", func() bool {
+		scraper.inflight.mu.Lock()
+		defer scraper.inflight.mu.Unlock()
+		call := scraper.inflight.calls[key]
+		return call != nil && call.waiters == 2
+	})
+	close(release)
+
+	if result := <-done; result.Data.(*ProductDetail).Name != "coalesced" {
+		t.Errorf("detail = %+v, want the in-flight result for %s", result.Data, key)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Campos cuya cobertura se monitorea en cada producto extraído
+const (
+	coverageName  = "name"
+	coveragePrice = "price"
+	coverageImage = "image"
+)
+
+// coverageFields es el orden en que se reportan los campos
+var coverageFields = []string{coverageName, coveragePrice, coverageImage}
+
+// maxDriftEvents limita cuántos eventos de drift recientes se guardan en memoria
+const maxDriftEvents = 50
+
+// DriftEvent registra que la cobertura de un campo cayó bajo el umbral (o se recuperó)
+type DriftEvent struct {
+	Time      time.Time `json:"time"`
+	Endpoint  string    `json:"endpoint"`
+	Source    string    `json:"source"`
+	Field     string    `json:"field"`
+	Coverage  float64   `json:"coverage"`
+	Threshold float64   `json:"threshold"`
+	Samples   int       `json:"samples"`
+	Recovered bool      `json:"recovered"`
+}
+
+// coverageSample indica qué campos venían poblados en un producto
+type coverageSample map[string]bool
+
+// coverageWindow es la ventana móvil de muestras de un endpoint y origen
+type coverageWindow struct {
+	samples  []coverageSample
+	next     int
+	count    int
+	drifting map[string]bool
+}
+
+// coverage retorna la proporción de muestras de la ventana con el campo poblado
+func (w *coverageWindow) coverage(field string) float64 {
+	if w.count == 0 {
+		return 0
+	}
+	populated := 0
+	for _, sample := range w.samples[:w.count] {
+		if sample[field] {
+			populated++
+		}
+	}
+	return float64(populated) / float64(w.count)
+}
+
+// CoverageMonitor mide qué proporción de los productos extraídos trae nombre, precio e imagen,
+// y marca un evento de drift cuando la cobertura de un campo cae bajo el umbral. Una caída
+// suele significar que Lider cambió la estructura de sus páginas o de su API.
+type CoverageMonitor struct {
+	mu         sync.Mutex
+	size       int
+	minSamples int
+	threshold  float64
+	windows    map[string]*coverageWindow
+	keys       map[string][2]string // clave de ventana -> endpoint y origen
+	events     []DriftEvent
+}
+
+// NewCoverageMonitor crea un monitor con ventanas de size muestras que evalúa el drift
+// desde minSamples muestras en adelante
+func NewCoverageMonitor(size, minSamples int, threshold float64) *CoverageMonitor {
+	if minSamples > size {
+		minSamples = size
+	}
+	return &CoverageMonitor{
+		size:       size,
+		minSamples: minSamples,
+		threshold:  threshold,
+		windows:    make(map[string]*coverageWindow),
+		keys:       make(map[string][2]string),
+	}
+}
+
+// Global coverage monitor instance
+var (
+	coverageMonitor     *CoverageMonitor
+	coverageMonitorOnce sync.Once
+)
+
+// getCoverageMonitor returns the singleton coverage monitor configured from
+// COVERAGE_WINDOW, COVERAGE_MIN_SAMPLES and COVERAGE_DRIFT_THRESHOLD
+func getCoverageMonitor() *CoverageMonitor {
+	coverageMonitorOnce.Do(func() {
+		size := 200
+		if raw := os.Getenv("COVERAGE_WINDOW"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				size = n
+			} else {
+				slog.Warn("Invalid COVERAGE_WINDOW, using default", "value", raw, "default", size)
+			}
+		}
+		minSamples := 50
+		if raw := os.Getenv("COVERAGE_MIN_SAMPLES"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				minSamples = n
+			} else {
+				slog.Warn("Invalid COVERAGE_MIN_SAMPLES, using default", "value", raw, "default", minSamples)
+			}
+		}
+		threshold := 0.8
+		if raw := os.Getenv("COVERAGE_DRIFT_THRESHOLD"); raw != "" {
+			if f, err := strconv.ParseFloat(raw, 64); err == nil && f >= 0 && f <= 1 {
+				threshold = f
+			} else {
+				slog.Warn("Invalid COVERAGE_DRIFT_THRESHOLD, using default", "value", raw, "default", threshold)
+			}
+		}
+		coverageMonitor = NewCoverageMonitor(size, minSamples, threshold)
+	})
+	return coverageMonitor
+}
+
+// productSample retorna los campos poblados de un producto de búsqueda
+func productSample(p Product) coverageSample {
+	return coverageSample{
+		coverageName:  p.DisplayName != "",
+		coveragePrice: p.Price.BasePriceSales > 0 || p.Price.BasePriceReference > 0,
+		coverageImage: p.Images.DefaultImage != "" || p.Images.MediumImage != "",
+	}
+}
+
+// detailSample retorna los campos poblados de un detalle de producto
+func detailSample(d *ProductDetail) coverageSample {
+	return coverageSample{
+		coverageName:  d.Name != "",
+		coveragePrice: d.Price.Current > 0,
+		coverageImage: len(d.Images) > 0,
+	}
+}
+
+// recordProductCoverage registra la cobertura de los productos de una página de resultados
+func recordProductCoverage(ctx context.Context, endpoint, source string, products []Product) {
+	samples := make([]coverageSample, 0, len(products))
+	for _, p := range products {
+		samples = append(samples, productSample(p))
+	}
+	getCoverageMonitor().Record(ctx, endpoint, source, samples...)
+}
+
+// recordDetailCoverage registra la cobertura de un detalle de producto
+func recordDetailCoverage(ctx context.Context, endpoint, source string, detail *ProductDetail) {
+	if detail == nil {
+		return
+	}
+	getCoverageMonitor().Record(ctx, endpoint, source, detailSample(detail))
+}
+
+// recordExtractionFailure cuenta una página descargada de la que no se pudo extraer nada
+// como una muestra sin campos, para que una página irreconocible también haga caer la cobertura
+func recordExtractionFailure(ctx context.Context, endpoint string, err error) {
+	if errorKindOf(err) != ErrParseFailure {
+		return
+	}
+	getCoverageMonitor().Record(ctx, endpoint, "scraping", coverageSample{})
+}
+
+// Record agrega muestras a la ventana del endpoint y origen, actualiza las métricas
+// y registra un evento cuando un campo entra o sale de drift
+func (m *CoverageMonitor) Record(ctx context.Context, endpoint, source string, samples ...coverageSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := endpoint + "/" + source
+	w, ok := m.windows[key]
+	if !ok {
+		w = &coverageWindow{
+			samples:  make([]coverageSample, m.size),
+			drifting: make(map[string]bool),
+		}
+		m.windows[key] = w
+		m.keys[key] = [2]string{endpoint, source}
+	}
+	for _, sample := range samples {
+		w.samples[w.next] = sample
+		w.next = (w.next + 1) % m.size
+		if w.count < m.size {
+			w.count++
+		}
+	}
+
+	for _, field := range coverageFields {
+		ratio := w.coverage(field)
+		scraperFieldCoverage.WithLabelValues(endpoint, source, field).Set(ratio)
+		if w.count < m.minSamples {
+			continue
+		}
+
+		drifting := ratio < m.threshold
+		if drifting == w.drifting[field] {
+			continue
+		}
+		w.drifting[field] = drifting
+
+		event := DriftEvent{
+			Time:      time.Now(),
+			Endpoint:  endpoint,
+			Source:    source,
+			Field:     field,
+			Coverage:  ratio,
+			Threshold: m.threshold,
+			Samples:   w.count,
+			Recovered: !drifting,
+		}
+		m.events = append(m.events, event)
+		if len(m.events) > maxDriftEvents {
+			m.events = m.events[len(m.events)-maxDriftEvents:]
+		}
+
+		logger := loggerFrom(ctx).With("endpoint", endpoint, "source", source, "field", field,
+			"coverage", ratio, "threshold", m.threshold, "samples", w.count)
+		if drifting {
+			scraperDriftEventsTotal.WithLabelValues(endpoint, source, field).Inc()
+			logger.Warn("Scraper drift detected: field coverage dropped below threshold")
+		} else {
+			logger.Info("Scraper field coverage recovered")
+		}
+	}
+}
+
+// coverageStatus es la cobertura actual de un endpoint y origen
+type coverageStatus struct {
+	Endpoint string             `json:"endpoint"`
+	Source   string             `json:"source"`
+	Samples  int                `json:"samples"`
+	Coverage map[string]float64 `json:"coverage"`
+	Drifting []string           `json:"drifting"`
+}
+
+// Snapshot retorna la cobertura de cada ventana y los eventos de drift recientes
+func (m *CoverageMonitor) Snapshot() ([]coverageStatus, []DriftEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	statuses := make([]coverageStatus, 0, len(m.windows))
+	for key, w := range m.windows {
+		status := coverageStatus{
+			Endpoint: m.keys[key][0],
+			Source:   m.keys[key][1],
+			Samples:  w.count,
+			Coverage: make(map[string]float64),
+			Drifting: []string{},
+		}
+		for _, field := range coverageFields {
+			status.Coverage[field] = w.coverage(field)
+			if w.drifting[field] {
+				status.Drifting = append(status.Drifting, field)
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Endpoint != statuses[j].Endpoint {
+			return statuses[i].Endpoint < statuses[j].Endpoint
+		}
+		return statuses[i].Source < statuses[j].Source
+	})
+
+	events := make([]DriftEvent, len(m.events))
+	copy(events, m.events)
+	return statuses, events
+}
+
+// handleScraperCoverage muestra la cobertura de campos por endpoint y origen y los eventos de drift
+// GET /admin/scraper-coverage
+func handleScraperCoverage(c *gin.Context) {
+	monitor := getCoverageMonitor()
+	statuses, events := monitor.Snapshot()
+	c.JSON(http.StatusOK, gin.H{
+		"window":      monitor.size,
+		"min_samples": monitor.minSamples,
+		"threshold":   monitor.threshold,
+		"windows":     statuses,
+		"events":      events,
+	})
+}
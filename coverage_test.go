@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCoverageMonitorEmitsDriftAndRecoveryOnce(t *testing.T) {
+	monitor := NewCoverageMonitor(4, 3, 0.5)
+	ctx := context.Background()
+	withoutImage := coverageSample{coverageName: true, coveragePrice: true, coverageImage: false}
+	complete := coverageSample{coverageName: true, coveragePrice: true, coverageImage: true}
+	events := func() []DriftEvent {
+		_, events := monitor.Snapshot()
+		return events
+	}
+
+	// Con menos de minSamples muestras no se evalúa el drift, aunque la cobertura sea 0
+	monitor.Record(ctx, endpointSearch, "scraping", withoutImage, withoutImage)
+	if got := events(); len(got) != 0 {
+		t.Fatalf("events before minSamples = %+v, want none", got)
+	}
+
+	monitor.Record(ctx, endpointSearch, "scraping", withoutImage)
+	monitor.Record(ctx, endpointSearch, "scraping", withoutImage)
+	got := events()
+	if len(got) != 1 {
+		t.Fatalf("events after the coverage dropped = %+v, want one drift event", got)
+	}
+	if drift := got[0]; drift.Field != coverageImage || drift.Recovered || drift.Coverage != 0 || drift.Samples != 3 ||
+		drift.Endpoint != endpointSearch || drift.Source != "scraping" {
+		t.Errorf("drift event = %+v, want image at coverage 0 with 3 samples", drift)
+	}
+
+	// La ventana es de 4 muestras: con 2 completas la cobertura vuelve al umbral
+	monitor.Record(ctx, endpointSearch, "scraping", complete)
+	if got := events(); len(got) != 1 {
+		t.Fatalf("events while still below the threshold = %+v, want only the drift event", got)
+	}
+	monitor.Record(ctx, endpointSearch, "scraping", complete)
+	monitor.Record(ctx, endpointSearch, "scraping", complete)
+	got = events()
+	if len(got) != 2 {
+		t.Fatalf("events after the coverage recovered = %+v, want drift and recovery", got)
+	}
+	if recovery := got[1]; recovery.Field != coverageImage || !recovery.Recovered || recovery.Coverage != 0.5 {
+		t.Errorf("recovery event = %+v, want image recovered at coverage 0.5", recovery)
+	}
+
+	statuses, _ := monitor.Snapshot()
+	if len(statuses) != 1 || len(statuses[0].Drifting) != 0 || statuses[0].Coverage[coverageImage] != 0.75 {
+		t.Errorf("status = %+v, want one window without drifting fields and image coverage 0.75", statuses)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tipos de endpoint expuestos por la API, usados para configurar TTLs y deadlines
+const (
+	endpointSearch      = "search"
+	endpointDetail      = "detail"
+	endpointSuggestions = "suggestions"
+	endpointPromotions  = "promotions"
+	endpointCategory    = "category"
+)
+
+// defaultUpstreamTimeouts define el tiempo máximo que un request puede pasar
+// consultando a Lider (incluyendo reintentos y fallbacks) por tipo de endpoint
+var defaultUpstreamTimeouts = map[string]time.Duration{
+	endpointSearch:      30 * time.Second,
+	endpointDetail:      60 * time.Second,
+	endpointSuggestions: 10 * time.Second,
+	endpointPromotions:  30 * time.Second,
+	endpointCategory:    30 * time.Second,
+}
+
+var (
+	upstreamTimeouts     map[string]time.Duration
+	upstreamTimeoutsOnce sync.Once
+)
+
+// withUpstreamDeadline aplica al contexto el deadline configurado para el endpoint
+// (UPSTREAM_TIMEOUT_<ENDPOINT>, por ejemplo UPSTREAM_TIMEOUT_DETAIL=45s) y registra la
+// operación para elegir su política de reintentos
+func withUpstreamDeadline(ctx context.Context, endpoint string) (context.Context, context.CancelFunc) {
+	upstreamTimeoutsOnce.Do(func() {
+		upstreamTimeouts = loadEndpointDurations("UPSTREAM_TIMEOUT_", defaultUpstreamTimeouts)
+	})
+	ctx = context.WithValue(ctx, upstreamOperationKey{}, endpoint)
+
+	timeout := upstreamTimeouts[endpoint]
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	deadline := time.Now().Add(timeout)
+	return context.WithDeadline(context.WithValue(ctx, upstreamDeadlineKey{}, deadline), deadline)
+}
+
+// upstreamDeadlineKey es la clave de contexto que guarda el deadline de withUpstreamDeadline
+type upstreamDeadlineKey struct{}
+
+// upstreamDeadline retorna el deadline de la solicitud. Se conserva como valor porque las
+// peticiones compartidas (requestGroup) se desligan de la cancelación de quien las inició.
+func upstreamDeadline(ctx context.Context) (time.Time, bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline, true
+	}
+	deadline, ok := ctx.Value(upstreamDeadlineKey{}).(time.Time)
+	return deadline, ok
+}
+
+// upstreamOperationKey es la clave de contexto que guarda el tipo de endpoint de withUpstreamDeadline
+type upstreamOperationKey struct{}
+
+// upstreamOperation retorna el tipo de endpoint de la solicitud, o "" si no se registró
+func upstreamOperation(ctx context.Context) string {
+	operation, _ := ctx.Value(upstreamOperationKey{}).(string)
+	return operation
+}
+
+// loadEndpointDurations lee una duración por endpoint desde variables de entorno
+// con el prefijo indicado, usando los valores por defecto si no existen o son inválidas
+func loadEndpointDurations(prefix string, defaults map[string]time.Duration) map[string]time.Duration {
+	durations := make(map[string]time.Duration, len(defaults))
+
+	for endpoint, value := range defaults {
+		envName := prefix + strings.ToUpper(endpoint)
+		if raw := os.Getenv(envName); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil {
+				slog.Warn("Invalid duration setting, using default", "name", envName, "value", raw, "default", value)
+			} else {
+				value = parsed
+			}
+		}
+		durations[endpoint] = value
+	}
+
+	return durations
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serverTransport envía todas las peticiones a Lider al servidor de prueba
+type serverTransport struct {
+	server *url.URL
+}
+
+func (t serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	redirected := req.Clone(req.Context())
+	redirected.URL.Scheme = t.server.Scheme
+	redirected.URL.Host = t.server.Host
+	redirected.Host = ""
+	return http.DefaultTransport.RoundTrip(redirected)
+}
+
+// useUpstreamServer atiende las peticiones a Lider con handler y cuenta cuántas llegan
+func useUpstreamServer(t *testing.T, handler http.HandlerFunc) *atomic.Int64 {
+	t.Helper()
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		handler(w, r)
+	}))
+	target, _ := url.Parse(server.URL)
+	setUpstreamTransport(serverTransport{server: target})
+	t.Cleanup(func() {
+		setUpstreamTransport(upstreamTransport(offlineTransport{}))
+		server.Close()
+	})
+	return &hits
+}
+
+// useUpstreamTimeout fija el deadline de un endpoint durante la prueba
+func useUpstreamTimeout(t *testing.T, endpoint string, timeout time.Duration) {
+	t.Helper()
+	_, cancel := withUpstreamDeadline(context.Background(), endpoint)
+	cancel()
+	previous := upstreamTimeouts[endpoint]
+	upstreamTimeouts[endpoint] = timeout
+	t.Cleanup(func() { upstreamTimeouts[endpoint] = previous })
+}
+
+func TestSlowUpstreamTimesOutWithinDeadline(t *testing.T) {
+	useUpstreamTimeout(t, endpointSearch, 300*time.Millisecond)
+	useUpstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	cassetteRouter().ServeHTTP(recorder, httptest.NewRequest("GET", "/productos?q=deadline", nil))
+	elapsed := time.Since(start)
+
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", recorder.Code, recorder.Body.String())
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != string(ErrUpstreamTimeout) {
+		t.Errorf("code = %q, want %s", body.Code, ErrUpstreamTimeout)
+	}
+	// El deadline cubre los reintentos y los endpoints de respaldo, no cada petición
+	if elapsed > time.Second {
+		t.Errorf("request took %v, want it to end at the 300ms deadline", elapsed)
+	}
+}
+
+func TestClientDisconnectCancelsRetries(t *testing.T) {
+	firstHit := make(chan struct{}, 1)
+	hits := useUpstreamServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case firstHit <- struct{}{}:
+		default:
+		}
+		// Lider pide esperar 2 segundos antes de reintentar
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	ctx = withRetryPolicy(ctx, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second})
+	req := httptest.NewRequest("GET", "/productos?q=disconnect", nil).WithContext(ctx)
+
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		defer close(done)
+		cassetteRouter().ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	<-firstHit
+	disconnect()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the handler kept waiting to retry after the client disconnected")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("handler returned after %v, want it to stop before the 2s retry", elapsed)
+	}
+
+	// Ningún reintento ni endpoint de respaldo sale después de la desconexión
+	time.Sleep(100 * time.Millisecond)
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream requests = %d, want 1", got)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorKind clasifica los errores de la capa de scraping. Su valor es el código
+// estable que se devuelve en el campo "code" de las respuestas de error.
+type ErrorKind string
+
+const (
+	ErrNotFound            ErrorKind = "not_found"             // el producto o la página no existe en Lider
+	ErrUpstreamBlocked     ErrorKind = "upstream_blocked"      // queue-it u otra protección anti-bot
+	ErrUpstreamQueued      ErrorKind = "upstream_queued"       // la fila virtual de queue-it está activa
+	ErrUpstreamRateLimited ErrorKind = "upstream_rate_limited" // Lider respondió 429
+	ErrUpstreamTimeout     ErrorKind = "upstream_timeout"      // se agotó el deadline hacia Lider
+	ErrUpstreamFailure     ErrorKind = "upstream_error"        // error de red o status inesperado
+	ErrParseFailure        ErrorKind = "parse_failure"         // la respuesta no se pudo interpretar
+	ErrInvalidInput        ErrorKind = "invalid_input"         // parámetros inválidos en la solicitud
+	ErrUnavailable         ErrorKind = "unavailable"           // funcionalidad deshabilitada (alertas, historial)
+	ErrInternal            ErrorKind = "internal_error"        // cualquier otro error
+)
+
+// ScraperError es un error tipado de la capa de scraping
+type ScraperError struct {
+	Kind       ErrorKind
+	Message    string
+	Err        error
+	RetryAfter time.Duration // cuánto esperar antes de reintentar, si Lider lo indicó
+}
+
+func (e *ScraperError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *ScraperError) Unwrap() error { return e.Err }
+
+// newScraperError crea un error tipado sin causa
+func newScraperError(kind ErrorKind, format string, args ...interface{}) *ScraperError {
+	return &ScraperError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapScraperError crea un error tipado que envuelve la causa original
+func wrapScraperError(kind ErrorKind, err error, message string) *ScraperError {
+	return &ScraperError{Kind: kind, Message: message, Err: err}
+}
+
+// statusError clasifica una respuesta de Lider con status distinto de 200
+func statusError(what string, status int) *ScraperError {
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return newScraperError(ErrNotFound, "%s returned status %d", what, status)
+	case status == http.StatusTooManyRequests:
+		return newScraperError(ErrUpstreamRateLimited, "%s returned status %d", what, status)
+	case status == http.StatusForbidden || status == http.StatusServiceUnavailable:
+		return newScraperError(ErrUpstreamBlocked, "%s returned status %d", what, status)
+	default:
+		return newScraperError(ErrUpstreamFailure, "%s returned status %d", what, status)
+	}
+}
+
+// requestError tipa la falla de una petición a Lider: los errores ya tipados por el transport
+// (queue-it, circuito abierto) se conservan, el vencimiento del deadline es un timeout y el
+// resto es una falla de red
+func requestError(ctx context.Context, err error) error {
+	var scraperErr *ScraperError
+	if errors.As(err, &scraperErr) {
+		return err
+	}
+	if ctx.Err() != nil {
+		return contextError(ctx)
+	}
+	return wrapScraperError(ErrUpstreamFailure, err, "request failed")
+}
+
+// contextError tipa la cancelación o el vencimiento del contexto
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return wrapScraperError(ErrUpstreamTimeout, ctx.Err(), "upstream deadline exceeded")
+	}
+	return ctx.Err()
+}
+
+// errorKindOf retorna la clasificación de un error (ErrInternal si no es tipado)
+func errorKindOf(err error) ErrorKind {
+	var scraperErr *ScraperError
+	if errors.As(err, &scraperErr) {
+		return scraperErr.Kind
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrUpstreamTimeout
+	}
+	return ErrInternal
+}
+
+// failureRank ordena los tipos de error de más a menos específico. Cuando fallan todos los
+// endpoints de una operación se informa el error más específico y no el del último endpoint.
+// Un bloqueo está sobre un 404: si la API principal está bloqueada, el 404 de un endpoint
+// alternativo (o de uno que Lider ya no usa) no prueba que el producto no exista. Un 404 sí
+// dice más que un timeout o un HTML ilegible.
+var failureRank = map[ErrorKind]int{
+	ErrUpstreamQueued:      7,
+	ErrUpstreamBlocked:     6,
+	ErrUpstreamRateLimited: 5,
+	ErrNotFound:            4,
+	ErrUpstreamTimeout:     3,
+	ErrUpstreamFailure:     2,
+	ErrParseFailure:        1,
+}
+
+// moreSpecific retorna el más específico entre current y candidate según failureRank; ante
+// un empate se queda con current, el del endpoint que se probó primero
+func moreSpecific(current, candidate error) error {
+	if current == nil {
+		return candidate
+	}
+	if candidate == nil || failureRank[errorKindOf(candidate)] <= failureRank[errorKindOf(current)] {
+		return current
+	}
+	return candidate
+}
+
+// errorStatus asocia cada tipo de error con su status HTTP
+var errorStatus = map[ErrorKind]int{
+	ErrNotFound:            http.StatusNotFound,
+	ErrUpstreamBlocked:     http.StatusServiceUnavailable,
+	ErrUpstreamQueued:      http.StatusServiceUnavailable,
+	ErrUpstreamRateLimited: http.StatusTooManyRequests,
+	ErrUpstreamTimeout:     http.StatusGatewayTimeout,
+	ErrUpstreamFailure:     http.StatusBadGateway,
+	ErrParseFailure:        http.StatusBadGateway,
+	ErrInvalidInput:        http.StatusBadRequest,
+	ErrUnavailable:         http.StatusServiceUnavailable,
+	ErrInternal:            http.StatusInternalServerError,
+}
+
+// errorMessages son los mensajes que ve el cliente; no incluyen detalles internos
+var errorMessages = map[ErrorKind]string{
+	ErrNotFound:            "recurso no encontrado en Lider",
+	ErrUpstreamBlocked:     "Lider bloqueó temporalmente las consultas (protección anti-bot), intenta más tarde",
+	ErrUpstreamQueued:      "Lider tiene activa su fila de espera, intenta después del tiempo indicado en Retry-After",
+	ErrUpstreamRateLimited: "Lider está limitando las consultas, intenta más tarde",
+	ErrUpstreamTimeout:     "Lider no respondió a tiempo",
+	ErrUpstreamFailure:     "error al consultar Lider",
+	ErrParseFailure:        "no se pudo interpretar la respuesta de Lider",
+	ErrInvalidInput:        "parámetros inválidos",
+	ErrUnavailable:         "funcionalidad no disponible",
+	ErrInternal:            "Error interno del servidor",
+}
+
+// publicError retorna status, código y mensaje seguros para mostrar al cliente
+func publicError(err error) (int, ErrorKind, string) {
+	kind := errorKindOf(err)
+	message := errorMessages[kind]
+
+	// Los errores de validación describen el parámetro, así que se muestran tal cual
+	var scraperErr *ScraperError
+	if kind == ErrInvalidInput && errors.As(err, &scraperErr) {
+		message = scraperErr.Message
+	}
+	return errorStatus[kind], kind, message
+}
+
+// retryAfterOf retorna la espera indicada por Lider en el error, o 0
+func retryAfterOf(err error) time.Duration {
+	var scraperErr *ScraperError
+	if errors.As(err, &scraperErr) {
+		return scraperErr.RetryAfter
+	}
+	return 0
+}
+
+// respondError responde con el status correspondiente al tipo de error y un código estable
+func respondError(c *gin.Context, err error) {
+	status, kind, message := publicError(err)
+	if wait := retryAfterOf(err); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	}
+	c.JSON(status, gin.H{
+		"error": message,
+		"code":  kind,
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMoreSpecificRanksFailures(t *testing.T) {
+	notFound := newScraperError(ErrNotFound, "detail API returned status 404")
+	queued := queuedError(0)
+	blocked := newScraperError(ErrUpstreamBlocked, "page returned status 403")
+	timeout := wrapScraperError(ErrUpstreamTimeout, context.DeadlineExceeded, "upstream deadline exceeded")
+	upstream := newScraperError(ErrUpstreamFailure, "API returned status 500")
+	parse := newScraperError(ErrParseFailure, "no products found in page")
+
+	tests := []struct {
+		name     string
+		failures []error
+		want     ErrorKind
+	}{
+		{"not found beats a later parse failure", []error{notFound, parse}, ErrNotFound},
+		{"a block beats a later not found", []error{blocked, notFound}, ErrUpstreamBlocked},
+		{"rate limit beats an earlier not found", []error{notFound, newScraperError(ErrUpstreamRateLimited, "API returned status 429")}, ErrUpstreamRateLimited},
+		{"not found beats timeout", []error{timeout, notFound}, ErrNotFound},
+		{"queued beats blocked", []error{blocked, queued}, ErrUpstreamQueued},
+		{"blocked beats timeout", []error{timeout, blocked}, ErrUpstreamBlocked},
+		{"timeout beats upstream failure", []error{upstream, timeout}, ErrUpstreamTimeout},
+		{"upstream failure beats parse failure", []error{parse, upstream}, ErrUpstreamFailure},
+		{"parse failure beats an untyped error", []error{errors.New("boom"), parse}, ErrParseFailure},
+		{"wrapped errors keep their kind", []error{parse, fmt.Errorf("apps_detail: %w", notFound)}, ErrNotFound},
+		{"nil candidates are ignored", []error{upstream, nil}, ErrUpstreamFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var failure error
+			for _, err := range tt.failures {
+				failure = moreSpecific(failure, err)
+			}
+			if got := errorKindOf(failure); got != tt.want {
+				t.Errorf("kind = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoreSpecificKeepsFirstOnTie(t *testing.T) {
+	first := newScraperError(ErrUpstreamFailure, "first")
+	second := newScraperError(ErrUpstreamFailure, "second")
+	if got := moreSpecific(first, second); got != first {
+		t.Errorf("moreSpecific = %v, want the first failure", got)
+	}
+}
+
+func TestRespondErrorMapsKindsToStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantKind   ErrorKind
+	}{
+		{newScraperError(ErrNotFound, "detail API returned status 404"), http.StatusNotFound, ErrNotFound},
+		{newScraperError(ErrUpstreamBlocked, "page returned status 403"), http.StatusServiceUnavailable, ErrUpstreamBlocked},
+		{queuedError(30 * time.Second), http.StatusServiceUnavailable, ErrUpstreamQueued},
+		{newScraperError(ErrUpstreamRateLimited, "API returned status 429"), http.StatusTooManyRequests, ErrUpstreamRateLimited},
+		{wrapScraperError(ErrUpstreamTimeout, context.DeadlineExceeded, "upstream deadline exceeded"), http.StatusGatewayTimeout, ErrUpstreamTimeout},
+		{newScraperError(ErrUpstreamFailure, "API returned status 500"), http.StatusBadGateway, ErrUpstreamFailure},
+		{newScraperError(ErrParseFailure, "failed to decode promotions"), http.StatusBadGateway, ErrParseFailure},
+		{newScraperError(ErrInvalidInput, "se requiere parámetro 'q'"), http.StatusBadRequest, ErrInvalidInput},
+		{newScraperError(ErrUnavailable, "alerts disabled"), http.StatusServiceUnavailable, ErrUnavailable},
+		{errors.New("boom"), http.StatusInternalServerError, ErrInternal},
+		{fmt.Errorf("promotions failed: apps_promotions error: %w", newScraperError(ErrNotFound, "no results")), http.StatusNotFound, ErrNotFound},
+		{fmt.Errorf("search failed: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, ErrUpstreamTimeout},
+	}
+
+	covered := map[ErrorKind]bool{}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			respondError(c, tt.err)
+
+			var body struct {
+				Error string    `json:"error"`
+				Code  ErrorKind `json:"code"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if recorder.Code != tt.wantStatus || body.Code != tt.wantKind {
+				t.Errorf("response = %d %s, want %d %s", recorder.Code, body.Code, tt.wantStatus, tt.wantKind)
+			}
+			if body.Error == "" || (tt.wantKind != ErrInvalidInput && strings.Contains(body.Error, tt.err.Error())) {
+				t.Errorf("message = %q, want the public message for %s", body.Error, tt.wantKind)
+			}
+			if wantRetry := tt.wantKind == ErrUpstreamQueued; (recorder.Header().Get("Retry-After") == "30") != wantRetry {
+				t.Errorf("Retry-After = %q", recorder.Header().Get("Retry-After"))
+			}
+		})
+		covered[tt.wantKind] = true
+	}
+	for kind := range errorStatus {
+		if !covered[kind] {
+			t.Errorf("kind %s is not covered", kind)
+		}
+	}
+}
+
+func TestListingAPIErrorsAreTyped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/malformed":
+			w.Write([]byte(`{"products": [`))
+		default:
+			var status int
+			fmt.Sscanf(r.URL.Path, "/%d", &status)
+			w.WriteHeader(status)
+			w.Write([]byte("upstream body with internal details"))
+		}
+	}))
+	defer server.Close()
+
+	// Las pruebas no tienen red: el servidor local se consulta con un transport directo
+	previous := httpClient.Transport
+	httpClient.Transport = &instrumentedTransport{base: http.DefaultTransport}
+	t.Cleanup(func() { httpClient.Transport = previous })
+
+	tests := []struct {
+		path string
+		want ErrorKind
+	}{
+		{"/404", ErrNotFound},
+		{"/429", ErrUpstreamRateLimited},
+		{"/503", ErrUpstreamBlocked},
+		{"/500", ErrUpstreamFailure},
+		{"/malformed", ErrParseFailure},
+	}
+	for _, tt := range tests {
+		for name, fetch := range map[string]func(ctx context.Context, u string) (*Response, error){
+			"promotions": func(ctx context.Context, u string) (*Response, error) { return fetchPromotions(ctx, u, "descuentos") },
+			"category":   func(ctx context.Context, u string) (*Response, error) { return fetchCategory(ctx, u, "lacteos") },
+		} {
+			_, err := fetch(context.Background(), server.URL+tt.path)
+			if got := errorKindOf(err); got != tt.want {
+				t.Errorf("%s %s: kind = %s (%v), want %s", name, tt.path, got, err, tt.want)
+			}
+			if err != nil && strings.Contains(err.Error(), "internal details") {
+				t.Errorf("%s %s: error embeds the upstream body: %v", name, tt.path, err)
+			}
+		}
+	}
+}
+
+// routeTransport responde cada petición con el status de la primera ruta que coincide
+type routeTransport map[string]int
+
+func (rt routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	status := http.StatusNotFound
+	for prefix, code := range rt {
+		if strings.HasPrefix(req.URL.Host+req.URL.Path, prefix) {
+			status = code
+		}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": {"text/plain"}},
+		Body:       io.NopCloser(strings.NewReader(http.StatusText(status))),
+		Request:    req,
+	}, nil
+}
+
+func TestBlockedPrimaryIsNotReportedAsNotFound(t *testing.T) {
+	// La API principal bloquea y los endpoints alternativos responden 404
+	scraper := NewAdvancedScraper(routeTransport{"apps.lider.cl/supermercado/product": http.StatusForbidden})
+	targets := getUpstreamEndpoints().targets(endpointDetail, "4522432", PageRequest{})
+
+	result := scraper.fetchProductDetail(context.Background(), "4522432", targets)
+	if result.Success {
+		t.Fatal("fetch succeeded against blocked and missing endpoints")
+	}
+	if got := errorKindOf(result.failure()); got != ErrUpstreamBlocked {
+		t.Errorf("kind = %s (%v), want %s", got, result.failure(), ErrUpstreamBlocked)
+	}
+}
@@ -0,0 +1,615 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// htmlDocument es una página parseada como árbol DOM, con sus scripts y meta tags indexados
+type htmlDocument struct {
+	root    *html.Node
+	scripts []*html.Node
+	metas   map[string]string // og:*, product:* y meta name=... por nombre o propiedad
+}
+
+// parseHTMLDocument parsea el HTML con el parser de x/net/html, que tolera markup
+// anidado o mal formado igual que un navegador
+func parseHTMLDocument(body string) (*htmlDocument, error) {
+	root, err := html.Parse(strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	doc := &htmlDocument{
+		root:  root,
+		metas: make(map[string]string),
+	}
+	walkNodes(root, func(n *html.Node) bool {
+		if n.Type != html.ElementNode {
+			return true
+		}
+		switch n.Data {
+		case "script":
+			doc.scripts = append(doc.scripts, n)
+			return false
+		case "meta":
+			key := nodeAttr(n, "property")
+			if key == "" {
+				key = nodeAttr(n, "name")
+			}
+			if key != "" {
+				if _, exists := doc.metas[key]; !exists {
+					doc.metas[key] = strings.TrimSpace(nodeAttr(n, "content"))
+				}
+			}
+		}
+		return true
+	})
+
+	return doc, nil
+}
+
+// walkNodes recorre el árbol en profundidad; si visit retorna false no baja a los hijos del nodo
+func walkNodes(n *html.Node, visit func(*html.Node) bool) {
+	if !visit(n) {
+		return
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		walkNodes(child, visit)
+	}
+}
+
+// nodeAttr retorna el valor de un atributo (vacío si no existe)
+func nodeAttr(n *html.Node, name string) string {
+	if n == nil {
+		return ""
+	}
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// nodeText retorna el texto visible del nodo con los espacios normalizados
+func nodeText(n *html.Node) string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	walkNodes(n, func(node *html.Node) bool {
+		if node.Type == html.ElementNode && (node.Data == "script" || node.Data == "style") {
+			return false
+		}
+		if node.Type == html.TextNode {
+			b.WriteString(node.Data)
+			b.WriteByte(' ')
+		}
+		return true
+	})
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// scriptText retorna el contenido crudo de un <script>
+func scriptText(n *html.Node) string {
+	var b strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == html.TextNode {
+			b.WriteString(child.Data)
+		}
+	}
+	return b.String()
+}
+
+// initialState retorna el objeto asignado a window.__INITIAL_STATE__, si la página lo incluye
+func (d *htmlDocument) initialState() map[string]interface{} {
+	for _, script := range d.scripts {
+		text := scriptText(script)
+		raw, ok := assignedJSON(text, "__INITIAL_STATE__")
+		if !ok {
+			continue
+		}
+		var state map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &state); err == nil {
+			return state
+		}
+	}
+	return nil
+}
+
+// nextData retorna el JSON del script __NEXT_DATA__ de Next.js, si la página lo incluye
+func (d *htmlDocument) nextData() map[string]interface{} {
+	for _, script := range d.scripts {
+		if nodeAttr(script, "id") != "__NEXT_DATA__" {
+			continue
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(scriptText(script))), &data); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// jsonLDObjects retorna los objetos de todos los scripts application/ld+json,
+// aplanando listas y @graph
+func (d *htmlDocument) jsonLDObjects() []map[string]interface{} {
+	var objects []map[string]interface{}
+
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch value := v.(type) {
+		case []interface{}:
+			for _, item := range value {
+				collect(item)
+			}
+		case map[string]interface{}:
+			objects = append(objects, value)
+			if graph, ok := value["@graph"]; ok {
+				collect(graph)
+			}
+		}
+	}
+
+	for _, script := range d.scripts {
+		if !strings.EqualFold(strings.TrimSpace(nodeAttr(script, "type")), "application/ld+json") {
+			continue
+		}
+		var data interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(scriptText(script))), &data); err != nil {
+			continue
+		}
+		collect(data)
+	}
+	return objects
+}
+
+// jsonLDProducts retorna los objetos schema.org Product, incluidos los de un ItemList
+func (d *htmlDocument) jsonLDProducts() []map[string]interface{} {
+	var products []map[string]interface{}
+	for _, obj := range d.jsonLDObjects() {
+		switch {
+		case jsonLDIsType(obj, "Product"):
+			products = append(products, obj)
+		case jsonLDIsType(obj, "ItemList"):
+			elements, _ := obj["itemListElement"].([]interface{})
+			for _, element := range elements {
+				item, ok := element.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if inner, ok := item["item"].(map[string]interface{}); ok {
+					item = inner
+				}
+				if jsonLDIsType(item, "Product") {
+					products = append(products, item)
+				}
+			}
+		}
+	}
+	return products
+}
+
+// assignedJSON localiza `marker = {...}` en el texto de un script y retorna el JSON
+// completo, balanceando llaves y corchetes (respetando strings y escapes)
+func assignedJSON(text, marker string) (string, bool) {
+	idx := strings.Index(text, marker)
+	if idx < 0 {
+		return "", false
+	}
+	rest := strings.TrimLeft(text[idx+len(marker):], " \t\r\n")
+	if !strings.HasPrefix(rest, "=") {
+		return "", false
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	return balancedJSON(rest)
+}
+
+// balancedJSON retorna el objeto o arreglo JSON con el que comienza s
+func balancedJSON(s string) (string, bool) {
+	if s == "" || (s[0] != '{' && s[0] != '[') {
+		return "", false
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return s[:i+1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// jsonLDIsType indica si el @type del objeto (string o lista) incluye typeName
+func jsonLDIsType(obj map[string]interface{}, typeName string) bool {
+	switch t := obj["@type"].(type) {
+	case string:
+		return strings.EqualFold(strings.TrimPrefix(t, "schema:"), typeName)
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok && strings.EqualFold(strings.TrimPrefix(s, "schema:"), typeName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonLDString lee un texto que puede venir como string, número u objeto con name/@value
+func jsonLDString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case map[string]interface{}:
+		if name, ok := value["name"].(string); ok {
+			return strings.TrimSpace(name)
+		}
+		if raw, ok := value["@value"].(string); ok {
+			return strings.TrimSpace(raw)
+		}
+	case []interface{}:
+		if len(value) > 0 {
+			return jsonLDString(value[0])
+		}
+	}
+	return ""
+}
+
+// jsonLDStrings lee una lista de textos o URLs (string, lista u objetos con url)
+func jsonLDStrings(v interface{}) []string {
+	var values []string
+	switch value := v.(type) {
+	case string:
+		if value != "" {
+			values = append(values, value)
+		}
+	case []interface{}:
+		for _, item := range value {
+			values = append(values, jsonLDStrings(item)...)
+		}
+	case map[string]interface{}:
+		if u, ok := value["url"].(string); ok && u != "" {
+			values = append(values, u)
+		} else if u, ok := value["contentUrl"].(string); ok && u != "" {
+			values = append(values, u)
+		}
+	}
+	return values
+}
+
+// jsonLDNumber lee un número que puede venir como número o como string ("1990", "$1.990")
+func jsonLDNumber(v interface{}) float64 {
+	switch value := v.(type) {
+	case float64:
+		return value
+	case string:
+		if n, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return n
+		}
+		return parsePrice(value)
+	}
+	return 0
+}
+
+// jsonLDOffer retorna la primera oferta de un Product (offers puede ser objeto o lista)
+func jsonLDOffer(obj map[string]interface{}) map[string]interface{} {
+	switch offers := obj["offers"].(type) {
+	case map[string]interface{}:
+		return offers
+	case []interface{}:
+		for _, item := range offers {
+			if offer, ok := item.(map[string]interface{}); ok {
+				return offer
+			}
+		}
+	}
+	return nil
+}
+
+// isInStock interpreta valores de disponibilidad de schema.org y OpenGraph
+func isInStock(value string) bool {
+	v := strings.ToLower(strings.ReplaceAll(value, " ", ""))
+	if strings.Contains(v, "outofstock") || strings.Contains(v, "soldout") || strings.Contains(v, "unavailable") || strings.Contains(v, "discontinued") {
+		return false
+	}
+	return strings.Contains(v, "instock") || strings.Contains(v, "limitedavailability") ||
+		strings.Contains(v, "onlineonly") || strings.Contains(v, "available")
+}
+
+// priceFromText extrae el primer monto de un texto como "Ahora $1.990 c/u"
+func priceFromText(text string) float64 {
+	if idx := strings.Index(text, "$"); idx >= 0 {
+		text = text[idx+1:]
+	}
+	start := strings.IndexAny(text, "0123456789")
+	if start < 0 {
+		return 0
+	}
+	end := start
+	for end < len(text) && strings.ContainsRune("0123456789.,", rune(text[end])) {
+		end++
+	}
+	return parsePrice(text[start:end])
+}
+
+// domPrice interpreta un precio leído del DOM: un número simple (atributo content de
+// microdata) o un texto con formato de moneda
+func domPrice(text string) float64 {
+	if n, err := strconv.ParseFloat(strings.TrimSpace(text), 64); err == nil {
+		return n
+	}
+	return priceFromText(text)
+}
+
+// productFromJSONLD convierte un Product de schema.org en Product
+func productFromJSONLD(obj map[string]interface{}) Product {
+	product := Product{
+		ID:          jsonLDString(obj["sku"]),
+		Brand:       jsonLDString(obj["brand"]),
+		Description: jsonLDString(obj["description"]),
+		DisplayName: jsonLDString(obj["name"]),
+	}
+	if product.ID == "" {
+		product.ID = jsonLDString(obj["productID"])
+	}
+
+	if offer := jsonLDOffer(obj); offer != nil {
+		price := jsonLDNumber(offer["price"])
+		if price == 0 {
+			price = jsonLDNumber(offer["lowPrice"])
+		}
+		product.Price.BasePriceSales = price
+		product.Price.BasePriceReference = price
+		if high := jsonLDNumber(offer["highPrice"]); high > price {
+			product.Price.BasePriceReference = high
+		}
+	}
+
+	images := jsonLDStrings(obj["image"])
+	if len(images) > 0 {
+		product.Images.DefaultImage = images[0]
+	}
+	if len(images) > 1 {
+		product.Images.MediumImage = images[1]
+	}
+	return product
+}
+
+// productDetailFromJSONLD convierte un Product de schema.org en ProductDetail
+func productDetailFromJSONLD(obj map[string]interface{}) *ProductDetail {
+	detail := &ProductDetail{
+		SKU:          jsonLDString(obj["sku"]),
+		Name:         jsonLDString(obj["name"]),
+		Brand:        jsonLDString(obj["brand"]),
+		Description:  jsonLDString(obj["description"]),
+		Images:       jsonLDStrings(obj["image"]),
+		Category:     jsonLDString(obj["category"]),
+		URL:          jsonLDString(obj["url"]),
+		Availability: true,
+	}
+	if detail.SKU == "" {
+		detail.SKU = jsonLDString(obj["productID"])
+	}
+
+	if offer := jsonLDOffer(obj); offer != nil {
+		detail.Price.Current = jsonLDNumber(offer["price"])
+		if detail.Price.Current == 0 {
+			detail.Price.Current = jsonLDNumber(offer["lowPrice"])
+		}
+		detail.Price.Original = detail.Price.Current
+		if high := jsonLDNumber(offer["highPrice"]); high > detail.Price.Current {
+			detail.Price.Original = high
+		}
+		detail.Price.Currency = jsonLDString(offer["priceCurrency"])
+		if availability := jsonLDString(offer["availability"]); availability != "" {
+			detail.Availability = isInStock(availability)
+		}
+	}
+
+	if rating, ok := obj["aggregateRating"].(map[string]interface{}); ok {
+		detail.Rating = jsonLDNumber(rating["ratingValue"])
+		detail.ReviewCount = int(jsonLDNumber(rating["reviewCount"]))
+		if detail.ReviewCount == 0 {
+			detail.ReviewCount = int(jsonLDNumber(rating["ratingCount"]))
+		}
+	}
+
+	if detail.SKU == "" && detail.Name == "" {
+		return nil
+	}
+	return detail
+}
+
+// productDetailFromOpenGraph arma un ProductDetail (posiblemente incompleto) con los meta tags og:* y product:*
+func productDetailFromOpenGraph(metas map[string]string) *ProductDetail {
+	detail := &ProductDetail{
+		SKU:          metas["product:retailer_item_id"],
+		Name:         metas["og:title"],
+		Brand:        metas["product:brand"],
+		Description:  metas["og:description"],
+		Category:     metas["product:category"],
+		URL:          metas["og:url"],
+		Availability: true,
+	}
+	if image := metas["og:image"]; image != "" {
+		detail.Images = []string{image}
+	}
+
+	detail.Price.Current = jsonLDNumber(metas["product:price:amount"])
+	if detail.Price.Current == 0 {
+		detail.Price.Current = jsonLDNumber(metas["og:price:amount"])
+	}
+	detail.Price.Original = jsonLDNumber(metas["product:original_price:amount"])
+	if detail.Price.Original == 0 {
+		detail.Price.Original = detail.Price.Current
+	}
+	detail.Price.Currency = metas["product:price:currency"]
+
+	availability := metas["product:availability"]
+	if availability == "" {
+		availability = metas["og:availability"]
+	}
+	if availability != "" {
+		detail.Availability = isInStock(availability)
+	}
+	return detail
+}
+
+// usableDetail indica si un detalle tiene datos suficientes para responder por sí solo
+func usableDetail(detail *ProductDetail) bool {
+	return detail != nil && detail.Name != "" && (detail.SKU != "" || detail.Price.Current > 0)
+}
+
+// productsFromDOM extrae las tarjetas de producto (selector item de las reglas) de una página de resultados
+func productsFromDOM(rules *compiledPageRules, doc *htmlDocument) []Product {
+	var products []Product
+	for _, item := range cascadia.QueryAll(doc.root, rules.item) {
+		product := Product{
+			ID:          rules.selectText(doc, item, "id"),
+			DisplayName: rules.selectText(doc, item, "display_name"),
+			Brand:       rules.selectText(doc, item, "brand"),
+			Description: rules.selectText(doc, item, "description"),
+		}
+
+		if price := domPrice(rules.selectText(doc, item, "price_sales")); price > 0 {
+			product.Price.BasePriceSales = price
+			product.Price.BasePriceReference = price
+		}
+		if price := domPrice(rules.selectText(doc, item, "price_reference")); price > 0 {
+			product.Price.BasePriceReference = price
+		}
+		product.Images.DefaultImage = rules.selectText(doc, item, "image_default")
+		product.Images.MediumImage = rules.selectText(doc, item, "image_medium")
+
+		if product.ID != "" && product.DisplayName != "" {
+			products = append(products, product)
+		}
+	}
+	return products
+}
+
+// productDetailFromDOM extrae el detalle desde el markup de la página del producto
+// con los selectores de las reglas (por defecto microdata itemprop, clases
+// product-title/brand/price y el primer <h1>)
+func productDetailFromDOM(rules *compiledPageRules, doc *htmlDocument) *ProductDetail {
+	root := doc.root
+	detail := &ProductDetail{
+		SKU:          rules.selectText(doc, root, "sku"),
+		Name:         rules.selectText(doc, root, "name"),
+		Brand:        rules.selectText(doc, root, "brand"),
+		Description:  rules.selectText(doc, root, "description"),
+		Images:       rules.selectAll(doc, root, "images"),
+		Category:     rules.selectText(doc, root, "category"),
+		Availability: true,
+	}
+
+	detail.Price.Current = domPrice(rules.selectText(doc, root, "price_current"))
+	detail.Price.Original = domPrice(rules.selectText(doc, root, "price_original"))
+	if detail.Price.Original == 0 {
+		detail.Price.Original = detail.Price.Current
+	}
+	if detail.Price.Current > 0 {
+		detail.Price.Currency = firstNonEmpty(rules.selectText(doc, root, "currency"), "CLP")
+	}
+	if availability := rules.selectText(doc, root, "availability"); availability != "" {
+		detail.Availability = isInStock(availability)
+	}
+	detail.Stock = int(jsonLDNumber(rules.selectText(doc, root, "stock")))
+	detail.Rating = jsonLDNumber(rules.selectText(doc, root, "rating"))
+
+	if detail.SKU == "" && detail.Name == "" {
+		return nil
+	}
+	return detail
+}
+
+// scriptStringField busca `"field":"valor"` en los scripts de la página (JSON embebido sin formato conocido)
+func scriptStringField(doc *htmlDocument, field string) string {
+	marker := `"` + field + `":"`
+	for _, script := range doc.scripts {
+		text := scriptText(script)
+		idx := strings.Index(text, marker)
+		if idx < 0 {
+			continue
+		}
+		rest := text[idx+len(marker):]
+		if end := strings.IndexByte(rest, '"'); end > 0 {
+			return rest[:end]
+		}
+	}
+	return ""
+}
+
+// fillProductDetail completa los campos vacíos de dst con los de src
+func fillProductDetail(dst, src *ProductDetail) {
+	if src == nil {
+		return
+	}
+	if dst.SKU == "" {
+		dst.SKU = src.SKU
+	}
+	if dst.Name == "" {
+		dst.Name = src.Name
+	}
+	if dst.Brand == "" {
+		dst.Brand = src.Brand
+	}
+	if dst.Description == "" {
+		dst.Description = src.Description
+	}
+	if dst.Price.Current == 0 {
+		dst.Price = src.Price
+	}
+	if len(dst.Images) == 0 {
+		dst.Images = src.Images
+	}
+	if dst.Category == "" {
+		dst.Category = src.Category
+	}
+	if dst.Rating == 0 {
+		dst.Rating = src.Rating
+	}
+	if dst.URL == "" {
+		dst.URL = src.URL
+	}
+}
+
+// firstNonEmpty retorna el primer valor no vacío (sin espacios alrededor)
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
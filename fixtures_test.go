@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// updateGolden reescribe los archivos .golden.json con la salida actual:
+// go test -run TestFixtures -update
+var updateGolden = flag.Bool("update", false, "reescribir los archivos .golden.json con la salida actual")
+
+// fixtureSKU es el SKU con el que se extraen las fixtures de páginas de producto
+const fixtureSKU = "1234567"
+
+// goldenSuffix es la extensión de las salidas esperadas de cada fixture
+const goldenSuffix = ".golden.json"
+
+// fixtureExtractor ejecuta un extractor del scraper sobre el contenido de una fixture
+type fixtureExtractor struct {
+	run     func(ctx context.Context, body []byte) (interface{}, error)
+	samples func(out interface{}) []coverageSample
+}
+
+// fixtureExtractors asocia cada subdirectorio de testdata/fixtures con el extractor que prueba
+var fixtureExtractors = map[string]fixtureExtractor{
+	// AdvancedScraper.extractProductsFromHTML (búsqueda, promociones y categorías por scraping)
+	"search_page": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			return getAdvancedScraper().extractProductsFromHTML(ctx, string(body)), nil
+		},
+		samples: productSamples,
+	},
+	// AdvancedScraper.extractProductDetailFromHTML (fallback HTML del detalle)
+	"product_page": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			return getAdvancedScraper().extractProductDetailFromHTML(ctx, string(body)), nil
+		},
+		samples: detailSamples,
+	},
+	// convertToProducts sobre el JSON de los endpoints de API del AdvancedScraper
+	"api_products": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			var data interface{}
+			if err := json.Unmarshal(body, &data); err != nil {
+				return nil, err
+			}
+			return convertToProducts(data)
+		},
+		samples: productSamples,
+	},
+	// convertToProductDetail sobre el JSON de los endpoints de API del AdvancedScraper
+	"api_detail": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			var data interface{}
+			if err := json.Unmarshal(body, &data); err != nil {
+				return nil, err
+			}
+			return convertToProductDetail(data)
+		},
+		samples: detailSamples,
+	},
+	// decodeResponse (/search, /promotions y /category de apps.lider.cl)
+	"search_response": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			return decodeResponse(body)
+		},
+		samples: func(out interface{}) []coverageSample {
+			if r, ok := out.(*Response); ok && r != nil {
+				return productSamples(r.Products)
+			}
+			return nil
+		},
+	},
+	// decodeSuggestions (/suggestions de apps.lider.cl)
+	"suggestions_response": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			return decodeSuggestions(body)
+		},
+	},
+	// productDetailFromPage (fetchProductDetailViaScraping de scraper.go)
+	"legacy_product_page": {
+		run: func(ctx context.Context, body []byte) (interface{}, error) {
+			return productDetailFromPage(ctx, fixtureSKU, body)
+		},
+		samples: detailSamples,
+	},
+}
+
+// productSamples retorna la cobertura de una lista de productos
+func productSamples(out interface{}) []coverageSample {
+	products, _ := out.([]Product)
+	samples := make([]coverageSample, 0, len(products))
+	for _, p := range products {
+		samples = append(samples, productSample(p))
+	}
+	return samples
+}
+
+// detailSamples retorna la cobertura de un detalle de producto
+func detailSamples(out interface{}) []coverageSample {
+	if detail, ok := out.(*ProductDetail); ok && detail != nil {
+		return []coverageSample{detailSample(detail)}
+	}
+	return nil
+}
+
+// fixtureOutput es lo que se compara contra el archivo .golden.json
+type fixtureOutput struct {
+	Result interface{} `json:"result"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// fixtureCase es una muestra guardada y su salida esperada
+type fixtureCase struct {
+	extractor string
+	input     string
+	golden    string
+}
+
+// name identifica la fixture en el reporte (extractor/archivo)
+func (f fixtureCase) name() string {
+	return f.extractor + "/" + filepath.Base(f.input)
+}
+
+// TestFixtures ejecuta cada extractor sobre las muestras de testdata/fixtures y compara el
+// resultado con su .golden.json. Con -update reescribe las salidas esperadas.
+func TestFixtures(t *testing.T) {
+	cases, err := findFixtures(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fc := range cases {
+		t.Run(fc.name(), func(t *testing.T) {
+			got, samples, err := runFixture(t.Context(), fc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if coverage := formatCoverage(samples); coverage != "" {
+				t.Log("coverage:" + coverage)
+			}
+
+			if *updateGolden {
+				if err := os.WriteFile(fc.golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(fc.golden)
+			if err != nil {
+				t.Fatalf("missing %s (run go test -run TestFixtures -update to create it)", filepath.Base(fc.golden))
+			}
+			if diff := firstDifference(want, got); diff != "" {
+				t.Errorf("output differs from %s\n%s", filepath.Base(fc.golden), diff)
+			}
+		})
+	}
+}
+
+// findFixtures busca las muestras en dir/<extractor>/*; todo archivo que no sea .golden.json es una entrada
+func findFixtures(dir string) ([]fixtureCase, error) {
+	var cases []fixtureCase
+	for extractor := range fixtureExtractors {
+		entries, err := os.ReadDir(filepath.Join(dir, extractor))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || strings.HasSuffix(name, goldenSuffix) || strings.HasPrefix(name, ".") {
+				continue
+			}
+			input := filepath.Join(dir, extractor, name)
+			cases = append(cases, fixtureCase{
+				extractor: extractor,
+				input:     input,
+				golden:    strings.TrimSuffix(input, filepath.Ext(input)) + goldenSuffix,
+			})
+		}
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no fixtures found in %s", dir)
+	}
+
+	sort.Slice(cases, func(i, j int) bool { return cases[i].name() < cases[j].name() })
+	return cases, nil
+}
+
+// runFixture ejecuta el extractor de la fixture y retorna su salida serializada y la cobertura de campos
+func runFixture(ctx context.Context, fc fixtureCase) ([]byte, []coverageSample, error) {
+	body, err := os.ReadFile(fc.input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extractor := fixtureExtractors[fc.extractor]
+	result, runErr := extractor.run(ctx, body)
+
+	output := fixtureOutput{Result: result}
+	if runErr != nil {
+		output.Error = runErr.Error()
+	}
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var samples []coverageSample
+	if extractor.samples != nil && runErr == nil {
+		samples = extractor.samples(result)
+	}
+	return append(data, '\n'), samples, nil
+}
+
+// formatCoverage resume qué proporción de los productos extraídos trae cada campo
+func formatCoverage(samples []coverageSample) string {
+	if len(samples) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(coverageFields))
+	for _, field := range coverageFields {
+		populated := 0
+		for _, sample := range samples {
+			if sample[field] {
+				populated++
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%s %d/%d", field, populated, len(samples)))
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// firstDifference describe la primera línea en que difieren la salida esperada y la obtenida
+func firstDifference(want, got []byte) string {
+	if bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+		return ""
+	}
+	wantLines := strings.Split(strings.TrimSpace(string(want)), "\n")
+	gotLines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			return fmt.Sprintf("line %d\n  want: %s\n  got:  %s", i+1, strings.TrimSpace(w), strings.TrimSpace(g))
+		}
+	}
+	return ""
+}
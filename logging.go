@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader es el header con el que se recibe y se devuelve el ID de la solicitud
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limita el largo de los IDs recibidos desde el cliente
+const maxRequestIDLength = 128
+
+// setupLogging configura slog como logger por defecto según LOG_LEVEL (debug, info, warn, error)
+// y LOG_FORMAT (json por defecto, o text). Las llamadas a log.Printf también pasan por slog.
+func setupLogging() {
+	level, ok := parseLogLevel(os.Getenv("LOG_LEVEL"))
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "text") {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+
+	if !ok {
+		slog.Warn("Invalid LOG_LEVEL, using info", "value", os.Getenv("LOG_LEVEL"))
+	}
+}
+
+// parseLogLevel convierte el valor de LOG_LEVEL en un slog.Level; un valor vacío es info
+func parseLogLevel(raw string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	default:
+		return slog.LevelInfo, false
+	}
+}
+
+// requestIDKey es la clave de contexto que guarda el ID de la solicitud
+type requestIDKey struct{}
+
+// withRequestID agrega el ID de la solicitud al contexto
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom retorna el ID de la solicitud guardado en el contexto (vacío si no hay)
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// loggerFrom retorna el logger por defecto con el request_id y el trace_id del contexto, si existen
+func loggerFrom(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := requestIDFrom(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	return logger
+}
+
+// requestIDMiddleware propaga el X-Request-ID recibido o genera uno nuevo, lo guarda en el
+// contexto de la solicitud y lo devuelve en la respuesta
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			generated, err := randomHex(16)
+			if err != nil {
+				slog.Error("Failed to generate request ID", "error", err)
+			}
+			id = generated
+		}
+
+		if id != "" {
+			c.Request = c.Request.WithContext(withRequestID(c.Request.Context(), id))
+			c.Header(requestIDHeader, id)
+			trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+		}
+		c.Next()
+	}
+}
+
+// validRequestID acepta IDs cortos con caracteres seguros para logs y headers
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// accessLogMiddleware reemplaza el logger de gin por una línea estructurada por solicitud
+func accessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		loggerFrom(c.Request.Context()).Log(c.Request.Context(), level, "request completed",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"ip", c.ClientIP(),
+			"key", apiKeyName(c),
+			"bytes", c.Writer.Size(),
+		)
+	}
+}
@@ -1,14 +1,80 @@
 package main
 
 import (
-	"log"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
+	// Subcomandos de línea de comandos
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "genkey":
+			runGenKey(os.Args[2:])
+			return
+		case "mock-upstream":
+			runMockUpstream(os.Args[2:])
+			return
+		case "mock-proxy":
+			runMockProxy(os.Args[2:])
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command %q\nusage: lider-api [genkey [-name NAME] [-scopes search,detail] | mock-upstream [-addr :9090] [-fail MODE] [-fail-path PATH] [-fail-rate R] [-fail-count N] [-delay D] | mock-proxy [-addr :8888] [-name NAME] [-auth USER:PASS] [-fail MODE] [-fail-path PATH] [-fail-rate R] [-fail-count N] [-delay D]]\n", os.Args[1])
+			os.Exit(2)
+		}
+	}
+
+	// Structured logging (LOG_LEVEL, LOG_FORMAT)
+	setupLogging()
+
+	// OpenTelemetry tracing (OTEL_TRACES_EXPORTER=otlp|stdout)
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err)
+		}
+	}()
+
+	// Record or replay upstream exchanges (UPSTREAM_CASSETTE_MODE=record|replay)
+	getCassette()
+
+	// Send upstream requests to a local mock-upstream server (UPSTREAM_MOCK_URL)
+	getMockUpstreamURL()
+
+	// Load the upstream endpoint catalogue (UPSTREAM_ENDPOINTS_PATH, UPSTREAM_<HOST>_URL)
+	getUpstreamEndpoints()
+
+	// Skip upstream endpoints that keep failing (CIRCUIT_BREAKER_*)
+	getCircuitBreakers()
+
+	// Route scraper requests through outbound proxies (PROXY_URLS)
+	getProxyPool()
+
+	// Pause upstream requests while Lider's queue-it waiting room is active (QUEUEIT_*)
+	getQueueItGate()
+	getRequestQueue()
+
+	// Retry failed upstream requests per operation (RETRY_*)
+	getRetryPolicies()
+
 	// Get port from environment or default to 8080
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -20,13 +86,22 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	// One server span per request; upstream calls are recorded as child spans
+	router.Use(otelgin.Middleware("lider-api"))
+
+	// Propagate or generate X-Request-ID and log every request as structured JSON
+	router.Use(requestIDMiddleware())
+	router.Use(accessLogMiddleware())
 
 	// Add CORS middleware for better API compatibility
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, X-API-Key")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, X-API-Key, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "Retry-After, X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Quota-Limit, X-Quota-Remaining, X-Quota-Reset")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -35,6 +110,9 @@ func main() {
 		c.Next()
 	})
 
+	// Record request count and latency per route
+	router.Use(metricsMiddleware())
+
 	// Apply API key authentication
 	router.Use(apiKeyAuthMiddleware())
 
@@ -43,52 +121,159 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "ok", "service": "lider-api"})
 	})
 
+	// Prometheus metrics: on their own port without a key (METRICS_ADDR), or here with the admin scope
+	if !startMetricsServer() {
+		router.GET("/metrics", metricsHandler())
+	}
+
 	// API routes
 	router.GET("/productos", handleSearch)
+	router.GET("/productos/stream", handleSearchStream)
 	router.GET("/suggestions", handleSuggestions)
 	router.GET("/promotions", handlePromotions)
 	router.GET("/categories", handleCategories)
+	router.GET("/categories/stream", handleCategoryStream)
 	router.GET("/product/:sku", handleProductDetail)
+	router.GET("/product/:sku/history", handleProductHistory)
 	router.GET("/product", handleProductDetail) // /product?sku=4522432 or /product?url=...
+	router.POST("/alerts", handleCreateAlert)
+	router.GET("/alerts", handleListAlerts)
+	router.GET("/alerts/:id", handleGetAlert)
+	router.DELETE("/alerts/:id", handleDeleteAlert)
+	router.GET("/queue/:id", handleQueuedRequest)
+
+	// Extraction rules (requires a key with the admin scope)
+	router.GET("/admin/extraction-rules", handleGetExtractionRules)
+	router.POST("/admin/extraction-rules/reload", handleReloadExtractionRules)
+
+	// Scraper field coverage and drift events (requires a key with the admin scope)
+	router.GET("/admin/scraper-coverage", handleScraperCoverage)
+
+	// Upstream endpoint catalogue and circuit breakers (requires a key with the admin scope)
+	router.GET("/admin/upstream-endpoints", handleGetUpstreamEndpoints)
+	router.GET("/admin/circuit-breakers", handleCircuitBreakers)
+	router.POST("/admin/circuit-breakers/reset", handleResetCircuitBreakers)
+
+	// Outbound proxy pool (requires a key with the admin scope)
+	router.GET("/admin/proxies", handleProxies)
+
+	// Scraper browser sessions (requires a key with the admin scope)
+	router.GET("/admin/sessions", handleSessions)
+
+	// Queue-it waiting room state and parked requests (requires a key with the admin scope)
+	router.GET("/admin/queueit", handleQueueItStatus)
+
+	// Load extraction rules (EXTRACTION_RULES_PATH) and start watching the file
+	getExtractionRules()
+
+	// Background work stops when the server shuts down (SIGINT or SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start background price alert checks
+	if manager := getAlertManager(); manager != nil {
+		manager.Start(ctx)
+	}
 
-	log.Printf("Starting server on port %s", port)
-	log.Printf("Available endpoints:")
-	log.Printf("  GET /health - Health check")
-	log.Printf("  GET /productos?q=term - Search products")
-	log.Printf("  GET /suggestions?term=partial - Get suggestions")
-	log.Printf("  GET /promotions?type=promo - Get promotions")
-	log.Printf("  GET /categories?id=cat_id - Get category products")
-	log.Printf("  GET /product/:sku - Get product detail by SKU")
-	log.Printf("  GET /product?sku=sku - Get product detail by SKU parameter")
+	// Run requests parked while queue-it is active and forget expired results (QUEUEIT_PARK)
+	if queue := getRequestQueue(); queue != nil {
+		queue.Start(ctx)
+	}
+
+	slog.Info("Starting server", "port", port)
+	for _, endpoint := range availableEndpoints {
+		slog.Info("Endpoint available", "route", endpoint.route, "description", endpoint.description)
+	}
 
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	server := &http.Server{Addr: ":" + port, Handler: router}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		slog.Info("Shutting down server, waiting for in-flight requests", "timeout", shutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Server did not shut down cleanly", "error", err)
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("Failed to start server", "error", err)
+		os.Exit(1)
 	}
+	<-shutdownDone
+	closeResponseCache()
 }
 
+// availableEndpoints se listan en el log al iniciar el servidor
+var availableEndpoints = []struct{ route, description string }{
+	{"GET /health", "Health check"},
+	{"GET /metrics", "Prometheus metrics"},
+	{"GET /productos?q=term", "Search products"},
+	{"GET /productos/stream?q=term", "Stream every result page as NDJSON/SSE"},
+	{"GET /suggestions?term=partial", "Get suggestions"},
+	{"GET /promotions?type=promo", "Get promotions"},
+	{"GET /categories?id=cat_id", "Get category products"},
+	{"GET /categories/stream?id=cat_id", "Stream every category page as NDJSON/SSE"},
+	{"GET /product/:sku", "Get product detail by SKU"},
+	{"GET /product?sku=sku", "Get product detail by SKU parameter"},
+	{"GET /product/:sku/history", "Get observed price history for a SKU"},
+	{"POST /alerts", "Register a price alert webhook"},
+	{"GET /alerts", "List price alerts"},
+	{"DELETE /alerts/:id", "Delete a price alert"},
+	{"GET /queue/:id", "Result of a request parked while Lider's queue-it was active"},
+	{"GET /admin/extraction-rules", "Show the active extraction rules"},
+	{"POST /admin/extraction-rules/reload", "Reload EXTRACTION_RULES_PATH"},
+	{"GET /admin/scraper-coverage", "Field coverage and drift events of scraped products"},
+	{"GET /admin/upstream-endpoints", "Show the upstream endpoint catalogue"},
+	{"GET /admin/circuit-breakers", "Circuit breaker state of each upstream endpoint"},
+	{"POST /admin/circuit-breakers/reset?endpoint=name", "Close one or every circuit breaker"},
+	{"GET /admin/proxies", "Health and eviction state of the outbound proxies"},
+	{"GET /admin/sessions", "Scraper browser sessions and their fingerprints"},
+	{"GET /admin/queueit", "Queue-it waiting room state and parked requests"},
+}
+
+// shutdownTimeout es cuánto se espera a las solicitudes en curso al apagar el servidor
+const shutdownTimeout = 15 * time.Second
+
 func handleSearch(c *gin.Context) {
 	q := c.Query("q")
 	if q == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "se requiere parámetro 'q'",
+			"code":    ErrInvalidInput,
 			"example": "/productos?q=leche",
 		})
 		return
 	}
-	prods, err := fetchProductsAdvanced(q)
+	page, err := parsePageRequest(c)
 	if err != nil {
-		log.Printf("Error fetching products for query '%s': %v", q, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Error interno del servidor",
-			"message": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"query":    q,
-		"count":    len(prods),
-		"products": prods,
-	})
+	search := func(ctx context.Context, c *gin.Context) (interface{}, error) {
+		result, meta, err := fetchProductsAdvanced(ctx, q, page)
+		if err != nil {
+			return nil, err
+		}
+		return paginationEnvelope(c, gin.H{
+			"query":             q,
+			"count":             len(result.Products),
+			"products":          result.Products,
+			"source":            meta.Source,
+			"cache_age_seconds": int(meta.CacheAge.Seconds()),
+		}, result), nil
+	}
+	body, err := search(c.Request.Context(), c)
+	if err != nil {
+		loggerFrom(c.Request.Context()).Error("Error fetching products", "query", q, "key", apiKeyName(c), "error", err)
+		if !parkQueued(c, err, endpointSearch, search) {
+			respondError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, body)
 }
 
 func handleSuggestions(c *gin.Context) {
@@ -96,24 +281,31 @@ func handleSuggestions(c *gin.Context) {
 	if term == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "se requiere parámetro 'term'",
+			"code":    ErrInvalidInput,
 			"example": "/suggestions?term=lec",
 		})
 		return
 	}
-	suggestions, err := fetchSuggestionsAdvanced(term)
+	suggest := func(ctx context.Context, c *gin.Context) (interface{}, error) {
+		suggestions, err := fetchSuggestionsAdvanced(ctx, term)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{
+			"term":        term,
+			"count":       len(suggestions),
+			"suggestions": suggestions,
+		}, nil
+	}
+	body, err := suggest(c.Request.Context(), c)
 	if err != nil {
-		log.Printf("Error fetching suggestions for term '%s': %v", term, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Error interno del servidor",
-			"message": err.Error(),
-		})
+		loggerFrom(c.Request.Context()).Error("Error fetching suggestions", "term", term, "key", apiKeyName(c), "error", err)
+		if !parkQueued(c, err, endpointSuggestions, suggest) {
+			respondError(c, err)
+		}
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"term":        term,
-		"count":       len(suggestions),
-		"suggestions": suggestions,
-	})
+	c.JSON(http.StatusOK, body)
 }
 
 func handlePromotions(c *gin.Context) {
@@ -121,24 +313,38 @@ func handlePromotions(c *gin.Context) {
 	if promo == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "se requiere parámetro 'type'",
+			"code":    ErrInvalidInput,
 			"example": "/promotions?type=descuentos",
 		})
 		return
 	}
-	prods, err := fetchPromotionsAdvanced(promo)
+	page, err := parsePageRequest(c)
 	if err != nil {
-		log.Printf("Error fetching promotions for type '%s': %v", promo, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Error interno del servidor",
-			"message": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"type":     promo,
-		"count":    len(prods),
-		"products": prods,
-	})
+	promotions := func(ctx context.Context, c *gin.Context) (interface{}, error) {
+		result, meta, err := fetchPromotionsAdvanced(ctx, promo, page)
+		if err != nil {
+			return nil, err
+		}
+		return paginationEnvelope(c, gin.H{
+			"type":              promo,
+			"count":             len(result.Products),
+			"products":          result.Products,
+			"source":            meta.Source,
+			"cache_age_seconds": int(meta.CacheAge.Seconds()),
+		}, result), nil
+	}
+	body, err := promotions(c.Request.Context(), c)
+	if err != nil {
+		loggerFrom(c.Request.Context()).Error("Error fetching promotions", "type", promo, "key", apiKeyName(c), "error", err)
+		if !parkQueued(c, err, endpointPromotions, promotions) {
+			respondError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, body)
 }
 
 func handleCategories(c *gin.Context) {
@@ -146,24 +352,38 @@ func handleCategories(c *gin.Context) {
 	if cat == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "se requiere parámetro 'id'",
+			"code":    ErrInvalidInput,
 			"example": "/categories?id=123",
 		})
 		return
 	}
-	prods, err := fetchCategoryAdvanced(cat)
+	page, err := parsePageRequest(c)
 	if err != nil {
-		log.Printf("Error fetching category for id '%s': %v", cat, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Error interno del servidor",
-			"message": err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
-		"category_id": cat,
-		"count":       len(prods),
-		"products":    prods,
-	})
+	category := func(ctx context.Context, c *gin.Context) (interface{}, error) {
+		result, meta, err := fetchCategoryAdvanced(ctx, cat, page)
+		if err != nil {
+			return nil, err
+		}
+		return paginationEnvelope(c, gin.H{
+			"category_id":       cat,
+			"count":             len(result.Products),
+			"products":          result.Products,
+			"source":            meta.Source,
+			"cache_age_seconds": int(meta.CacheAge.Seconds()),
+		}, result), nil
+	}
+	body, err := category(c.Request.Context(), c)
+	if err != nil {
+		loggerFrom(c.Request.Context()).Error("Error fetching category", "category", cat, "key", apiKeyName(c), "error", err)
+		if !parkQueued(c, err, endpointCategory, category) {
+			respondError(c, err)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, body)
 }
 
 func handleProductDetail(c *gin.Context) {
@@ -180,19 +400,73 @@ func handleProductDetail(c *gin.Context) {
 	if sku == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "se requiere parámetro 'sku' o 'url'",
+			"code":    ErrInvalidInput,
 			"example": "/product/4522432 o /product?sku=4522432 o /product?url=https://www.lider.cl/supermercado/product/sku/4522432/...",
 		})
 		return
 	}
 
-	detail, err := fetchProductDetailAdvanced(sku)
+	productDetail := func(ctx context.Context, c *gin.Context) (interface{}, error) {
+		detail, meta, err := fetchProductDetailAdvanced(ctx, sku)
+		if err != nil {
+			return nil, err
+		}
+		return productDetailResponse{
+			ProductDetail:   detail,
+			Source:          meta.Source,
+			CacheAgeSeconds: int(meta.CacheAge.Seconds()),
+		}, nil
+	}
+	body, err := productDetail(c.Request.Context(), c)
 	if err != nil {
-		log.Printf("Error fetching product detail for SKU '%s': %v", sku, err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   "Error interno del servidor",
-			"message": err.Error(),
-		})
+		loggerFrom(c.Request.Context()).Error("Error fetching product detail", "sku", sku, "key", apiKeyName(c), "error", err)
+		if !parkQueued(c, err, endpointDetail, productDetail) {
+			respondError(c, err)
+		}
 		return
 	}
-	c.JSON(http.StatusOK, detail)
+	c.JSON(http.StatusOK, body)
+}
+
+// productDetailResponse agrega el origen de los datos al detalle del producto
+type productDetailResponse struct {
+	*ProductDetail
+	Source          string `json:"source"`
+	CacheAgeSeconds int    `json:"cache_age_seconds"`
+}
+
+// runGenKey genera una nueva API key e imprime su hash para configurarla
+// en API_KEYS_FILE, API_KEYS o API_KEY_HASH sin guardar el secreto en texto plano
+func runGenKey(args []string) {
+	fs := flag.NewFlagSet("genkey", flag.ExitOnError)
+	name := fs.String("name", "default", "nombre de la key")
+	scopes := fs.String("scopes", scopeAdmin, "scopes separados por coma (search, detail, alerts, admin)")
+	fs.Parse(args)
+
+	key, err := generateAPIKey()
+	if err != nil {
+		slog.Error("Failed to generate API key", "error", err)
+		os.Exit(1)
+	}
+	hash, err := hashAPIKey(key)
+	if err != nil {
+		slog.Error("Failed to hash API key", "error", err)
+		os.Exit(1)
+	}
+
+	entry := APIKey{
+		Name:    *name,
+		KeyHash: hash,
+		Scopes:  strings.Split(*scopes, ","),
+	}
+	registry := &APIKeyRegistry{keys: []*APIKey{&entry}}
+	if err := registry.validate(); err != nil {
+		slog.Error("Invalid API key entry", "error", err)
+		os.Exit(1)
+	}
+	config, _ := json.Marshal(entry)
+
+	fmt.Printf("API key (entrégala al cliente, no se vuelve a mostrar):\n  %s\n\n", key)
+	fmt.Printf("Hash (para API_KEY_HASH):\n  %s\n\n", hash)
+	fmt.Printf("Entrada para API_KEYS_FILE / API_KEYS:\n  %s\n", config)
 }
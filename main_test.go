@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// offlineTransport falla cualquier petición que llegue a la red: en las pruebas Lider se
+// responde desde las cassettes de testdata/cassettes
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("network disabled in tests: " + req.URL.String())
+}
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "lider-api-test")
+	if err != nil {
+		panic(err)
+	}
+
+	// Se leen en el primer uso de cada singleton, así que basta con fijarlas antes de correr
+	os.Setenv("UPSTREAM_CASSETTE_MODE", cassetteReplay)
+	os.Setenv("UPSTREAM_CASSETTE_DIR", filepath.Join("testdata", "cassettes"))
+	os.Setenv("CACHE_DISABLED", "true")
+	os.Setenv("PRICE_HISTORY_PATH", filepath.Join(dir, "price_history.jsonl"))
+	setUpstreamTransport(upstreamTransport(offlineTransport{}))
+	// Las pruebas de cache cambian CACHE_DISABLED; el singleton debe leerlo antes
+	getResponseCache()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry es el registro propio de la API (métricas de la API más las de proceso y runtime de Go)
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_api_http_requests_total",
+		Help: "Solicitudes HTTP atendidas por ruta, método y status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lider_api_http_request_duration_seconds",
+		Help:    "Latencia de las solicitudes HTTP por ruta y método.",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"route", "method"})
+
+	upstreamRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_upstream_requests_total",
+		Help: "Peticiones hechas a Lider por host, status y origen (api, scraping, public).",
+	}, []string{"host", "status", "source"})
+
+	upstreamRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_upstream_retries_total",
+		Help: "Reintentos de peticiones a Lider por host.",
+	}, []string{"host"})
+
+	queueItBlocksTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_queueit_blocks_total",
+		Help: "Respuestas bloqueadas por queue-it, por host y por dónde se detectó (redirect o body).",
+	}, []string{"host", "detected_in"})
+
+	rateLimiterWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "lider_rate_limiter_wait_seconds",
+		Help:    "Tiempo esperado por un turno del rate limiter del AdvancedScraper.",
+		Buckets: []float64{0.001, 0.01, 0.1, 0.5, 1, 2, 5, 10, 30},
+	})
+
+	fetchResultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_fetch_results_total",
+		Help: "Resultados de las operaciones del scraper por operación y origen final (api, scraping, cache, none).",
+	}, []string{"operation", "source"})
+
+	cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_cache_requests_total",
+		Help: "Consultas al cache de respuestas por endpoint y resultado (hit, miss).",
+	}, []string{"endpoint", "result"})
+
+	scraperFieldCoverage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lider_scraper_field_coverage",
+		Help: "Proporción de los productos extraídos recientemente con el campo poblado (name, price, image), por endpoint y origen.",
+	}, []string{"endpoint", "source", "field"})
+
+	scraperDriftEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_scraper_drift_events_total",
+		Help: "Veces que la cobertura de un campo cayó bajo COVERAGE_DRIFT_THRESHOLD, por endpoint, origen y campo.",
+	}, []string{"endpoint", "source", "field"})
+
+	upstreamCircuitState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lider_upstream_circuit_state",
+		Help: "Estado del circuit breaker de cada endpoint del catálogo (0 closed, 1 half_open, 2 open).",
+	}, []string{"endpoint"})
+
+	upstreamCircuitTransitionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_upstream_circuit_transitions_total",
+		Help: "Cambios de estado de los circuit breakers por endpoint del catálogo y estado de destino.",
+	}, []string{"endpoint", "state"})
+
+	upstreamCircuitRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_upstream_circuit_rejections_total",
+		Help: "Consultas a un endpoint del catálogo que se saltaron por tener el circuito abierto.",
+	}, []string{"endpoint"})
+
+	proxyRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_proxy_requests_total",
+		Help: "Peticiones del scraper por proxy de salida y resultado (success, blocked, error).",
+	}, []string{"proxy", "result"})
+
+	proxyHealthScore = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "lider_proxy_health_score",
+		Help: "Puntaje de salud de cada proxy de salida, entre 0 y 1 (promedio móvil de peticiones exitosas).",
+	}, []string{"proxy"})
+
+	proxyEvictionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_proxy_evictions_total",
+		Help: "Veces que un proxy de salida se sacó del pool por fallas seguidas.",
+	}, []string{"proxy"})
+
+	scraperSessionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "lider_scraper_sessions",
+		Help: "Sesiones de navegador abiertas en el pool del scraper.",
+	})
+
+	scraperSessionRetirementsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "lider_scraper_session_retirements_total",
+		Help: "Sesiones del scraper retiradas y reemplazadas por haber sido bloqueadas.",
+	})
+
+	scraperSessionWarmupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lider_scraper_session_warmups_total",
+		Help: "Visitas a la página de inicio de las sesiones nuevas por resultado (success, error).",
+	}, []string{"result"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		upstreamRequestsTotal,
+		upstreamRetriesTotal,
+		queueItBlocksTotal,
+		rateLimiterWait,
+		fetchResultsTotal,
+		cacheRequestsTotal,
+		scraperFieldCoverage,
+		scraperDriftEventsTotal,
+		upstreamCircuitState,
+		upstreamCircuitTransitionsTotal,
+		upstreamCircuitRejectionsTotal,
+		proxyRequestsTotal,
+		proxyHealthScore,
+		proxyEvictionsTotal,
+		scraperSessionsActive,
+		scraperSessionRetirementsTotal,
+		scraperSessionWarmupsTotal,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lider_cache_hit_ratio",
+			Help: "Proporción de consultas al cache que fueron hit desde el inicio del proceso.",
+		}, cacheHitRatio),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lider_queueit_wait_seconds",
+			Help: "Segundos que faltan para volver a consultar a Lider mientras su fila de queue-it está activa (0 si no lo está).",
+		}, queueItWaitSeconds),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "lider_queueit_parked_requests",
+			Help: "Solicitudes estacionadas esperando que se abra la fila de queue-it (QUEUEIT_PARK).",
+		}, parkedRequests),
+	)
+}
+
+// metricsMiddleware registra conteo y latencia de cada solicitud por ruta de gin
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler expone las métricas en formato de texto de Prometheus
+func metricsHandler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
+
+// startMetricsServer sirve GET /metrics sin API key en METRICS_ADDR (p. ej. ":9091"), un puerto
+// que no se publica junto con la API. Retorna false si METRICS_ADDR no está configurada; en ese
+// caso /metrics queda en el router principal y requiere una key con scope admin.
+func startMetricsServer() bool {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		return false
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Metrics server stopped", "addr", addr, "error", err)
+		}
+	}()
+	slog.Info("Serving Prometheus metrics without API key", "addr", addr)
+	return true
+}
+
+// upstreamSourceKey es la clave de contexto que indica el origen de una petición upstream
+type upstreamSourceKey struct{}
+
+// withUpstreamSource marca el contexto con el origen ("api", "scraping") de las peticiones que se hagan con él
+func withUpstreamSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, upstreamSourceKey{}, source)
+}
+
+// upstreamSource retorna el origen marcado en el contexto, o "public" para el cliente HTTP básico
+func upstreamSource(ctx context.Context) string {
+	if source, ok := ctx.Value(upstreamSourceKey{}).(string); ok {
+		return source
+	}
+	return "public"
+}
+
+// instrumentedTransport cuenta cada petición upstream por host, status y origen
+type instrumentedTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip ejecuta la petición con el transport base y registra el resultado
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	upstreamRequestsTotal.WithLabelValues(req.URL.Host, status, upstreamSource(req.Context())).Inc()
+
+	return resp, err
+}
+
+// Totales de cache para calcular lider_cache_hit_ratio sin leer los contadores de Prometheus
+var cacheHits, cacheLookups atomic.Int64
+
+// recordFetchResult registra el origen final de una operación del scraper
+func recordFetchResult(operation, source string) {
+	if source == "" {
+		source = "none"
+	}
+	fetchResultsTotal.WithLabelValues(operation, source).Inc()
+}
+
+// recordCacheLookup registra un hit o miss del cache de respuestas
+func recordCacheLookup(endpoint string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+		cacheHits.Add(1)
+	}
+	cacheLookups.Add(1)
+	cacheRequestsTotal.WithLabelValues(endpoint, result).Inc()
+}
+
+// cacheHitRatio calcula hits / (hits + misses) sumando todos los endpoints
+func cacheHitRatio() float64 {
+	total := cacheLookups.Load()
+	if total == 0 {
+		return 0
+	}
+	return float64(cacheHits.Load()) / float64(total)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// histogramCount retorna cuántas observaciones tiene la serie de lider_api_http_request_duration_seconds
+func histogramCount(t *testing.T, route, method string) uint64 {
+	t.Helper()
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "lider_api_http_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["route"] == route && labels["method"] == method {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return 0
+}
+
+func TestMetricsMiddlewareCountsRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metricsMiddleware())
+	router.GET("/product/:sku", func(c *gin.Context) {
+		if c.Param("sku") == "0" {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+
+	ok := httpRequestsTotal.WithLabelValues("/product/:sku", "GET", "200")
+	notFound := httpRequestsTotal.WithLabelValues("/product/:sku", "GET", "404")
+	unmatched := httpRequestsTotal.WithLabelValues("unmatched", "GET", "404")
+	okBefore, notFoundBefore, unmatchedBefore := testutil.ToFloat64(ok), testutil.ToFloat64(notFound), testutil.ToFloat64(unmatched)
+	observedBefore := histogramCount(t, "/product/:sku", "GET")
+
+	for _, target := range []string{"/product/4522432", "/product/123", "/product/0", "/nope"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	if got := testutil.ToFloat64(ok) - okBefore; got != 2 {
+		t.Errorf("200 requests counted = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(notFound) - notFoundBefore; got != 1 {
+		t.Errorf("404 requests counted = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(unmatched) - unmatchedBefore; got != 1 {
+		t.Errorf("unmatched requests counted = %v, want 1", got)
+	}
+	// El histograma se etiqueta por ruta, no por status: las tres solicitudes a /product/:sku
+	if got := histogramCount(t, "/product/:sku", "GET") - observedBefore; got != 3 {
+		t.Errorf("latency observations = %d, want 3", got)
+	}
+}
+
+func TestInstrumentedTransportCountsUpstreamRequests(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &instrumentedTransport{base: http.DefaultTransport}}
+	req, _ := http.NewRequestWithContext(withUpstreamSource(t.Context(), "scraping"), "GET", server.URL, nil)
+	host := req.URL.Host
+	counter := upstreamRequestsTotal.WithLabelValues(host, "503", "scraping")
+	before := testutil.ToFloat64(counter)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := testutil.ToFloat64(counter) - before; got != 1 {
+		t.Errorf("upstream requests counted = %v, want 1", got)
+	}
+
+	// Un error de red se cuenta con status "error"
+	failed := upstreamRequestsTotal.WithLabelValues("127.0.0.1:1", "error", "public")
+	before = testutil.ToFloat64(failed)
+	if resp, err := client.Get("http://127.0.0.1:1/"); err == nil {
+		resp.Body.Close()
+	}
+	if got := testutil.ToFloat64(failed) - before; got != 1 {
+		t.Errorf("failed upstream requests counted = %v, want 1", got)
+	}
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	cacheHits.Store(0)
+	cacheLookups.Store(0)
+	hits := cacheRequestsTotal.WithLabelValues("test", "hit")
+	misses := cacheRequestsTotal.WithLabelValues("test", "miss")
+
+	recordCacheLookup("test", true)
+	recordCacheLookup("test", false)
+	recordCacheLookup("test", true)
+	recordCacheLookup("test", true)
+
+	if got := testutil.ToFloat64(hits); got != 3 {
+		t.Errorf("cache hits = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(misses); got != 1 {
+		t.Errorf("cache misses = %v, want 1", got)
+	}
+	if got := cacheHitRatio(); got != 0.75 {
+		t.Errorf("cacheHitRatio() = %v, want 0.75", got)
+	}
+}
+
+func TestMetricsHandlerExposesRegistry(t *testing.T) {
+	recordFetchResult("search", "")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(metricsMiddleware())
+	router.GET("/metrics", metricsHandler())
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/metrics", nil))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{
+		`lider_fetch_results_total{operation="search",source="none"}`,
+		`lider_api_http_requests_total{method="GET",route="/metrics",status="200"}`,
+		"# TYPE lider_api_http_request_duration_seconds histogram",
+		"# TYPE lider_rate_limiter_wait_seconds histogram",
+		"lider_cache_hit_ratio",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output is missing %q", want)
+		}
+	}
+}
@@ -2,21 +2,26 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// apiKeyAuthMiddleware fuerza que el header X-API-Key coincida con la variable de entorno API_KEY
+// apiKeyAuthMiddleware valida el header X-API-Key contra el registro de keys
+// (API_KEYS_FILE, API_KEYS o API_KEY) y verifica que la key tenga el scope de la ruta.
 // Excluye el endpoint /health de la autenticación
 func apiKeyAuthMiddleware() gin.HandlerFunc {
-	apiKey := os.Getenv("API_KEY")
-	if apiKey == "" {
-		log.Fatal("API_KEY environment variable is not set")
+	registry, err := loadAPIKeyRegistry()
+	if err != nil {
+		slog.Error("Failed to load API keys", "error", err)
+		os.Exit(1)
 	}
+	slog.Info("API keys loaded", "count", len(registry.keys))
+	limiter := newKeyRateLimiter()
 
 	return func(c *gin.Context) {
 		// Skip authentication for health check endpoint
@@ -28,31 +33,102 @@ func apiKeyAuthMiddleware() gin.HandlerFunc {
 		startTime := time.Now()
 		clientIP := c.ClientIP()
 		userAgent := c.GetHeader("User-Agent")
+		logger := loggerFrom(c.Request.Context()).With("ip", clientIP, "path", c.Request.URL.Path)
 
 		key := c.GetHeader("X-API-Key")
 		if key == "" {
-			log.Printf("AUTH FAILED: Missing API key - IP: %s, UA: %s, Path: %s",
-				clientIP, userAgent, c.Request.URL.Path)
+			logger.Warn("AUTH FAILED: missing API key", "ua", userAgent)
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"error": "API key is required",
+				"code":  "missing_api_key",
 				"hint":  "Include X-API-Key header with your request",
 			})
 			return
 		}
 
-		if key != apiKey {
-			log.Printf("AUTH FAILED: Invalid API key - IP: %s, UA: %s, Path: %s",
-				clientIP, userAgent, c.Request.URL.Path)
+		apiKey, ok := registry.Lookup(key)
+		if !ok {
+			logger.Warn("AUTH FAILED: invalid API key", "ua", userAgent)
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
 				"error": "Invalid API key",
+				"code":  "invalid_api_key",
+			})
+			return
+		}
+
+		if apiKey.Disabled {
+			logger.Warn("AUTH FAILED: disabled API key", "key", apiKey.Name)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "API key is disabled",
+				"code":  "api_key_disabled",
+			})
+			return
+		}
+
+		if apiKey.Expired(time.Now()) {
+			logger.Warn("AUTH FAILED: expired API key", "key", apiKey.Name)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "API key has expired",
+				"code":  "api_key_expired",
+			})
+			return
+		}
+
+		// Las rutas inexistentes no tienen scope: se dejan pasar para que gin responda 404
+		if route := c.FullPath(); route != "" {
+			if scope := requiredScope(route); scope != "" && !apiKey.Allows(scope) {
+				logger.Warn("AUTH FAILED: API key lacks scope", "key", apiKey.Name, "scope", scope)
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error": "API key is not allowed to access this endpoint",
+					"code":  "insufficient_scope",
+					"scope": scope,
+				})
+				return
+			}
+		}
+
+		c.Set(ctxKeyAPIKeyName, apiKey.Name)
+
+		// Límites por key: token bucket y cuota diaria
+		decision := limiter.Allow(apiKey, time.Now())
+		setRateLimitHeaders(c, decision)
+		if !decision.Allowed {
+			logger.Warn("RATE LIMITED", "key", apiKey.Name, "reason", decision.Reason)
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			message, code := "Rate limit exceeded", "rate_limited"
+			if decision.Reason == "daily_quota" {
+				message, code = "Daily quota exceeded", "quota_exceeded"
+			}
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       message,
+				"code":        code,
+				"retry_after": ceilSeconds(decision.RetryAfter),
 			})
 			return
 		}
 
 		// Log successful authentication
-		log.Printf("AUTH SUCCESS: IP: %s, Path: %s, Time: %v",
-			clientIP, c.Request.URL.Path, time.Since(startTime))
+		logger.Debug("AUTH SUCCESS", "key", apiKey.Name, "duration", time.Since(startTime))
 
 		c.Next()
 	}
 }
+
+// setRateLimitHeaders agrega los headers X-RateLimit-* (y X-Quota-* si la key tiene cuota diaria)
+func setRateLimitHeaders(c *gin.Context, decision rateLimitDecision) {
+	if decision.Limit > 0 {
+		c.Header("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+	}
+	if decision.Quota > 0 {
+		c.Header("X-Quota-Limit", strconv.Itoa(decision.Quota))
+		c.Header("X-Quota-Remaining", strconv.Itoa(decision.QuotaLeft))
+		c.Header("X-Quota-Reset", strconv.Itoa(ceilSeconds(decision.QuotaReset)))
+	}
+}
+
+// apiKeyName retorna el nombre de la key autenticada en la solicitud (vacío si no hay)
+func apiKeyName(c *gin.Context) string {
+	return c.GetString(ctxKeyAPIKeyName)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hopHeaders son los headers de conexión que un proxy no reenvía
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// mockProxy es un proxy HTTP de reenvío local que reemplaza a un proxy de salida real al probar
+// PROXY_URLS. Reenvía peticiones con URI absoluta, abre túneles CONNECT y acepta las mismas
+// fallas inyectables que mock-upstream.
+type mockProxy struct {
+	mockControl
+	name      string
+	auth      string // usuario:clave que se exige en Proxy-Authorization (vacío: sin autenticación)
+	control   http.Handler
+	transport *http.Transport
+}
+
+// runMockProxy inicia el proxy local (subcomando mock-proxy)
+func runMockProxy(args []string) {
+	fs := flag.NewFlagSet("mock-proxy", flag.ExitOnError)
+	addr := fs.String("addr", ":8888", "dirección donde escuchar")
+	name := fs.String("name", "", "nombre del proxy en el header Via (por defecto, la dirección)")
+	auth := fs.String("auth", "", "exigir credenciales usuario:clave")
+	mode := fs.String("fail", "", "falla a inyectar ("+strings.Join(mockFailureModes, ", ")+")")
+	path := fs.String("fail-path", "", "solo fallar peticiones cuyo host+ruta contiene este texto")
+	rate := fs.Float64("fail-rate", 0, "probabilidad de fallar, entre 0 y 1 (0: siempre)")
+	count := fs.Int("fail-count", 0, "fallar solo las primeras N peticiones (0: sin límite)")
+	delay := fs.String("delay", "10s", "espera del modo slow")
+	fs.Parse(args)
+
+	setupLogging()
+	gin.SetMode(gin.ReleaseMode)
+
+	proxy := &mockProxy{
+		name: *name,
+		auth: *auth,
+		transport: &http.Transport{
+			MaxIdleConns:        30,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	if proxy.name == "" {
+		proxy.name = "mock-proxy" + *addr
+	}
+	failure := MockFailure{Mode: *mode, Path: *path, Rate: *rate, Count: *count, Delay: *delay}
+	if err := proxy.setFailure(failure); err != nil {
+		slog.Error("Invalid failure", "error", err)
+		os.Exit(1)
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	proxy.routes(router)
+	proxy.control = router
+
+	slog.Info("Mock forward proxy listening", "addr", *addr, "proxy_urls", "http://localhost"+*addr)
+	slog.Info("Endpoint available", "route", "GET|POST|DELETE /_mock/failures", "description", "Show, set or clear injected failures")
+	slog.Info("Endpoint available", "route", "GET /_mock/requests", "description", "Recent proxied requests")
+	if failure.Mode != mockFailNone {
+		slog.Info("Injecting failure", "mode", failure.Mode, "path", failure.Path, "rate", failure.Rate, "count", failure.Count, "delay", failure.Delay)
+	}
+	if err := http.ListenAndServe(*addr, proxy); err != nil {
+		slog.Error("Failed to start mock proxy", "error", err)
+		os.Exit(1)
+	}
+}
+
+// ServeHTTP separa las peticiones a reenviar (URI absoluta o CONNECT) de las rutas /_mock
+func (p *mockProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect && !r.URL.IsAbs() {
+		p.control.ServeHTTP(w, r)
+		return
+	}
+
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	entry := mockRequestLog{Time: time.Now(), Method: r.Method, Host: host, Path: r.URL.Path, Via: p.name}
+	status := http.StatusOK
+	defer func() {
+		entry.Status = status
+		p.record(entry)
+		slog.Info("Mock proxy request", "method", r.Method, "host", host, "path", r.URL.Path,
+			"status", status, "injected", entry.Injected)
+	}()
+
+	if p.auth != "" && !p.authorized(r) {
+		status = http.StatusProxyAuthRequired
+		w.Header().Set("Proxy-Authenticate", `Basic realm="mock-proxy"`)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if failure, ok := p.injectedFailure(host, r.URL.Path); ok {
+		entry.Injected = failure.Mode
+		if r.Method == http.MethodConnect {
+			// Un túnel no permite respuestas de Lider: cualquier falla que no sea slow rechaza el CONNECT
+			if failure.Mode != mockFailSlow {
+				status = http.StatusBadGateway
+				if code, err := strconv.Atoi(failure.Mode); err == nil {
+					status = code
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			select {
+			case <-time.After(failure.delay()):
+			case <-r.Context().Done():
+				return
+			}
+		} else {
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			if serveMockFailure(recorder, r, failure, host, r.URL.Path) {
+				status = recorder.status
+				return
+			}
+		}
+	}
+
+	if r.Method == http.MethodConnect {
+		status = p.tunnel(w, r)
+		return
+	}
+	status = p.forward(w, r)
+}
+
+// authorized valida las credenciales Basic de Proxy-Authorization
+func (p *mockProxy) authorized(r *http.Request) bool {
+	header := r.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(header, "Basic ") {
+		return false
+	}
+	probe := &http.Request{Header: http.Header{"Authorization": {header}}}
+	user, pass, ok := probe.BasicAuth()
+	return ok && user+":"+pass == p.auth
+}
+
+// forward reenvía una petición con URI absoluta y copia la respuesta
+func (p *mockProxy) forward(w http.ResponseWriter, r *http.Request) int {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, header := range hopHeaders {
+		out.Header.Del(header)
+	}
+	out.Header.Add("Via", "1.1 "+p.name)
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		slog.Warn("Mock proxy upstream request failed", "url", r.URL.String(), "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+	defer resp.Body.Close()
+
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Add("Via", "1.1 "+p.name)
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return resp.StatusCode
+}
+
+// tunnel abre un túnel TCP hacia el destino del CONNECT
+func (p *mockProxy) tunnel(w http.ResponseWriter, r *http.Request) int {
+	upstream, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return http.StatusBadGateway
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+	w.WriteHeader(http.StatusOK)
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return http.StatusInternalServerError
+	}
+
+	go func() {
+		defer upstream.Close()
+		defer client.Close()
+		io.Copy(upstream, client)
+	}()
+	go func() {
+		defer upstream.Close()
+		defer client.Close()
+		io.Copy(client, upstream)
+	}()
+	return http.StatusOK
+}
+
+// statusRecorder guarda el status escrito para el historial de peticiones
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
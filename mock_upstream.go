@@ -0,0 +1,727 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hosts de Lider que emula mock-upstream
+const (
+	mockHostApps = "apps.lider.cl"
+	mockHostWWW  = "www.lider.cl"
+	mockHostAPI  = "api.lider.cl"
+)
+
+// mockSessionCookie es la cookie que entrega la página de inicio del mock
+const mockSessionCookie = "lider_session"
+
+// mockPathPrefixes permite llegar a cada host por prefijo de ruta cuando el header Host
+// no es de Lider (por ejemplo, http://localhost:9090/www/supermercado/search)
+var mockPathPrefixes = map[string]string{
+	"/apps": mockHostApps,
+	"/www":  mockHostWWW,
+	"/api":  mockHostAPI,
+}
+
+// Modos de falla que se pueden inyectar en mock-upstream
+const (
+	mockFailNone        = ""
+	mockFail429         = "429"
+	mockFail500         = "500"
+	mockFail503         = "503"
+	mockFailQueueIt     = "queueit"      // redirección 302 a queue-it.net
+	mockFailQueueItBody = "queueit_body" // 200 con la página de espera de queue-it
+	mockFailSlow        = "slow"         // responde normalmente después de Delay
+	mockFailMalformed   = "malformed"    // 200 con JSON o HTML truncado
+)
+
+// mockFailureModes son los modos válidos de MockFailure
+var mockFailureModes = []string{mockFail429, mockFail500, mockFail503, mockFailQueueIt, mockFailQueueItBody, mockFailSlow, mockFailMalformed}
+
+// MockFailure describe qué fallas inyecta mock-upstream
+type MockFailure struct {
+	Mode  string  `json:"mode"`
+	Path  string  `json:"path,omitempty"`  // solo peticiones cuyo host+ruta contiene este texto
+	Rate  float64 `json:"rate,omitempty"`  // probabilidad de fallar (0 o ausente: siempre)
+	Count int     `json:"count,omitempty"` // fallar solo las próximas N peticiones (0: sin límite)
+	Delay string  `json:"delay,omitempty"` // espera del modo slow y la que anuncia queueit_body (default 10s)
+}
+
+// validate revisa el modo y la espera de la falla
+func (f MockFailure) validate() error {
+	if f.Mode != mockFailNone && !containsString(mockFailureModes, f.Mode) {
+		return fmt.Errorf("unknown failure mode '%s' (valid: %s)", f.Mode, strings.Join(mockFailureModes, ", "))
+	}
+	if f.Rate < 0 || f.Rate > 1 {
+		return fmt.Errorf("rate must be between 0 and 1")
+	}
+	if f.Delay != "" {
+		if _, err := time.ParseDuration(f.Delay); err != nil {
+			return fmt.Errorf("invalid delay: %w", err)
+		}
+	}
+	return nil
+}
+
+// delay retorna la espera del modo slow
+func (f MockFailure) delay() time.Duration {
+	if d, err := time.ParseDuration(f.Delay); err == nil && f.Delay != "" {
+		return d
+	}
+	return 10 * time.Second
+}
+
+// mockProduct es un producto del catálogo de mock-upstream
+type mockProduct struct {
+	SKU         string
+	Name        string
+	Brand       string
+	Description string
+	Category    string
+	CategoryID  string
+	Price       float64
+	Original    float64
+	Stock       int
+	Rating      float64
+}
+
+// image retorna la URL de la imagen del producto
+func (p mockProduct) image() string {
+	return fmt.Sprintf("https://images.lider.cl/wmtcl?source=url[file:/productos/%sa.jpg]", p.SKU)
+}
+
+// mockCatalog es el catálogo fijo que responde mock-upstream
+var mockCatalog = []mockProduct{
+	{"4522432", "Leche Entera Colun 1 L", "Colun", "Leche entera UHT", "Lácteos", "lacteos", 990, 1190, 42, 4.6},
+	{"1023456", "Leche Descremada Sin Lactosa Soprole 1 L", "Soprole", "Leche descremada sin lactosa", "Lácteos", "lacteos", 1350, 1350, 18, 4.4},
+	{"2034567", "Yogurt Batido Frutilla Soprole 125 g", "Soprole", "Yogurt batido sabor frutilla", "Lácteos", "lacteos", 290, 350, 120, 4.2},
+	{"789012", "Arroz Grado 2 Tucapel 1 kg", "Tucapel", "Arroz grano largo", "Despensa", "despensa", 1490, 1690, 0, 4.1},
+	{"334455", "Aceite Maravilla Belmont 1 L", "Belmont", "Aceite vegetal de maravilla", "Despensa", "despensa", 2990, 2990, 35, 4.0},
+	{"556677", "Pan Molde Blanco Ideal 750 g", "Ideal", "Pan de molde blanco", "Panadería", "panaderia", 2190, 2490, 25, 4.3},
+	{"556678", "Pan Integral Castaño 600 g", "Castaño", "Pan integral con semillas", "Panadería", "panaderia", 1890, 1890, 12, 4.5},
+	{"998877", "Detergente Líquido Omo 3 L", "Omo", "Detergente líquido para ropa", "Limpieza", "limpieza", 8990, 10490, 9, 4.7},
+}
+
+// mockRequestLog es una petición atendida por mock-upstream o mock-proxy
+type mockRequestLog struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method,omitempty"`
+	Host     string    `json:"host"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Injected string    `json:"injected,omitempty"`
+	Via      string    `json:"via,omitempty"`
+	Session  string    `json:"session,omitempty"` // cookie de sesión que entregó la página de inicio
+}
+
+// maxMockRequests limita el historial de peticiones en memoria
+const maxMockRequests = 200
+
+// mockControl guarda la falla inyectada y el historial de peticiones de mock-upstream
+// y mock-proxy, y expone las rutas /_mock para cambiarlos en caliente
+type mockControl struct {
+	mu        sync.Mutex
+	failure   MockFailure
+	remaining int
+	requests  []mockRequestLog
+}
+
+// mockUpstream es el servidor que emula las APIs JSON de apps.lider.cl y las páginas HTML de www.lider.cl
+type mockUpstream struct {
+	mockControl
+	sessions atomic.Int64
+}
+
+// runMockUpstream levanta un servidor local que emula a Lider, con fallas inyectables
+// por flags o en caliente con /_mock/failures
+func runMockUpstream(args []string) {
+	fs := flag.NewFlagSet("mock-upstream", flag.ExitOnError)
+	addr := fs.String("addr", ":9090", "dirección donde escuchar")
+	mode := fs.String("fail", "", "falla a inyectar ("+strings.Join(mockFailureModes, ", ")+")")
+	path := fs.String("fail-path", "", "solo fallar peticiones cuyo host+ruta contiene este texto")
+	rate := fs.Float64("fail-rate", 0, "probabilidad de fallar, entre 0 y 1 (0: siempre)")
+	count := fs.Int("fail-count", 0, "fallar solo las primeras N peticiones (0: sin límite)")
+	delay := fs.String("delay", "10s", "espera del modo slow")
+	fs.Parse(args)
+
+	setupLogging()
+	gin.SetMode(gin.ReleaseMode)
+
+	mock := &mockUpstream{}
+	failure := MockFailure{Mode: *mode, Path: *path, Rate: *rate, Count: *count, Delay: *delay}
+	if err := mock.setFailure(failure); err != nil {
+		slog.Error("Invalid failure", "error", err)
+		os.Exit(1)
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	mock.routes(router)
+	router.NoRoute(mock.handleUpstream)
+
+	slog.Info("Mock Lider upstream listening", "addr", *addr, "upstream_mock_url", "http://localhost"+*addr)
+	slog.Info("Endpoint available", "route", "GET|POST|DELETE /_mock/failures", "description", "Show, set or clear injected failures")
+	slog.Info("Endpoint available", "route", "GET /_mock/requests", "description", "Recent requests")
+	if failure.Mode != mockFailNone {
+		slog.Info("Injecting failure", "mode", failure.Mode, "path", failure.Path, "rate", failure.Rate, "count", failure.Count, "delay", failure.Delay)
+	}
+	if err := router.Run(*addr); err != nil {
+		slog.Error("Failed to start mock upstream", "error", err)
+		os.Exit(1)
+	}
+}
+
+// routes registra las rutas /_mock/failures y /_mock/requests
+func (m *mockControl) routes(router *gin.Engine) {
+	router.GET("/_mock/failures", m.handleGetFailure)
+	router.POST("/_mock/failures", m.handleSetFailure)
+	router.DELETE("/_mock/failures", m.handleClearFailure)
+	router.GET("/_mock/requests", m.handleRequests)
+}
+
+// setFailure reemplaza la falla inyectada
+func (m *mockControl) setFailure(failure MockFailure) error {
+	if err := failure.validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failure = failure
+	m.remaining = failure.Count
+	return nil
+}
+
+// injectedFailure decide si la petición debe fallar y con qué modo
+func (m *mockControl) injectedFailure(host, path string) (MockFailure, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.failure
+	if f.Mode == mockFailNone {
+		return f, false
+	}
+	if f.Path != "" && !strings.Contains(host+path, f.Path) {
+		return f, false
+	}
+	if f.Rate > 0 && rand.Float64() >= f.Rate {
+		return f, false
+	}
+	if f.Count > 0 {
+		if m.remaining <= 0 {
+			return f, false
+		}
+		m.remaining--
+	}
+	return f, true
+}
+
+// record agrega la petición al historial
+func (m *mockControl) record(entry mockRequestLog) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, entry)
+	if len(m.requests) > maxMockRequests {
+		m.requests = m.requests[len(m.requests)-maxMockRequests:]
+	}
+}
+
+// handleGetFailure muestra la falla inyectada y cuántas quedan
+func (m *mockControl) handleGetFailure(c *gin.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"failure": m.failure, "remaining": m.remaining, "modes": mockFailureModes})
+}
+
+// handleSetFailure reemplaza la falla inyectada
+func (m *mockControl) handleSetFailure(c *gin.Context) {
+	var failure MockFailure
+	if err := c.ShouldBindJSON(&failure); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := m.setFailure(failure); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "modes": mockFailureModes})
+		return
+	}
+	slog.Info("Mock failure updated", "mode", failure.Mode, "path", failure.Path, "rate", failure.Rate, "count", failure.Count)
+	c.JSON(http.StatusOK, gin.H{"failure": failure})
+}
+
+// handleClearFailure deja de inyectar fallas
+func (m *mockControl) handleClearFailure(c *gin.Context) {
+	m.setFailure(MockFailure{})
+	c.Status(http.StatusNoContent)
+}
+
+// handleRequests lista las peticiones recientes
+func (m *mockControl) handleRequests(c *gin.Context) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	requests := make([]mockRequestLog, len(m.requests))
+	copy(requests, m.requests)
+	c.JSON(http.StatusOK, gin.H{"count": len(requests), "requests": requests})
+}
+
+// mockTarget resuelve el host de Lider y la ruta de la petición: por el header
+// X-Forwarded-Host (UPSTREAM_MOCK_URL lo agrega) o Host, o por el prefijo /apps, /www o /api
+func mockTarget(r *http.Request) (string, string) {
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if strings.HasSuffix(host, "lider.cl") {
+		return host, r.URL.Path
+	}
+	for prefix, target := range mockPathPrefixes {
+		if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+			return target, strings.TrimPrefix(r.URL.Path, prefix)
+		}
+	}
+	return "", r.URL.Path
+}
+
+// handleUpstream atiende cualquier petición a Lider, aplicando primero la falla inyectada
+func (m *mockUpstream) handleUpstream(c *gin.Context) {
+	host, path := mockTarget(c.Request)
+	entry := mockRequestLog{Time: time.Now(), Host: host, Path: path, Via: c.GetHeader("Via")}
+	if cookie, err := c.Request.Cookie(mockSessionCookie); err == nil {
+		entry.Session = cookie.Value
+	}
+	defer func() {
+		entry.Status = c.Writer.Status()
+		m.record(entry)
+		slog.Info("Mock upstream request", "host", host, "path", path, "query", c.Request.URL.RawQuery,
+			"status", entry.Status, "injected", entry.Injected)
+	}()
+
+	if failure, ok := m.injectedFailure(host, path); ok {
+		entry.Injected = failure.Mode
+		if serveMockFailure(c.Writer, c.Request, failure, host, path) {
+			return
+		}
+	}
+
+	switch host {
+	case mockHostApps:
+		m.serveApps(c, path)
+	case mockHostWWW:
+		m.serveWWW(c, path)
+	case mockHostAPI:
+		m.serveAPI(c, path)
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown upstream host; send Host: apps.lider.cl|www.lider.cl|api.lider.cl or prefix the path with /apps, /www or /api"})
+	}
+}
+
+// serveMockFailure responde la falla inyectada. Retorna false cuando la petición se debe
+// atender normalmente (modo slow, después de la espera).
+func serveMockFailure(w http.ResponseWriter, r *http.Request, failure MockFailure, host, path string) bool {
+	switch failure.Mode {
+	case mockFail429, mockFail500, mockFail503:
+		status, _ := strconv.Atoi(failure.Mode)
+		if status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable {
+			w.Header().Set("Retry-After", "2")
+		}
+		http.Error(w, http.StatusText(status), status)
+	case mockFailQueueIt:
+		target := r.URL.String()
+		if !r.URL.IsAbs() {
+			target = "https://" + host + r.URL.RequestURI()
+		}
+		http.Redirect(w, r, "https://lider.queue-it.net/?c=lider&e=supermercado&t="+url.QueryEscape(target), http.StatusFound)
+	case mockFailQueueItBody:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, mockQueueItPage, int(failure.delay().Seconds()))
+	case mockFailMalformed:
+		if host == mockHostWWW && !strings.HasPrefix(path, "/catalogo/api/") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<html><body><div data-testid="product-item"><script>window.__INITIAL_STATE__ = {"search":{"results":[{"id":`))
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"products":[{"ID":"4522432","displayName":"Leche`))
+		}
+	case mockFailSlow:
+		select {
+		case <-time.After(failure.delay()):
+			return false
+		case <-r.Context().Done():
+		}
+	}
+	return true
+}
+
+// serveApps emula las APIs JSON de apps.lider.cl
+func (m *mockUpstream) serveApps(c *gin.Context, path string) {
+	switch {
+	case path == "/supermercado/search":
+		c.JSON(http.StatusOK, mockProductsResponse(c, mockSearch(c.Query("query"))))
+	case path == "/supermercado/suggestions":
+		c.JSON(http.StatusOK, gin.H{"suggestions": mockSuggestions(c.Query("term"))})
+	case path == "/supermercado/promotions":
+		c.JSON(http.StatusOK, mockProductsResponse(c, mockPromotions()))
+	case path == "/supermercado/category":
+		c.JSON(http.StatusOK, mockProductsResponse(c, mockCategory(c.Query("id"))))
+	case path == "/supermercado/product":
+		mockServeDetailJSON(c, c.Query("sku"))
+	case strings.HasPrefix(path, "/supermercado/product/"):
+		mockServeDetailJSON(c, strings.TrimPrefix(path, "/supermercado/product/"))
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	}
+}
+
+// serveWWW emula las páginas HTML de www.lider.cl y su API de catálogo
+func (m *mockUpstream) serveWWW(c *gin.Context, path string) {
+	switch {
+	case path == "/" || path == "/supermercado":
+		// Página de inicio: entrega una cookie de sesión nueva, como la visita inicial de un navegador
+		if _, err := c.Request.Cookie(mockSessionCookie); err != nil {
+			http.SetCookie(c.Writer, &http.Cookie{Name: mockSessionCookie, Value: fmt.Sprintf("s%d", m.sessions.Add(1)), Path: "/", Domain: "lider.cl"})
+		}
+		mockServeHTML(c, `<html><head><title>Lider Supermercado</title></head><body><h1>Lider</h1></body></html>`)
+	case path == "/supermercado/search":
+		mockServeHTML(c, mockSearchPage(mockPage(c, mockSearch(c.Query("query")))))
+	case path == "/supermercado/ofertas":
+		mockServeHTML(c, mockCardsPage("Ofertas", mockPage(c, mockPromotions())))
+	case strings.HasPrefix(path, "/supermercado/category/"):
+		id := strings.TrimPrefix(path, "/supermercado/category/")
+		mockServeHTML(c, mockCardsPage("Categoría "+id, mockPage(c, mockCategory(id))))
+	case strings.HasPrefix(path, "/supermercado/product/sku/"):
+		sku := strings.SplitN(strings.TrimPrefix(path, "/supermercado/product/sku/"), "/", 2)[0]
+		product, ok := mockFind(sku)
+		if !ok {
+			c.Data(http.StatusNotFound, "text/html; charset=utf-8", []byte("<html><body><h1>Producto no encontrado</h1></body></html>"))
+			return
+		}
+		mockServeHTML(c, mockProductPage(product))
+	case strings.HasPrefix(path, "/catalogo/api/products/"):
+		mockServeDetailJSON(c, strings.TrimPrefix(path, "/catalogo/api/products/"))
+	default:
+		c.Data(http.StatusNotFound, "text/html; charset=utf-8", []byte("<html><body>404</body></html>"))
+	}
+}
+
+// serveAPI emula api.lider.cl
+func (m *mockUpstream) serveAPI(c *gin.Context, path string) {
+	if strings.HasPrefix(path, "/v1/products/") {
+		mockServeDetailJSON(c, strings.TrimPrefix(path, "/v1/products/"))
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+}
+
+// mockSearch retorna los productos cuyo nombre, marca o categoría contiene la consulta
+func mockSearch(query string) []mockProduct {
+	query = strings.ToLower(strings.TrimSpace(query))
+	var found []mockProduct
+	for _, p := range mockCatalog {
+		text := strings.ToLower(p.Name + " " + p.Brand + " " + p.Category)
+		if query == "" || strings.Contains(text, query) {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// mockPromotions retorna los productos con descuento
+func mockPromotions() []mockProduct {
+	var found []mockProduct
+	for _, p := range mockCatalog {
+		if p.Original > p.Price {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// mockCategory retorna los productos de la categoría
+func mockCategory(id string) []mockProduct {
+	var found []mockProduct
+	for _, p := range mockCatalog {
+		if p.CategoryID == id {
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// mockSuggestions retorna nombres de productos que empiezan o contienen el término
+func mockSuggestions(term string) []string {
+	term = strings.ToLower(strings.TrimSpace(term))
+	suggestions := []string{}
+	for _, p := range mockCatalog {
+		if term != "" && strings.Contains(strings.ToLower(p.Name), term) {
+			suggestions = append(suggestions, strings.ToLower(p.Name))
+		}
+	}
+	return suggestions
+}
+
+// mockFind busca un producto por SKU
+func mockFind(sku string) (mockProduct, bool) {
+	for _, p := range mockCatalog {
+		if p.SKU == sku {
+			return p, true
+		}
+	}
+	return mockProduct{}, false
+}
+
+// mockPageBounds aplica page (desde firstPage: 0 en las APIs, 1 en las páginas HTML) y
+// hitsPerPage (default 20) a la lista
+func mockPageBounds(c *gin.Context, total, firstPage int) (start, end, page, perPage int) {
+	page, err := strconv.Atoi(c.Query("page"))
+	if err != nil || page < firstPage {
+		page = firstPage
+	}
+	perPage, _ = strconv.Atoi(c.DefaultQuery("hitsPerPage", "20"))
+	if perPage < 1 {
+		perPage = 20
+	}
+	start = (page - firstPage) * perPage
+	if start > total {
+		start = total
+	}
+	end = start + perPage
+	if end > total {
+		end = total
+	}
+	return start, end, page, perPage
+}
+
+// mockPage retorna la página solicitada de la lista
+func mockPage(c *gin.Context, products []mockProduct) []mockProduct {
+	start, end, _, _ := mockPageBounds(c, len(products), 1)
+	return products[start:end]
+}
+
+// mockProductsResponse arma la respuesta de /search, /promotions y /category
+func mockProductsResponse(c *gin.Context, products []mockProduct) Response {
+	start, end, page, perPage := mockPageBounds(c, len(products), 0)
+	response := Response{
+		Products: []Product{},
+		NbHits:   len(products),
+		Page:     page,
+		NbPages:  (len(products) + perPage - 1) / perPage,
+	}
+	for _, p := range products[start:end] {
+		response.Products = append(response.Products, Product{
+			ID:          p.SKU,
+			Brand:       p.Brand,
+			Description: p.Description,
+			DisplayName: p.Name,
+			Price:       PriceInfo{BasePriceReference: p.Original, BasePriceSales: p.Price},
+			Images:      Images{DefaultImage: p.image(), MediumImage: p.image() + "&scale=size[180x180]"},
+		})
+	}
+	return response
+}
+
+// mockServeDetailJSON responde el detalle de un producto en el formato de las APIs internas
+func mockServeDetailJSON(c *gin.Context, sku string) {
+	p, ok := mockFind(sku)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "product not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"sku":          p.SKU,
+		"name":         p.Name,
+		"brand":        p.Brand,
+		"description":  p.Description,
+		"price":        gin.H{"current": p.Price, "original": p.Original, "currency": "CLP"},
+		"images":       []string{p.image()},
+		"availability": p.Stock > 0,
+		"stock":        p.Stock,
+		"rating":       p.Rating,
+		"category":     p.Category,
+	})
+}
+
+// mockServeHTML responde una página HTML
+func mockServeHTML(c *gin.Context, page string) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+}
+
+// mockSearchPage arma una página de búsqueda con los resultados en window.__INITIAL_STATE__
+func mockSearchPage(products []mockProduct) string {
+	results := make([]gin.H, 0, len(products))
+	for _, p := range products {
+		results = append(results, gin.H{
+			"id":          p.SKU,
+			"brand":       p.Brand,
+			"description": p.Description,
+			"displayName": p.Name,
+			"price":       gin.H{"BasePriceReference": p.Original, "BasePriceSales": p.Price},
+			"images":      gin.H{"defaultImage": p.image()},
+		})
+	}
+	state, _ := json.Marshal(gin.H{"search": gin.H{"results": results}})
+	return `<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><title>Resultados | Lider.cl</title></head>
+<body>
+  <div id="root"></div>
+  <script>window.__INITIAL_STATE__ = ` + string(state) + `;</script>
+</body>
+</html>
+`
+}
+
+// mockCardsPage arma una página de productos solo con tarjetas data-testid="product-item"
+func mockCardsPage(title string, products []mockProduct) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><title>` + html.EscapeString(title) + ` | Lider.cl</title></head>
+<body>
+  <ul class="product-grid">
+`)
+	for _, p := range products {
+		fmt.Fprintf(&b, `    <li><div data-testid="product-item" data-product-id="%s">
+      <img src="%s" alt="%s">
+      <span data-testid="product-brand">%s</span>
+      <span data-testid="product-title">%s</span>
+      <div data-testid="product-price">$%s</div>
+    </div></li>
+`, p.SKU, html.EscapeString(p.image()), html.EscapeString(p.Name), html.EscapeString(p.Brand), html.EscapeString(p.Name), mockFormatPrice(p.Price))
+	}
+	b.WriteString("  </ul>\n</body>\n</html>\n")
+	return b.String()
+}
+
+// mockProductPage arma la página de un producto con JSON-LD y meta tags OpenGraph
+func mockProductPage(p mockProduct) string {
+	availability := "https://schema.org/InStock"
+	if p.Stock == 0 {
+		availability = "https://schema.org/OutOfStock"
+	}
+	ld, _ := json.Marshal(gin.H{
+		"@context":    "https://schema.org",
+		"@type":       "Product",
+		"sku":         p.SKU,
+		"name":        p.Name,
+		"brand":       gin.H{"@type": "Brand", "name": p.Brand},
+		"description": p.Description,
+		"category":    p.Category,
+		"image":       []string{p.image()},
+		"offers": gin.H{
+			"@type":         "Offer",
+			"price":         p.Price,
+			"highPrice":     p.Original,
+			"priceCurrency": "CLP",
+			"availability":  availability,
+		},
+		"aggregateRating": gin.H{"@type": "AggregateRating", "ratingValue": p.Rating, "reviewCount": 10},
+	})
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="es">
+<head>
+  <meta charset="utf-8">
+  <title>%[1]s | Lider.cl</title>
+  <meta property="og:title" content="%[1]s">
+  <meta property="og:image" content="%[2]s">
+  <meta property="og:url" content="https://www.lider.cl/supermercado/product/sku/%[3]s">
+  <script type="application/ld+json">%[4]s</script>
+</head>
+<body>
+  <h1 class="product-title">%[1]s</h1>
+  <span class="price">$%[5]s</span>
+</body>
+</html>
+`, html.EscapeString(p.Name), html.EscapeString(p.image()), p.SKU, ld, mockFormatPrice(p.Price))
+}
+
+// mockFormatPrice formatea un precio como en Lider (1.990)
+func mockFormatPrice(price float64) string {
+	digits := strconv.Itoa(int(price))
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+// mockQueueItPage imita la sala de espera de queue-it que Lider sirve con status 200; el
+// parámetro es la espera que anuncia (secondsToStart)
+const mockQueueItPage = `<!DOCTYPE html>
+<html>
+<head><title>Lider - Sala de espera</title></head>
+<body>
+  <p>Estás en la fila virtual.</p>
+  <script src="https://static.queue-it.net/script/queueclient.min.js"></script>
+  <script>window.queueViewModel = {"ticket":{"secondsToStart":%d,"usersInLineAheadOfYou":1520}};</script>
+</body>
+</html>
+`
+
+// Global mock upstream URL
+var (
+	mockUpstreamURL     *url.URL
+	mockUpstreamURLOnce sync.Once
+)
+
+// getMockUpstreamURL returns the UPSTREAM_MOCK_URL that requests to *.lider.cl are sent to (nil if unset)
+func getMockUpstreamURL() *url.URL {
+	mockUpstreamURLOnce.Do(func() {
+		raw := os.Getenv("UPSTREAM_MOCK_URL")
+		if raw == "" {
+			return
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			slog.Error("Invalid UPSTREAM_MOCK_URL value, expected e.g. http://localhost:9090", "value", raw)
+			os.Exit(1)
+		}
+		mockUpstreamURL = u
+		slog.Info("Sending upstream requests for *.lider.cl to mock", "url", u.String())
+	})
+	return mockUpstreamURL
+}
+
+// mockRedirectTransport envía las peticiones a *.lider.cl al servidor de UPSTREAM_MOCK_URL,
+// con el host original en X-Forwarded-Host para que mock-upstream sepa qué host emular. El
+// header Host queda del mock para que los proxies de PROXY_URLS también lleguen a él. Los
+// hosts que UPSTREAM_<HOST>_URL apunta fuera de *.lider.cl no pasan por el mock.
+type mockRedirectTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip reescribe esquema y host hacia el mock; el resto de las peticiones pasan sin cambios
+func (t *mockRedirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := getMockUpstreamURL()
+	if target == nil || !strings.HasSuffix(req.URL.Hostname(), "lider.cl") {
+		return t.base.RoundTrip(req)
+	}
+
+	mocked := req.Clone(req.Context())
+	mocked.URL.Scheme = target.Scheme
+	mocked.URL.Host = target.Host
+	mocked.Host = ""
+	mocked.Header.Set("X-Forwarded-Host", req.URL.Host)
+	return t.base.RoundTrip(mocked)
+}
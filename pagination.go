@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Límites de paginación aceptados por la API
+const (
+	defaultPage = 1
+	maxPerPage  = 100
+)
+
+// PageRequest contiene la página solicitada por el cliente.
+// PerPage 0 significa usar el tamaño de página por defecto de Lider.
+type PageRequest struct {
+	Page    int
+	PerPage int
+}
+
+// ProductPage es una página de productos junto con la información de paginación de Lider
+type ProductPage struct {
+	Products []Product
+	Total    int // nbHits reportado por Lider (0 si se desconoce)
+	Page     int
+	Pages    int // nbPages reportado por Lider (0 si se desconoce)
+	PerPage  int
+}
+
+// parsePageRequest lee y valida los parámetros 'page' y 'per_page'
+func parsePageRequest(c *gin.Context) (PageRequest, error) {
+	page := PageRequest{Page: defaultPage}
+
+	if raw := c.Query("page"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 {
+			return page, newScraperError(ErrInvalidInput, "parámetro 'page' inválido: debe ser un entero mayor o igual a 1")
+		}
+		page.Page = value
+	}
+
+	if raw := c.Query("per_page"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxPerPage {
+			return page, newScraperError(ErrInvalidInput, "parámetro 'per_page' inválido: debe ser un entero entre 1 y %d", maxPerPage)
+		}
+		page.PerPage = value
+	}
+
+	return page, nil
+}
+
+// cacheParts retorna los componentes de la página para construir claves de cache
+func (p PageRequest) cacheParts() []string {
+	return []string{strconv.Itoa(p.Page), strconv.Itoa(p.PerPage)}
+}
+
+// withPageParams agrega los parámetros de paginación a una URL upstream.
+// perPageParam es el nombre que usa el destino para el tamaño de página y firstPage el
+// número de su primera página: las APIs de Lider (Algolia) cuentan desde 0 y las páginas
+// HTML desde 1, mientras que la API pública siempre cuenta desde 1.
+func withPageParams(rawURL string, page PageRequest, perPageParam string, firstPage int) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	if page.Page > defaultPage {
+		query.Set("page", strconv.Itoa(page.Page-defaultPage+firstPage))
+	}
+	if page.PerPage > 0 && perPageParam != "" {
+		query.Set(perPageParam, strconv.Itoa(page.PerPage))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// newProductPage arma una ProductPage a partir de la respuesta de Lider
+func newProductPage(products []Product, total, pages int, page PageRequest) *ProductPage {
+	return &ProductPage{
+		Products: products,
+		Total:    total,
+		Page:     page.Page,
+		Pages:    pages,
+		PerPage:  page.PerPage,
+	}
+}
+
+// pageInfoFromData extrae nbHits y nbPages de una respuesta JSON genérica de Lider
+func pageInfoFromData(data interface{}) (total, pages int) {
+	if m, ok := data.(map[string]interface{}); ok {
+		if nbHits, ok := m["nbHits"].(float64); ok {
+			total = int(nbHits)
+		}
+		if nbPages, ok := m["nbPages"].(float64); ok {
+			pages = int(nbPages)
+		}
+	}
+	return total, pages
+}
+
+// partial indica si la página trae menos productos que per_page, es decir, si es la última.
+// Sin per_page no se conoce el tamaño de página de Lider y no se puede saber.
+func (p *ProductPage) partial() bool {
+	return p.PerPage > 0 && len(p.Products) < p.PerPage
+}
+
+// paginationEnvelope agrega total, page, pages y los links next/prev a la respuesta
+func paginationEnvelope(c *gin.Context, body gin.H, page *ProductPage) gin.H {
+	body["page"] = page.Page
+	body["per_page"] = page.PerPage
+	body["total"] = nil
+	body["pages"] = nil
+	if page.Pages > 0 {
+		body["total"] = page.Total
+		body["pages"] = page.Pages
+	}
+
+	var next, prev interface{}
+	hasNext := page.Page < page.Pages
+	if page.Pages == 0 {
+		// Sin totales (p. ej. datos obtenidos por scraping): seguir mientras la página venga
+		// completa. Sin per_page no se sabe si lo está, y un next sin fin es peor que ninguno.
+		hasNext = page.PerPage > 0 && len(page.Products) > 0 && !page.partial()
+	}
+	if hasNext {
+		next = pageLink(c, page.Page+1)
+	}
+	if page.Page > 1 {
+		prev = pageLink(c, page.Page-1)
+	}
+	body["next"] = next
+	body["prev"] = prev
+
+	return body
+}
+
+// pageLink construye el link relativo a otra página de la misma solicitud
+func pageLink(c *gin.Context, page int) string {
+	query := c.Request.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	return c.Request.URL.Path + "?" + query.Encode()
+}
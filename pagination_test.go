@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWithPageParams(t *testing.T) {
+	tests := []struct {
+		name         string
+		page         PageRequest
+		perPageParam string
+		firstPage    int
+		want         url.Values
+	}{
+		{"first page is omitted", PageRequest{Page: 1}, "hitsPerPage", 0, url.Values{"query": {"leche"}}},
+		{"api pages count from zero", PageRequest{Page: 2}, "hitsPerPage", 0, url.Values{"query": {"leche"}, "page": {"1"}}},
+		{"html pages count from one", PageRequest{Page: 3}, "", 1, url.Values{"query": {"leche"}, "page": {"3"}}},
+		{"per page uses the endpoint param", PageRequest{Page: 4, PerPage: 10}, "hitsPerPage", 0,
+			url.Values{"query": {"leche"}, "page": {"3"}, "hitsPerPage": {"10"}}},
+		{"per page without param is dropped", PageRequest{Page: 1, PerPage: 10}, "", 1, url.Values{"query": {"leche"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := url.Parse(withPageParams("https://apps.lider.cl/supermercado/search?query=leche", tt.page, tt.perPageParam, tt.firstPage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Query().Encode() != tt.want.Encode() {
+				t.Errorf("query = %s, want %s", got.Query().Encode(), tt.want.Encode())
+			}
+		})
+	}
+}
+
+// La primera página de la búsqueda paginada grabada en testdata/cassettes (contra
+// mock-upstream, que imita la API de Algolia) trae page 0: el catálogo por defecto debe
+// pedir la página N de la API pública como N-1
+func TestRecordedSearchResponseIsZeroBased(t *testing.T) {
+	recorded := &cassette{mode: cassetteReplay, dir: filepath.Join("testdata", "cassettes")}
+	req := httptest.NewRequest("GET", "https://apps.lider.cl/supermercado/search?hitsPerPage=1&query=leche", nil)
+	exchange, err := recorded.load(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := decodeResponse([]byte(exchange.Response.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Page != 0 || response.NbPages < 2 {
+		t.Fatalf("recorded first page has page=%d nbPages=%d, want page=0 and several pages", response.Page, response.NbPages)
+	}
+
+	catalog, err := parseUpstreamCatalog(defaultEndpointsYAML, "default_endpoints.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints, err := compileUpstreamCatalog(catalog, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"apps_search": "1", "www_search_page": "2"}
+	for _, target := range endpoints.targets(endpointSearch, "leche", PageRequest{Page: 2}) {
+		u, err := url.Parse(target.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u.Query().Get("page"); got != want[target.Name] {
+			t.Errorf("%s: page = %q, want %q", target.Name, got, want[target.Name])
+		}
+	}
+}
+
+func TestPaginationEnvelopeNextLink(t *testing.T) {
+	products := func(n int) []Product { return make([]Product, n) }
+	tests := []struct {
+		name string
+		page *ProductPage
+		want interface{}
+	}{
+		{"totals with more pages", &ProductPage{Products: products(2), Page: 1, Pages: 2, PerPage: 2}, "/productos?page=2&q=leche"},
+		{"totals on the last page", &ProductPage{Products: products(2), Page: 2, Pages: 2, PerPage: 2}, nil},
+		{"scraped full page", &ProductPage{Products: products(2), Page: 1, PerPage: 2}, "/productos?page=2&q=leche"},
+		{"scraped partial page", &ProductPage{Products: products(1), Page: 1, PerPage: 2}, nil},
+		{"scraped page without per_page", &ProductPage{Products: products(2), Page: 1}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/productos?q=leche", nil)
+			body := paginationEnvelope(c, gin.H{}, tt.page)
+			if body["next"] != tt.want {
+				t.Errorf("next = %v, want %v", body["next"], tt.want)
+			}
+		})
+	}
+}
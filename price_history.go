@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriceObservation es un precio observado para un SKU en un momento dado
+type PriceObservation struct {
+	SKU        string    `json:"sku"`
+	Current    float64   `json:"current"`
+	Original   float64   `json:"original"`
+	Discount   float64   `json:"discount"`
+	Currency   string    `json:"currency"`
+	Available  bool      `json:"available"`
+	Source     string    `json:"source"`
+	ObservedAt time.Time `json:"observedAt"`
+}
+
+// DailyPriceStats agrega las observaciones de un día
+type DailyPriceStats struct {
+	Date         string  `json:"date"`
+	Min          float64 `json:"min"`
+	Max          float64 `json:"max"`
+	Avg          float64 `json:"avg"`
+	Observations int     `json:"observations"`
+}
+
+// PriceHistoryStore guarda las observaciones en un archivo JSON Lines (append-only)
+// y mantiene un índice en memoria por SKU
+type PriceHistoryStore struct {
+	mu    sync.RWMutex
+	file  *os.File
+	bySKU map[string][]PriceObservation
+}
+
+// NewPriceHistoryStore abre (o crea) el archivo de historial y carga las observaciones existentes
+func NewPriceHistoryStore(path string) (*PriceHistoryStore, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create price history directory: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open price history file: %w", err)
+	}
+
+	store := &PriceHistoryStore{
+		file:  file,
+		bySKU: make(map[string][]PriceObservation),
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		var obs PriceObservation
+		if err := json.Unmarshal(scanner.Bytes(), &obs); err != nil || obs.SKU == "" {
+			slog.Warn("Skipping malformed price history line", "path", path, "line", lineNumber)
+			continue
+		}
+		store.bySKU[obs.SKU] = append(store.bySKU[obs.SKU], obs)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read price history file: %w", err)
+	}
+
+	// Si el proceso murió a mitad de una escritura, la última línea queda sin
+	// salto de línea y el próximo registro se pegaría a ella
+	if err := terminateLastLine(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Las líneas se escriben en orden, pero un archivo editado a mano podría no estarlo
+	for sku := range store.bySKU {
+		observations := store.bySKU[sku]
+		sort.SliceStable(observations, func(i, j int) bool {
+			return observations[i].ObservedAt.Before(observations[j].ObservedAt)
+		})
+	}
+
+	return store, nil
+}
+
+// terminateLastLine agrega un salto de línea si el archivo no termina en uno
+func terminateLastLine(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat price history file: %w", err)
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return fmt.Errorf("failed to read price history file: %w", err)
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	if _, err := file.Write([]byte{'\n'}); err != nil {
+		return fmt.Errorf("failed to repair price history file: %w", err)
+	}
+	slog.Warn("Terminated truncated price history line", "path", file.Name())
+	return nil
+}
+
+// Record agrega una observación al archivo y al índice en memoria
+func (s *PriceHistoryStore) Record(obs PriceObservation) error {
+	data, err := json.Marshal(obs)
+	if err != nil {
+		return fmt.Errorf("failed to encode price observation: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write price observation: %w", err)
+	}
+	s.bySKU[obs.SKU] = append(s.bySKU[obs.SKU], obs)
+
+	return nil
+}
+
+// Query retorna las observaciones de un SKU dentro del rango [from, to].
+// Un tiempo cero en from o to deja ese extremo abierto.
+func (s *PriceHistoryStore) Query(sku string, from, to time.Time) []PriceObservation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []PriceObservation
+	for _, obs := range s.bySKU[sku] {
+		if !from.IsZero() && obs.ObservedAt.Before(from) {
+			continue
+		}
+		if !to.IsZero() && obs.ObservedAt.After(to) {
+			continue
+		}
+		result = append(result, obs)
+	}
+	return result
+}
+
+// Close cierra el archivo de historial
+func (s *PriceHistoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// aggregateDailyPrices calcula mínimo, máximo y promedio del precio actual por día (UTC)
+func aggregateDailyPrices(observations []PriceObservation) []DailyPriceStats {
+	byDay := make(map[string]*DailyPriceStats)
+	var days []string
+
+	for _, obs := range observations {
+		day := obs.ObservedAt.UTC().Format("2006-01-02")
+		stats, ok := byDay[day]
+		if !ok {
+			stats = &DailyPriceStats{Date: day, Min: math.Inf(1), Max: math.Inf(-1)}
+			byDay[day] = stats
+			days = append(days, day)
+		}
+		stats.Min = math.Min(stats.Min, obs.Current)
+		stats.Max = math.Max(stats.Max, obs.Current)
+		stats.Avg += obs.Current
+		stats.Observations++
+	}
+
+	sort.Strings(days)
+	result := make([]DailyPriceStats, 0, len(days))
+	for _, day := range days {
+		stats := byDay[day]
+		stats.Avg = math.Round(stats.Avg/float64(stats.Observations)*100) / 100
+		result = append(result, *stats)
+	}
+	return result
+}
+
+// Global price history instance
+var (
+	priceHistory     *PriceHistoryStore
+	priceHistoryOnce sync.Once
+)
+
+// getPriceHistory returns the singleton price history store (nil if it could not be opened)
+func getPriceHistory() *PriceHistoryStore {
+	priceHistoryOnce.Do(func() {
+		path := os.Getenv("PRICE_HISTORY_PATH")
+		if path == "" {
+			path = filepath.Join("data", "price_history.jsonl")
+		}
+
+		store, err := NewPriceHistoryStore(path)
+		if err != nil {
+			slog.Error("Price history disabled", "error", err)
+			return
+		}
+		priceHistory = store
+		slog.Info("Price history store initialized", "path", path)
+	})
+	return priceHistory
+}
+
+// recordPriceObservation guarda el precio observado en un detalle de producto.
+// Los detalles sin precio (Current <= 0) se omiten para no ensuciar el mínimo diario.
+func recordPriceObservation(ctx context.Context, detail *ProductDetail, source string) {
+	if detail == nil || detail.SKU == "" || detail.Price.Current <= 0 {
+		return
+	}
+	store := getPriceHistory()
+	if store == nil {
+		return
+	}
+
+	discount := detail.Price.Discount
+	if discount == 0 && detail.Price.Original > detail.Price.Current && detail.Price.Current > 0 {
+		discount = ((detail.Price.Original - detail.Price.Current) / detail.Price.Original) * 100
+	}
+
+	obs := PriceObservation{
+		SKU:        detail.SKU,
+		Current:    detail.Price.Current,
+		Original:   detail.Price.Original,
+		Discount:   math.Round(discount*100) / 100,
+		Currency:   detail.Price.Currency,
+		Available:  detail.Availability,
+		Source:     source,
+		ObservedAt: time.Now().UTC(),
+	}
+
+	if err := store.Record(obs); err != nil {
+		loggerFrom(ctx).Error("Failed to record price observation", "sku", detail.SKU, "error", err)
+	}
+}
+
+// parseHistoryTime acepta fechas RFC3339 o YYYY-MM-DD; endOfDay extiende
+// las fechas sin hora hasta el final del día
+func parseHistoryTime(raw string, endOfDay bool) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// handleProductHistory retorna el historial de precios de un SKU
+func handleProductHistory(c *gin.Context) {
+	sku := strings.TrimSpace(c.Param("sku"))
+	if sku == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "se requiere parámetro 'sku'",
+			"code":    ErrInvalidInput,
+			"example": "/product/4522432/history?from=2024-01-01&to=2024-01-31&aggregate=daily",
+		})
+		return
+	}
+
+	from, err := parseHistoryTime(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "parámetro 'from' inválido",
+			"code":    ErrInvalidInput,
+			"example": "from=2024-01-01 o from=2024-01-01T00:00:00Z",
+		})
+		return
+	}
+	to, err := parseHistoryTime(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "parámetro 'to' inválido",
+			"code":    ErrInvalidInput,
+			"example": "to=2024-01-31 o to=2024-01-31T23:59:59Z",
+		})
+		return
+	}
+
+	aggregate := c.Query("aggregate")
+	if aggregate != "" && aggregate != "daily" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "parámetro 'aggregate' inválido",
+			"code":    ErrInvalidInput,
+			"example": "aggregate=daily",
+		})
+		return
+	}
+
+	store := getPriceHistory()
+	if store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "historial de precios no disponible",
+			"code":  ErrUnavailable,
+		})
+		return
+	}
+
+	observations := store.Query(sku, from, to)
+	if observations == nil {
+		observations = []PriceObservation{}
+	}
+
+	response := gin.H{
+		"sku":          sku,
+		"count":        len(observations),
+		"observations": observations,
+	}
+	if aggregate == "daily" {
+		response["daily"] = aggregateDailyPrices(observations)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
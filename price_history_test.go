@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func priceAt(sku string, current float64, observedAt string) PriceObservation {
+	t, err := time.Parse(time.RFC3339, observedAt)
+	if err != nil {
+		panic(err)
+	}
+	return PriceObservation{SKU: sku, Current: current, ObservedAt: t}
+}
+
+func TestPriceHistoryQueryFiltersRange(t *testing.T) {
+	store, err := NewPriceHistoryStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	for _, obs := range []PriceObservation{
+		priceAt("1", 1000, "2024-01-14T23:59:59Z"),
+		priceAt("1", 990, "2024-01-15T00:00:00Z"),
+		priceAt("1", 1190, "2024-01-15T23:00:00Z"),
+		priceAt("1", 1090, "2024-01-16T10:00:00Z"),
+		priceAt("2", 500, "2024-01-15T12:00:00Z"),
+	} {
+		if err := store.Record(obs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	from, _ := parseHistoryTime("2024-01-15", false)
+	to, _ := parseHistoryTime("2024-01-15", true)
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []float64
+	}{
+		{"open range", time.Time{}, time.Time{}, []float64{1000, 990, 1190, 1090}},
+		{"from only", from, time.Time{}, []float64{990, 1190, 1090}},
+		{"to only", time.Time{}, to, []float64{1000, 990, 1190}},
+		{"single day", from, to, []float64{990, 1190}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []float64
+			for _, obs := range store.Query("1", tt.from, tt.to) {
+				got = append(got, obs.Current)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("prices = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateDailyPrices(t *testing.T) {
+	got := aggregateDailyPrices([]PriceObservation{
+		priceAt("1", 1190, "2024-01-16T10:00:00Z"),
+		priceAt("1", 990, "2024-01-15T13:00:00Z"),
+		priceAt("1", 1000, "2024-01-15T14:00:00Z"),
+		priceAt("1", 1190, "2024-01-15T15:00:00Z"),
+		// 23:30 en Santiago ya es el día siguiente en UTC
+		priceAt("1", 800, "2024-01-15T23:30:00-03:00"),
+	})
+	want := []DailyPriceStats{
+		{Date: "2024-01-15", Min: 990, Max: 1190, Avg: 1060, Observations: 3},
+		{Date: "2024-01-16", Min: 800, Max: 1190, Avg: 995, Observations: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("daily = %+v, want %+v", got, want)
+	}
+
+	if got := aggregateDailyPrices(nil); len(got) != 0 {
+		t.Errorf("daily for no observations = %+v, want empty", got)
+	}
+}
+
+func TestPriceHistoryRepairsTruncatedLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	content := `{"sku":"1","current":990,"observedAt":"2024-01-15T13:00:00Z"}` + "\n" + `{"sku":"1","curr`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewPriceHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Record(priceAt("1", 1190, "2024-01-16T10:00:00Z")); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	reopened, err := NewPriceHistoryStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if got := reopened.Query("1", time.Time{}, time.Time{}); len(got) != 2 || got[1].Current != 1190 {
+		t.Errorf("observations after reopening = %+v, want the first line and the new record", got)
+	}
+}
+
+func TestRecordPriceObservationSkipsMissingPrice(t *testing.T) {
+	store := getPriceHistory()
+	if store == nil {
+		t.Fatal("price history store not available")
+	}
+
+	for _, current := range []float64{0, -1} {
+		detail := &ProductDetail{SKU: "history-no-price"}
+		detail.Price.Current = current
+		recordPriceObservation(context.Background(), detail, "api")
+	}
+	if got := store.Query("history-no-price", time.Time{}, time.Time{}); len(got) != 0 {
+		t.Errorf("recorded %d observations without a price, want none", len(got))
+	}
+
+	before := len(store.Query("history-priced", time.Time{}, time.Time{}))
+	detail := &ProductDetail{SKU: "history-priced"}
+	detail.Price.Current = 990
+	recordPriceObservation(context.Background(), detail, "api")
+	if got := len(store.Query("history-priced", time.Time{}, time.Time{})) - before; got != 1 {
+		t.Errorf("recorded %d observations for a priced detail, want 1", got)
+	}
+}
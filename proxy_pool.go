@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Estrategias para elegir el proxy de salida
+const (
+	proxyRoundRobin    = "round_robin"    // los proxies disponibles se turnan
+	proxyLeastFailures = "least_failures" // el proxy disponible con menos fallas desde que entró al pool
+)
+
+// Resultados de una petición a través de un proxy
+const (
+	proxyResultSuccess = "success"
+	proxyResultBlocked = "blocked" // 429, 503 o queue-it
+	proxyResultError   = "error"   // el proxy no respondió o rechazó la conexión
+)
+
+// proxyScoreWeight es el peso de la última petición en el puntaje de salud de un proxy
+const proxyScoreWeight = 0.2
+
+// upstreamProxy es un proxy de salida del pool
+type upstreamProxy struct {
+	url          *url.URL
+	label        string // esquema y host, sin credenciales (para logs y métricas)
+	score        float64
+	successes    int64
+	failures     int64
+	recent       int // fallas desde que entró (o volvió) al pool
+	consecutive  int
+	evictions    int64
+	evictedUntil time.Time
+	lastError    string
+	lastUsed     time.Time
+}
+
+// ProxyPool reparte las peticiones del AdvancedScraper entre proxies HTTP o SOCKS5, saca
+// del pool por un tiempo a los que Lider bloquea seguido y mantiene cada sesión (cookie jar)
+// en el mismo proxy
+type ProxyPool struct {
+	mu               sync.Mutex
+	proxies          []*upstreamProxy
+	strategy         string
+	sticky           bool
+	evictAfter       int
+	evictionCooldown time.Duration
+	directFallback   bool
+	next             int
+	sessions         map[any]*upstreamProxy
+	now              func() time.Time
+}
+
+// NewProxyPool crea el pool con los proxies dados, todos con puntaje 1
+func NewProxyPool(proxyURLs []*url.URL, strategy string, sticky bool, evictAfter int, evictionCooldown time.Duration, directFallback bool) *ProxyPool {
+	pool := &ProxyPool{
+		strategy:         strategy,
+		sticky:           sticky,
+		evictAfter:       evictAfter,
+		evictionCooldown: evictionCooldown,
+		directFallback:   directFallback,
+		sessions:         make(map[any]*upstreamProxy),
+		now:              time.Now,
+	}
+	for _, u := range proxyURLs {
+		proxy := &upstreamProxy{url: u, label: u.Scheme + "://" + u.Host, score: 1}
+		proxyHealthScore.WithLabelValues(proxy.label).Set(proxy.score)
+		pool.proxies = append(pool.proxies, proxy)
+	}
+	return pool
+}
+
+// Global proxy pool instance
+var (
+	proxyPool     *ProxyPool
+	proxyPoolOnce sync.Once
+)
+
+// getProxyPool returns the singleton outbound proxy pool configured from PROXY_*, or nil
+// when PROXY_URLS is empty and the scraper connects directly
+func getProxyPool() *ProxyPool {
+	proxyPoolOnce.Do(func() {
+		raw := os.Getenv("PROXY_URLS")
+		if strings.TrimSpace(raw) == "" {
+			return
+		}
+
+		var proxyURLs []*url.URL
+		for _, entry := range strings.Split(raw, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			u, err := parseProxyURL(entry)
+			if err != nil {
+				slog.Error("Invalid PROXY_URLS entry", "error", err)
+				os.Exit(1)
+			}
+			proxyURLs = append(proxyURLs, u)
+		}
+
+		strategy := proxyRoundRobin
+		if raw := os.Getenv("PROXY_STRATEGY"); raw != "" {
+			if raw == proxyRoundRobin || raw == proxyLeastFailures {
+				strategy = raw
+			} else {
+				slog.Warn("Invalid PROXY_STRATEGY, using default", "value", raw, "default", strategy)
+			}
+		}
+
+		evictAfter := 3
+		if raw := os.Getenv("PROXY_EVICT_AFTER"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				evictAfter = n
+			} else {
+				slog.Warn("Invalid PROXY_EVICT_AFTER, using default", "value", raw, "default", evictAfter)
+			}
+		}
+
+		evictionCooldown := 5 * time.Minute
+		if raw := os.Getenv("PROXY_EVICTION_COOLDOWN"); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				evictionCooldown = d
+			} else {
+				slog.Warn("Invalid PROXY_EVICTION_COOLDOWN, using default", "value", raw, "default", evictionCooldown)
+			}
+		}
+
+		sticky := !strings.EqualFold(os.Getenv("PROXY_STICKY_SESSIONS"), "false")
+		directFallback := strings.EqualFold(os.Getenv("PROXY_DIRECT_FALLBACK"), "true")
+
+		proxyPool = NewProxyPool(proxyURLs, strategy, sticky, evictAfter, evictionCooldown, directFallback)
+		slog.Info("Outbound proxy pool enabled", "proxies", len(proxyURLs), "strategy", strategy, "sticky_sessions", sticky,
+			"evict_after", evictAfter, "eviction_cooldown", evictionCooldown)
+	})
+	return proxyPool
+}
+
+// parseProxyURL valida una URL de PROXY_URLS (http, https o socks5, con credenciales opcionales)
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("%s: unsupported proxy scheme '%s' (valid: http, https, socks5)", u.Redacted(), u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: missing proxy host", u.Redacted())
+	}
+	return u, nil
+}
+
+// available indica si el proxy está en el pool; si terminó su expulsión lo readmite. Requiere mu.
+func (p *ProxyPool) available(ctx context.Context, proxy *upstreamProxy, now time.Time) bool {
+	if proxy.evictedUntil.IsZero() {
+		return true
+	}
+	if now.Before(proxy.evictedUntil) {
+		return false
+	}
+	proxy.evictedUntil = time.Time{}
+	proxy.consecutive = 0
+	proxy.recent = 0
+	proxy.score = 0.5
+	proxyHealthScore.WithLabelValues(proxy.label).Set(proxy.score)
+	loggerFrom(ctx).Info("Outbound proxy readmitted after eviction", "proxy", proxy.label)
+	return true
+}
+
+// Pick elige el proxy para la petición. Una sesión ya asignada mantiene su proxy mientras
+// siga en el pool. Retorna nil sin error cuando no hay proxies disponibles y
+// PROXY_DIRECT_FALLBACK permite conectarse directo.
+func (p *ProxyPool) Pick(ctx context.Context, session any) (*upstreamProxy, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.sticky && session != nil {
+		if proxy, ok := p.sessions[session]; ok && p.available(ctx, proxy, now) {
+			proxy.lastUsed = now
+			return proxy, nil
+		}
+	}
+
+	var chosen *upstreamProxy
+	chosenIndex := 0
+	for i := range p.proxies {
+		index := (p.next + i) % len(p.proxies)
+		proxy := p.proxies[index]
+		if !p.available(ctx, proxy, now) {
+			continue
+		}
+		if chosen == nil || (p.strategy == proxyLeastFailures && (proxy.recent < chosen.recent ||
+			(proxy.recent == chosen.recent && proxy.score > chosen.score))) {
+			chosen, chosenIndex = proxy, index
+		}
+		if p.strategy == proxyRoundRobin {
+			break
+		}
+	}
+
+	if chosen == nil {
+		if p.directFallback {
+			return nil, nil
+		}
+		return nil, newScraperError(ErrUpstreamBlocked, "no outbound proxy available (all %d evicted)", len(p.proxies))
+	}
+
+	p.next = (chosenIndex + 1) % len(p.proxies)
+	chosen.lastUsed = now
+	if p.sticky && session != nil {
+		p.sessions[session] = chosen
+	}
+	return chosen, nil
+}
+
+// Release olvida el proxy asignado a una sesión
+func (p *ProxyPool) Release(session any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.sessions, session)
+}
+
+// Record registra el resultado de una petición a través del proxy y lo expulsa del pool
+// por PROXY_EVICTION_COOLDOWN cuando acumula PROXY_EVICT_AFTER fallas seguidas
+func (p *ProxyPool) Record(ctx context.Context, proxy *upstreamProxy, result, detail string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	proxyRequestsTotal.WithLabelValues(proxy.label, result).Inc()
+	if result == proxyResultSuccess {
+		proxy.successes++
+		proxy.consecutive = 0
+		proxy.score = proxy.score*(1-proxyScoreWeight) + proxyScoreWeight
+		proxyHealthScore.WithLabelValues(proxy.label).Set(proxy.score)
+		return
+	}
+
+	proxy.failures++
+	proxy.recent++
+	proxy.consecutive++
+	proxy.lastError = detail
+	proxy.score *= 1 - proxyScoreWeight
+	proxyHealthScore.WithLabelValues(proxy.label).Set(proxy.score)
+
+	if proxy.consecutive >= p.evictAfter && proxy.evictedUntil.IsZero() {
+		proxy.evictedUntil = p.now().Add(p.evictionCooldown)
+		proxy.evictions++
+		proxyEvictionsTotal.WithLabelValues(proxy.label).Inc()
+		for session, assigned := range p.sessions {
+			if assigned == proxy {
+				delete(p.sessions, session)
+			}
+		}
+		loggerFrom(ctx).Warn("Outbound proxy evicted", "proxy", proxy.label, "consecutive_failures", proxy.consecutive,
+			"last_error", detail, "until", proxy.evictedUntil)
+	}
+}
+
+// proxyStatus es el estado de un proxy que se muestra en /admin/proxies
+type proxyStatus struct {
+	Proxy               string     `json:"proxy"`
+	State               string     `json:"state"`
+	Score               float64    `json:"score"`
+	Successes           int64      `json:"successes"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Evictions           int64      `json:"evictions"`
+	EvictedUntil        *time.Time `json:"evicted_until,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastUsed            *time.Time `json:"last_used,omitempty"`
+	Sessions            int        `json:"sessions"`
+}
+
+// Snapshot retorna el estado de cada proxy en el orden de PROXY_URLS
+func (p *ProxyPool) Snapshot() []proxyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessions := make(map[*upstreamProxy]int)
+	for _, proxy := range p.sessions {
+		sessions[proxy]++
+	}
+
+	now := p.now()
+	statuses := make([]proxyStatus, 0, len(p.proxies))
+	for _, proxy := range p.proxies {
+		status := proxyStatus{
+			Proxy:               proxy.label,
+			State:               "active",
+			Score:               proxy.score,
+			Successes:           proxy.successes,
+			Failures:            proxy.failures,
+			ConsecutiveFailures: proxy.consecutive,
+			Evictions:           proxy.evictions,
+			LastError:           proxy.lastError,
+			Sessions:            sessions[proxy],
+		}
+		if !proxy.evictedUntil.IsZero() && now.Before(proxy.evictedUntil) {
+			status.State = "evicted"
+			evictedUntil := proxy.evictedUntil
+			status.EvictedUntil = &evictedUntil
+		}
+		if !proxy.lastUsed.IsZero() {
+			lastUsed := proxy.lastUsed
+			status.LastUsed = &lastUsed
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// handleProxies muestra el estado de los proxies de salida
+// GET /admin/proxies
+func handleProxies(c *gin.Context) {
+	pool := getProxyPool()
+	if pool == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false, "proxies": []proxyStatus{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":           true,
+		"strategy":          pool.strategy,
+		"sticky_sessions":   pool.sticky,
+		"evict_after":       pool.evictAfter,
+		"eviction_cooldown": pool.evictionCooldown.String(),
+		"direct_fallback":   pool.directFallback,
+		"proxies":           pool.Snapshot(),
+	})
+}
+
+// proxySessionKey es la clave de contexto que identifica la sesión (cookie jar) de una petición
+type proxySessionKey struct{}
+
+// withProxySession marca el contexto con la sesión, para que sus peticiones usen siempre el mismo proxy
+func withProxySession(ctx context.Context, session any) context.Context {
+	return context.WithValue(ctx, proxySessionKey{}, session)
+}
+
+// proxySessionFrom retorna la sesión marcada en el contexto, o nil
+func proxySessionFrom(ctx context.Context) any {
+	return ctx.Value(proxySessionKey{})
+}
+
+// proxyChoiceKey es la clave de contexto con el proxy elegido para la petición
+type proxyChoiceKey struct{}
+
+// proxyFromContext es el Proxy del http.Transport del AdvancedScraper: usa el proxy que
+// eligió proxyTransport, o ninguno
+func proxyFromContext(req *http.Request) (*url.URL, error) {
+	if proxy, ok := req.Context().Value(proxyChoiceKey{}).(*upstreamProxy); ok {
+		return proxy.url, nil
+	}
+	return nil, nil
+}
+
+// proxyTransport elige un proxy del pool para cada petición y le informa el resultado
+type proxyTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip envía la petición por el proxy elegido, o directo si PROXY_URLS está vacío
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	pool := getProxyPool()
+	if pool == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	proxy, err := pool.Pick(req.Context(), proxySessionFrom(req.Context()))
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	resp, err := t.base.RoundTrip(req.WithContext(context.WithValue(ctx, proxyChoiceKey{}, proxy)))
+	if err != nil {
+		// Las cancelaciones de la solicitud no son culpa del proxy
+		if ctx.Err() == nil {
+			pool.Record(ctx, proxy, proxyResultError, err.Error())
+		}
+		return nil, err
+	}
+
+	result, detail, err := classifyProxyResponse(resp)
+	if err != nil {
+		resp.Body.Close()
+		if ctx.Err() == nil {
+			pool.Record(ctx, proxy, proxyResultError, err.Error())
+		}
+		return nil, err
+	}
+	pool.Record(ctx, proxy, result, detail)
+	return resp, nil
+}
+
+// classifyProxyResponse decide si la respuesta cuenta como bloqueo del proxy. Lee el body
+// para detectar la página de queue-it y lo deja disponible de nuevo.
+func classifyProxyResponse(resp *http.Response) (string, string, error) {
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return proxyResultError, "proxy authentication required", nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		return proxyResultBlocked, fmt.Sprintf("upstream returned status %d", resp.StatusCode), nil
+	case strings.Contains(resp.Header.Get("Location"), "queue-it.net"):
+		return proxyResultBlocked, "redirected to queue-it", nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", "", err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if isQueueItPage(body) {
+		return proxyResultBlocked, "queue-it page", nil
+	}
+	return proxyResultSuccess, "", nil
+}
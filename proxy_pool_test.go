@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testProxyPool crea un pool con proxies ficticios y un reloj controlado por la prueba
+func testProxyPool(t *testing.T, strategy string, sticky bool, evictAfter int, names ...string) (*ProxyPool, *time.Time) {
+	t.Helper()
+	var urls []*url.URL
+	for _, name := range names {
+		u, err := parseProxyURL("http://" + name + ":3128")
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, u)
+	}
+	pool := NewProxyPool(urls, strategy, sticky, evictAfter, time.Minute, false)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	pool.now = func() time.Time { return now }
+	return pool, &now
+}
+
+// pick elige un proxy y retorna su host, fallando la prueba si no hay ninguno
+func pick(t *testing.T, pool *ProxyPool, session any) string {
+	t.Helper()
+	proxy, err := pool.Pick(context.Background(), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proxy.url.Hostname()
+}
+
+func TestProxyPoolHealthScore(t *testing.T) {
+	pool, _ := testProxyPool(t, proxyRoundRobin, false, 10, "a")
+	proxy := pool.proxies[0]
+	ctx := context.Background()
+
+	steps := []struct {
+		result string
+		want   float64
+	}{
+		{proxyResultBlocked, 0.8},
+		{proxyResultError, 0.64},
+		{proxyResultSuccess, 0.712},
+		{proxyResultSuccess, 0.7696},
+	}
+	for _, step := range steps {
+		pool.Record(ctx, proxy, step.result, "")
+		if math.Abs(proxy.score-step.want) > 1e-9 {
+			t.Errorf("score after %s = %v, want %v", step.result, proxy.score, step.want)
+		}
+	}
+	if proxy.successes != 2 || proxy.failures != 2 || proxy.consecutive != 0 {
+		t.Errorf("successes=%d failures=%d consecutive=%d, want 2, 2 and 0", proxy.successes, proxy.failures, proxy.consecutive)
+	}
+}
+
+func TestProxyPoolEvictsAndReadmits(t *testing.T) {
+	pool, now := testProxyPool(t, proxyRoundRobin, false, 2, "a", "b")
+	ctx := context.Background()
+	a := pool.proxies[0]
+
+	pool.Record(ctx, a, proxyResultBlocked, "upstream returned status 429")
+	if !a.evictedUntil.IsZero() {
+		t.Fatal("proxy evicted after a single failure")
+	}
+	pool.Record(ctx, a, proxyResultBlocked, "upstream returned status 429")
+	if a.evictedUntil.IsZero() || a.evictions != 1 {
+		t.Fatalf("proxy not evicted after %d consecutive failures", a.consecutive)
+	}
+	if state := pool.Snapshot()[0].State; state != "evicted" {
+		t.Errorf("snapshot state = %q, want evicted", state)
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := pick(t, pool, nil); got != "b" {
+			t.Fatalf("pick %d = %s while a is evicted, want b", i, got)
+		}
+	}
+
+	// Terminado el cooldown vuelve al pool con la mitad del puntaje y sin fallas recientes
+	*now = now.Add(time.Minute)
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		seen[pick(t, pool, nil)] = true
+	}
+	if !seen["a"] {
+		t.Fatal("proxy a was not readmitted after the cooldown")
+	}
+	if !a.evictedUntil.IsZero() || a.score != 0.5 || a.consecutive != 0 || a.recent != 0 {
+		t.Errorf("readmitted proxy = %+v, want score 0.5 and no recent failures", a)
+	}
+}
+
+func TestProxyPoolAllEvicted(t *testing.T) {
+	pool, _ := testProxyPool(t, proxyRoundRobin, false, 1, "a")
+	pool.Record(context.Background(), pool.proxies[0], proxyResultError, "connection refused")
+
+	if _, err := pool.Pick(context.Background(), nil); errorKindOf(err) != ErrUpstreamBlocked {
+		t.Errorf("Pick error = %v, want %s", err, ErrUpstreamBlocked)
+	}
+
+	pool.directFallback = true
+	if proxy, err := pool.Pick(context.Background(), nil); proxy != nil || err != nil {
+		t.Errorf("Pick with direct fallback = %v, %v, want a direct connection", proxy, err)
+	}
+}
+
+func TestProxyPoolLeastFailures(t *testing.T) {
+	pool, _ := testProxyPool(t, proxyLeastFailures, false, 10, "a", "b", "c")
+	ctx := context.Background()
+	pool.Record(ctx, pool.proxies[0], proxyResultBlocked, "")
+	pool.Record(ctx, pool.proxies[2], proxyResultBlocked, "")
+
+	for i := 0; i < 3; i++ {
+		if got := pick(t, pool, nil); got != "b" {
+			t.Errorf("pick %d = %s, want b (the only proxy without failures)", i, got)
+		}
+	}
+}
+
+func TestProxyPoolStickySessions(t *testing.T) {
+	pool, _ := testProxyPool(t, proxyRoundRobin, true, 1, "a", "b")
+	first, second := &scraperSession{id: 1}, &scraperSession{id: 2}
+
+	if got := pick(t, pool, first); got != "a" {
+		t.Fatalf("first session got %s, want a", got)
+	}
+	if got := pick(t, pool, second); got != "b" {
+		t.Fatalf("second session got %s, want b", got)
+	}
+	for i := 0; i < 3; i++ {
+		if got := pick(t, pool, first); got != "a" {
+			t.Errorf("first session moved to %s, want it to stay on a", got)
+		}
+	}
+
+	// Al expulsar el proxy la sesión se reasigna
+	pool.Record(context.Background(), pool.proxies[0], proxyResultBlocked, "queue-it page")
+	if got := pick(t, pool, first); got != "b" {
+		t.Errorf("first session got %s after a was evicted, want b", got)
+	}
+
+	pool.Release(first)
+	if _, ok := pool.sessions[first]; ok {
+		t.Error("released session still has a proxy")
+	}
+
+	pool.sticky = false
+	if got := pick(t, pool, second); got != "b" {
+		t.Errorf("non-sticky pick = %s, want b (the only proxy left)", got)
+	}
+	if len(pool.sessions) != 1 {
+		t.Errorf("non-sticky pick assigned a session: %d sessions", len(pool.sessions))
+	}
+}
+
+// testForwardProxy es un proxy HTTP de reenvío que responde status a toda petición
+type testForwardProxy struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	status int
+	hits   int
+}
+
+func newTestForwardProxy(t *testing.T, status int) *testForwardProxy {
+	p := &testForwardProxy{status: status}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() {
+			http.Error(w, "expected a proxied request", http.StatusBadRequest)
+			return
+		}
+		p.mu.Lock()
+		p.hits++
+		p.mu.Unlock()
+		w.WriteHeader(p.status)
+		io.WriteString(w, `{"products":[]}`)
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *testForwardProxy) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hits
+}
+
+func TestProxyTransportRoutesAndEvicts(t *testing.T) {
+	healthy := newTestForwardProxy(t, http.StatusOK)
+	blocked := newTestForwardProxy(t, http.StatusServiceUnavailable)
+	blockedURL, _ := url.Parse(blocked.server.URL)
+	healthyURL, _ := url.Parse(healthy.server.URL)
+
+	pool := NewProxyPool([]*url.URL{blockedURL, healthyURL}, proxyRoundRobin, true, 2, time.Minute, false)
+	proxyPoolOnce.Do(func() {})
+	previous := proxyPool
+	proxyPool = pool
+	t.Cleanup(func() { proxyPool = previous })
+
+	client := &http.Client{Transport: &proxyTransport{base: &http.Transport{Proxy: proxyFromContext}}}
+	ctx := withProxySession(context.Background(), &scraperSession{id: 1})
+	get := func() int {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "http://apps.lider.cl/supermercado/search?query=leche", nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// La sesión queda en el proxy bloqueado hasta que se expulsa, y luego pasa al sano
+	statuses := []int{get(), get(), get(), get()}
+	want := []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK, http.StatusOK}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+	if blocked.count() != 2 || healthy.count() != 2 {
+		t.Errorf("blocked proxy hits = %d, healthy proxy hits = %d, want 2 and 2", blocked.count(), healthy.count())
+	}
+
+	snapshot := pool.Snapshot()
+	if snapshot[0].State != "evicted" || snapshot[0].LastError != "upstream returned status 503" {
+		t.Errorf("blocked proxy = %+v, want evicted after two 503s", snapshot[0])
+	}
+	if snapshot[1].State != "active" || snapshot[1].Successes != 2 || snapshot[1].Sessions != 1 {
+		t.Errorf("healthy proxy = %+v, want active with 2 successes and the session", snapshot[1])
+	}
+}
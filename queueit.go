@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueueItGate recuerda que Lider activó su fila virtual (queue-it) y hasta cuándo. Mientras
+// está cerrada no se envían peticiones a Lider: se espera lo que indicó la fila o, si el
+// deadline de la solicitud no alcanza, se falla de inmediato con ErrUpstreamQueued.
+type QueueItGate struct {
+	mu           sync.Mutex
+	defaultWait  time.Duration
+	maxWait      time.Duration
+	blockedUntil time.Time
+	since        time.Time
+	lastWait     time.Duration
+	lastSource   string
+	detections   int64
+	now          func() time.Time
+}
+
+// Global queue-it gate instance
+var (
+	queueItGate     *QueueItGate
+	queueItGateOnce sync.Once
+)
+
+// getQueueItGate returns the singleton queue-it gate configured from QUEUEIT_DEFAULT_WAIT
+// and QUEUEIT_MAX_WAIT
+func getQueueItGate() *QueueItGate {
+	queueItGateOnce.Do(func() {
+		queueItGate = &QueueItGate{defaultWait: 30 * time.Second, maxWait: 5 * time.Minute, now: time.Now}
+		if raw := os.Getenv("QUEUEIT_DEFAULT_WAIT"); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				queueItGate.defaultWait = d
+			} else {
+				slog.Warn("Invalid QUEUEIT_DEFAULT_WAIT, using default", "value", raw, "default", queueItGate.defaultWait)
+			}
+		}
+		if raw := os.Getenv("QUEUEIT_MAX_WAIT"); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				queueItGate.maxWait = d
+			} else {
+				slog.Warn("Invalid QUEUEIT_MAX_WAIT, using default", "value", raw, "default", queueItGate.maxWait)
+			}
+		}
+	})
+	return queueItGate
+}
+
+// Block cierra la compuerta por la espera que indicó la fila (QUEUEIT_DEFAULT_WAIT si no
+// indicó ninguna, hasta QUEUEIT_MAX_WAIT) y retorna cuánto falta para volver a intentar
+func (g *QueueItGate) Block(ctx context.Context, wait time.Duration, source string) time.Duration {
+	if wait <= 0 {
+		wait = g.defaultWait
+	}
+	if wait > g.maxWait {
+		wait = g.maxWait
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if !now.Before(g.blockedUntil) {
+		g.since = now
+		loggerFrom(ctx).Warn("Lider queue-it waiting room active, pausing upstream requests", "wait", wait.String(), "detected_in", source)
+	}
+	if until := now.Add(wait); until.After(g.blockedUntil) {
+		g.blockedUntil = until
+	}
+	g.lastWait = wait
+	g.lastSource = source
+	g.detections++
+	return g.blockedUntil.Sub(now)
+}
+
+// Remaining retorna cuánto falta para que se abra la compuerta (0 si está abierta)
+func (g *QueueItGate) Remaining() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if remaining := g.blockedUntil.Sub(g.now()); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// queueItDelay alarga la espera antes de una petición hasta que se abra la compuerta de
+// queue-it, o retorna ErrUpstreamQueued si no se abrirá antes del deadline de la solicitud
+func queueItDelay(ctx context.Context, delay time.Duration) (time.Duration, error) {
+	wait := getQueueItGate().Remaining()
+	if wait <= delay {
+		return delay, nil
+	}
+	if exceedsDeadline(ctx, wait) {
+		return 0, queuedError(wait)
+	}
+	return wait, nil
+}
+
+// queueItWaitSeconds alimenta el gauge lider_queueit_wait_seconds
+func queueItWaitSeconds() float64 {
+	return getQueueItGate().Remaining().Seconds()
+}
+
+// queuedError es el error de una petición que no se envió, o que se detuvo, por la fila de queue-it
+func queuedError(wait time.Duration) *ScraperError {
+	err := newScraperError(ErrUpstreamQueued, "Lider queue-it waiting room active, retry in %s", wait.Round(time.Second))
+	err.RetryAfter = wait
+	return err
+}
+
+// Patrones de la sala de espera de queue-it que indican cuánto falta para entrar
+var (
+	queueItSecondsPattern = regexp.MustCompile(`"secondsToStart"\s*:\s*(\d+)`)
+	queueItServicePattern = regexp.MustCompile(`"expectedServiceTime"\s*:\s*"([^"]+)"`)
+)
+
+// queueItWait retorna la espera que indicó la fila: el header Retry-After o, en la sala de
+// espera, secondsToStart o expectedServiceTime. Retorna 0 si no indicó ninguna.
+func queueItWait(header http.Header, body []byte) time.Duration {
+	now := time.Now()
+	if wait := parseRetryAfter(header.Get("Retry-After"), now); wait > 0 {
+		return wait
+	}
+	if match := queueItSecondsPattern.FindSubmatch(body); match != nil {
+		if seconds, err := strconv.Atoi(string(match[1])); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if match := queueItServicePattern.FindSubmatch(body); match != nil {
+		if at, err := time.Parse(time.RFC3339, string(match[1])); err == nil && at.After(now) {
+			return at.Sub(now)
+		}
+	}
+	return 0
+}
+
+// parseRetryAfter interpreta un header Retry-After en segundos o como fecha HTTP
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// Estados de una solicitud estacionada
+const (
+	parkedPending = "pending"
+	parkedDone    = "done"
+	parkedFailed  = "failed"
+)
+
+// parkedRequest es una solicitud que se respondió con 202 mientras queue-it estaba activo y
+// que se completa en segundo plano cuando se abre la fila
+type parkedRequest struct {
+	ID          string     `json:"id"`
+	Endpoint    string     `json:"endpoint"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	key       string
+	requestID string
+	run       func(ctx context.Context) (interface{}, error)
+	result    interface{}
+	err       error
+}
+
+// RequestQueue es la fila interna donde se estacionan las solicitudes bloqueadas por
+// queue-it (QUEUEIT_PARK). Un worker las vuelve a ejecutar, en orden, cuando se abre la compuerta.
+type RequestQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*parkedRequest
+	pending chan *parkedRequest
+	ttl     time.Duration
+}
+
+// Global request queue instance
+var (
+	requestQueue     *RequestQueue
+	requestQueueOnce sync.Once
+)
+
+// getRequestQueue returns the singleton request queue configured from QUEUEIT_PARK_*, or nil
+// when QUEUEIT_PARK is not enabled and queued requests fail with upstream_queued
+func getRequestQueue() *RequestQueue {
+	requestQueueOnce.Do(func() {
+		if !strings.EqualFold(os.Getenv("QUEUEIT_PARK"), "true") {
+			return
+		}
+
+		size := 100
+		if raw := os.Getenv("QUEUEIT_PARK_MAX"); raw != "" {
+			if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+				size = n
+			} else {
+				slog.Warn("Invalid QUEUEIT_PARK_MAX, using default", "value", raw, "default", size)
+			}
+		}
+		ttl := 10 * time.Minute
+		if raw := os.Getenv("QUEUEIT_PARK_TTL"); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				ttl = d
+			} else {
+				slog.Warn("Invalid QUEUEIT_PARK_TTL, using default", "value", raw, "default", ttl)
+			}
+		}
+
+		requestQueue = &RequestQueue{
+			jobs:    make(map[string]*parkedRequest),
+			pending: make(chan *parkedRequest, size),
+			ttl:     ttl,
+		}
+		slog.Info("Requests blocked by queue-it will be parked", "max", size, "ttl", ttl)
+	})
+	return requestQueue
+}
+
+// Park estaciona la solicitud. Retorna false si la fila interna está llena.
+func (q *RequestQueue) Park(ctx context.Context, endpoint, key string, run func(ctx context.Context) (interface{}, error)) (*parkedRequest, bool) {
+	id, err := randomHex(12)
+	if err != nil {
+		return nil, false
+	}
+	job := &parkedRequest{
+		ID:        id,
+		Endpoint:  endpoint,
+		Status:    parkedPending,
+		CreatedAt: time.Now(),
+		key:       key,
+		requestID: requestIDFrom(ctx),
+		run:       run,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- job:
+	default:
+		return nil, false
+	}
+	q.jobs[id] = job
+	return job, true
+}
+
+// Start ejecuta en segundo plano el worker de la fila y la limpieza periódica de las
+// solicitudes completadas hasta que se cancele ctx
+func (q *RequestQueue) Start(ctx context.Context) {
+	go q.work(ctx)
+	go func() {
+		ticker := time.NewTicker(min(q.ttl, time.Minute))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				q.expire()
+			}
+		}
+	}()
+}
+
+// expire olvida las solicitudes completadas hace más de QUEUEIT_PARK_TTL. Se cuenta desde
+// que se completaron y no desde que se estacionaron, para que un resultado que tardó en
+// llegar se pueda consultar el TTL completo.
+func (q *RequestQueue) expire() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for id, job := range q.jobs {
+		if job.CompletedAt != nil && now.Sub(*job.CompletedAt) > q.ttl {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Get retorna una copia de la solicitud estacionada si la estacionó la misma key
+func (q *RequestQueue) Get(id, key string) (parkedRequest, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok || job.key != key {
+		return parkedRequest{}, false
+	}
+	return *job, true
+}
+
+// Pending retorna cuántas solicitudes estacionadas siguen sin completarse
+func (q *RequestQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := 0
+	for _, job := range q.jobs {
+		if job.Status == parkedPending {
+			pending++
+		}
+	}
+	return pending
+}
+
+// parkedRequests alimenta el gauge lider_queueit_parked_requests
+func parkedRequests() float64 {
+	if queue := getRequestQueue(); queue != nil {
+		return float64(queue.Pending())
+	}
+	return 0
+}
+
+// work ejecuta las solicitudes estacionadas cuando se abre la compuerta de queue-it. Si la
+// fila de Lider sigue activa vuelven al final de la fila interna hasta QUEUEIT_PARK_TTL.
+// Al cancelarse ctx (apagado del servidor) se abandona la espera y la solicitud en curso.
+func (q *RequestQueue) work(ctx context.Context) {
+	gate := getQueueItGate()
+	for {
+		var job *parkedRequest
+		select {
+		case <-ctx.Done():
+			return
+		case job = <-q.pending:
+		}
+		if wait := gate.Remaining(); wait > 0 {
+			if err := sleepContext(ctx, wait); err != nil {
+				return
+			}
+		}
+
+		jobCtx := withRequestID(ctx, job.requestID)
+		result, err := job.run(jobCtx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		q.mu.Lock()
+		job.Attempts++
+		if errorKindOf(err) == ErrUpstreamQueued && time.Since(job.CreatedAt) < q.ttl {
+			select {
+			case q.pending <- job:
+				q.mu.Unlock()
+				continue
+			default:
+			}
+		}
+		now := time.Now()
+		job.CompletedAt = &now
+		job.result, job.err = result, err
+		job.Status = parkedDone
+		if err != nil {
+			job.Status = parkedFailed
+		}
+		q.mu.Unlock()
+
+		loggerFrom(jobCtx).Info("Parked request completed", "id", job.ID, "endpoint", job.Endpoint, "status", job.Status,
+			"attempts", job.Attempts, "waited", now.Sub(job.CreatedAt))
+	}
+}
+
+// parkQueued responde 202 con la URL de consulta si el error es de queue-it y QUEUEIT_PARK
+// está activo; run vuelve a ejecutar la solicitud y retorna el body de la respuesta normal.
+// Retorna false si la solicitud no se estacionó y el handler debe responder el error.
+func parkQueued(c *gin.Context, err error, endpoint string, run func(ctx context.Context, c *gin.Context) (interface{}, error)) bool {
+	queue := getRequestQueue()
+	if queue == nil || errorKindOf(err) != ErrUpstreamQueued {
+		return false
+	}
+
+	// La copia del gin.Context se puede usar después de que termine el handler
+	detached := c.Copy()
+	job, ok := queue.Park(c.Request.Context(), endpoint, apiKeyName(c), func(ctx context.Context) (interface{}, error) {
+		return run(ctx, detached)
+	})
+	if !ok {
+		loggerFrom(c.Request.Context()).Warn("Request queue full, not parking request", "endpoint", endpoint)
+		return false
+	}
+
+	wait := getQueueItGate().Remaining()
+	pollURL := "/queue/" + job.ID
+	c.Header("Location", pollURL)
+	c.Header("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+	c.JSON(http.StatusAccepted, gin.H{
+		"id":          job.ID,
+		"status":      job.Status,
+		"poll_url":    pollURL,
+		"retry_after": ceilSeconds(wait),
+		"message":     "Lider tiene activa su fila de espera; la solicitud se completará cuando se abra",
+	})
+	loggerFrom(c.Request.Context()).Info("Request parked until queue-it opens", "id", job.ID, "endpoint", endpoint, "wait", wait.String())
+	return true
+}
+
+// handleQueuedRequest responde el resultado de una solicitud estacionada: 202 mientras espera,
+// la respuesta normal cuando terminó o su error
+// GET /queue/:id
+func handleQueuedRequest(c *gin.Context) {
+	queue := getRequestQueue()
+	if queue == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "solicitud en espera no encontrada", "code": ErrNotFound})
+		return
+	}
+
+	job, ok := queue.Get(c.Param("id"), apiKeyName(c))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "solicitud en espera no encontrada", "code": ErrNotFound})
+		return
+	}
+
+	switch job.Status {
+	case parkedPending:
+		wait := getQueueItGate().Remaining()
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+		c.JSON(http.StatusAccepted, gin.H{
+			"id":          job.ID,
+			"status":      job.Status,
+			"endpoint":    job.Endpoint,
+			"attempts":    job.Attempts,
+			"created_at":  job.CreatedAt,
+			"retry_after": ceilSeconds(wait),
+		})
+	case parkedFailed:
+		respondError(c, job.err)
+	default:
+		c.JSON(http.StatusOK, job.result)
+	}
+}
+
+// handleQueueItStatus muestra el estado de la compuerta de queue-it y de la fila interna
+// GET /admin/queueit
+func handleQueueItStatus(c *gin.Context) {
+	gate := getQueueItGate()
+	remaining := gate.Remaining()
+
+	gate.mu.Lock()
+	status := gin.H{
+		"blocked":          remaining > 0,
+		"retry_in_seconds": ceilSeconds(remaining),
+		"detections":       gate.detections,
+		"last_wait":        gate.lastWait.String(),
+		"last_detected_in": gate.lastSource,
+		"default_wait":     gate.defaultWait.String(),
+		"max_wait":         gate.maxWait.String(),
+		"parking_enabled":  false,
+		"parked_requests":  0,
+		"blocked_since":    nil,
+		"blocked_until":    nil,
+	}
+	if remaining > 0 {
+		status["blocked_since"] = gate.since
+		status["blocked_until"] = gate.blockedUntil
+	}
+	gate.mu.Unlock()
+
+	if queue := getRequestQueue(); queue != nil {
+		status["parking_enabled"] = true
+		status["parked_requests"] = queue.Pending()
+	}
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// waitFor repite check hasta que se cumpla o pase un segundo
+func waitFor(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !check() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRequestQueueExpiresResultsAndStopsWithContext(t *testing.T) {
+	queue := &RequestQueue{
+		jobs:    make(map[string]*parkedRequest),
+		pending: make(chan *parkedRequest, 10),
+		ttl:     100 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	queue.Start(ctx)
+
+	run := func(ctx context.Context) (interface{}, error) { return "ok", nil }
+	job, ok := queue.Park(context.Background(), "search", "key", run)
+	if !ok {
+		t.Fatal("queue rejected the request")
+	}
+	waitFor(t, "the parked request to complete", func() bool {
+		parked, _ := queue.Get(job.ID, "key")
+		return parked.Status == parkedDone
+	})
+
+	// El resultado se olvida al vencer el TTL aunque nadie vuelva a estacionar solicitudes
+	waitFor(t, "the completed request to expire", func() bool {
+		_, found := queue.Get(job.ID, "key")
+		return !found
+	})
+
+	// Una solicitud que tardó más de la mitad del TTL en completarse se puede consultar el TTL
+	// completo desde que terminó, aunque ya haya pasado el TTL desde que se estacionó
+	slow, ok := queue.Park(context.Background(), "search", "key", func(ctx context.Context) (interface{}, error) {
+		time.Sleep(60 * time.Millisecond)
+		return "ok", nil
+	})
+	if !ok {
+		t.Fatal("queue rejected the request")
+	}
+	waitFor(t, "the slow request to complete", func() bool {
+		parked, _ := queue.Get(slow.ID, "key")
+		return parked.Status == parkedDone
+	})
+	time.Sleep(time.Until(slow.CreatedAt.Add(110 * time.Millisecond)))
+	queue.expire()
+	if _, found := queue.Get(slow.ID, "key"); !found {
+		t.Fatal("result expired before the TTL elapsed since it completed")
+	}
+	waitFor(t, "the slow request to expire", func() bool {
+		_, found := queue.Get(slow.ID, "key")
+		return !found
+	})
+
+	// Con el contexto cancelado el worker ya no ejecuta solicitudes
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	ran := make(chan struct{}, 1)
+	late, ok := queue.Park(context.Background(), "search", "key", func(ctx context.Context) (interface{}, error) {
+		ran <- struct{}{}
+		return nil, nil
+	})
+	if !ok {
+		t.Fatal("queue rejected the request")
+	}
+	select {
+	case <-ran:
+		t.Fatal("worker ran a request after its context was cancelled")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if parked, _ := queue.Get(late.ID, "key"); parked.Status != parkedPending {
+		t.Errorf("status = %s, want %s", parked.Status, parkedPending)
+	}
+}
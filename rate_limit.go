@@ -0,0 +1,179 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Límites por defecto para keys que no definen los suyos
+const (
+	defaultRateLimitPerMinute = 60
+	defaultRateLimitBurst     = 20
+)
+
+// tokenBucket implementa un token bucket que se recarga de forma continua
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	perSec   float64
+	updated  time.Time
+}
+
+// dailyUsage cuenta las solicitudes de una key en el día (UTC) actual
+type dailyUsage struct {
+	day   string
+	count int
+}
+
+// rateLimitDecision es el resultado de evaluar una solicitud contra los límites de su key
+type rateLimitDecision struct {
+	Allowed    bool
+	Reason     string // "rate_limit" o "daily_quota" cuando no se permite
+	Limit      int    // capacidad del bucket (burst); 0 si la key no tiene rate limit
+	Remaining  int
+	Reset      time.Duration // hasta que el bucket vuelva a estar lleno
+	RetryAfter time.Duration
+	Quota      int // 0 = sin cuota diaria
+	QuotaLeft  int
+	QuotaReset time.Duration
+}
+
+// keyRateLimiter aplica token bucket y cuota diaria de forma independiente por API key
+type keyRateLimiter struct {
+	mu           sync.Mutex
+	buckets      map[string]*tokenBucket
+	usage        map[string]*dailyUsage
+	defaultRate  float64
+	defaultBurst int
+	defaultQuota int
+}
+
+// newKeyRateLimiter crea el limitador con los valores por defecto de RATE_LIMIT_PER_MINUTE,
+// RATE_LIMIT_BURST y DAILY_QUOTA (0 = sin cuota)
+func newKeyRateLimiter() *keyRateLimiter {
+	return &keyRateLimiter{
+		buckets:      make(map[string]*tokenBucket),
+		usage:        make(map[string]*dailyUsage),
+		defaultRate:  float64(envInt("RATE_LIMIT_PER_MINUTE", defaultRateLimitPerMinute)),
+		defaultBurst: envInt("RATE_LIMIT_BURST", defaultRateLimitBurst),
+		defaultQuota: envInt("DAILY_QUOTA", 0),
+	}
+}
+
+// limitsFor retorna rate por minuto, burst y cuota diaria efectivos para la key
+func (l *keyRateLimiter) limitsFor(key *APIKey) (rate float64, burst int, quota int) {
+	rate, burst, quota = l.defaultRate, l.defaultBurst, l.defaultQuota
+	if key.RateLimitPerMinute > 0 {
+		rate = key.RateLimitPerMinute
+	}
+	if key.Burst > 0 {
+		burst = key.Burst
+	}
+	if key.DailyQuota > 0 {
+		quota = key.DailyQuota
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate, burst, quota
+}
+
+// Allow consume un token y una unidad de cuota de la key si ambos están disponibles
+func (l *keyRateLimiter) Allow(key *APIKey, now time.Time) rateLimitDecision {
+	rate, burst, quota := l.limitsFor(key)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Un rate de 0 desactiva el token bucket; usamos un bucket sin límite efectivo
+	if rate <= 0 {
+		rate = math.Inf(1)
+	}
+
+	bucket, ok := l.buckets[key.Name]
+	if !ok || bucket.capacity != float64(burst) || bucket.perSec != rate/60 {
+		bucket = &tokenBucket{
+			tokens:   float64(burst),
+			capacity: float64(burst),
+			perSec:   rate / 60,
+			updated:  now,
+		}
+		l.buckets[key.Name] = bucket
+	}
+
+	// Recargar tokens según el tiempo transcurrido
+	elapsed := now.Sub(bucket.updated).Seconds()
+	if math.IsInf(bucket.perSec, 1) {
+		bucket.tokens = bucket.capacity
+	} else {
+		bucket.tokens = math.Min(bucket.capacity, bucket.tokens+elapsed*bucket.perSec)
+	}
+	bucket.updated = now
+
+	day := now.UTC().Format("2006-01-02")
+	usage, ok := l.usage[key.Name]
+	if !ok || usage.day != day {
+		usage = &dailyUsage{day: day}
+		l.usage[key.Name] = usage
+	}
+
+	decision := rateLimitDecision{
+		Quota: quota,
+	}
+	// Limit y Remaining se miden en tokens del bucket: Limit es su capacidad, no el rate por
+	// minuto, para que la primera solicitud vea Remaining = Limit - 1
+	if !math.IsInf(rate, 1) {
+		decision.Limit = burst
+	}
+	tomorrow := time.Date(now.UTC().Year(), now.UTC().Month(), now.UTC().Day()+1, 0, 0, 0, 0, time.UTC)
+	decision.QuotaReset = tomorrow.Sub(now)
+
+	switch {
+	case quota > 0 && usage.count >= quota:
+		decision.Reason = "daily_quota"
+		decision.RetryAfter = decision.QuotaReset
+	case bucket.tokens < 1 && !math.IsInf(bucket.perSec, 1):
+		decision.Reason = "rate_limit"
+		decision.RetryAfter = time.Duration((1 - bucket.tokens) / bucket.perSec * float64(time.Second))
+	default:
+		decision.Allowed = true
+		bucket.tokens--
+		usage.count++
+	}
+
+	decision.Remaining = int(bucket.tokens)
+	if !math.IsInf(bucket.perSec, 1) {
+		decision.Reset = time.Duration((bucket.capacity - bucket.tokens) / bucket.perSec * float64(time.Second))
+	}
+	if quota > 0 {
+		decision.QuotaLeft = quota - usage.count
+	}
+
+	return decision
+}
+
+// envInt lee un entero no negativo desde una variable de entorno, con valor por defecto
+func envInt(name string, fallback int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		slog.Warn("Invalid integer setting, using default", "name", name, "value", raw, "default", fallback)
+		return fallback
+	}
+	return value
+}
+
+// ceilSeconds redondea una duración hacia arriba a segundos enteros (para headers HTTP)
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
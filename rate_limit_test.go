@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testRateLimiter crea un limitador sin límites por defecto; cada prueba los fija en la key
+func testRateLimiter() *keyRateLimiter {
+	return &keyRateLimiter{
+		buckets: make(map[string]*tokenBucket),
+		usage:   make(map[string]*dailyUsage),
+	}
+}
+
+func TestRateLimiterRefillsBucket(t *testing.T) {
+	limiter := testRateLimiter()
+	key := &APIKey{Name: "mobile-app", RateLimitPerMinute: 30, Burst: 2}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	first := limiter.Allow(key, now)
+	if !first.Allowed || first.Limit != 2 || first.Remaining != 1 {
+		t.Fatalf("first decision = %+v, want allowed with limit 2 and 1 remaining", first)
+	}
+	limiter.Allow(key, now)
+	denied := limiter.Allow(key, now)
+	if denied.Allowed || denied.Reason != "rate_limit" || denied.Remaining != 0 {
+		t.Fatalf("third decision = %+v, want denied by the rate limit", denied)
+	}
+	// 30 por minuto: un token cada 2 segundos, y el bucket lleno en 4
+	if denied.RetryAfter != 2*time.Second || denied.Reset != 4*time.Second {
+		t.Errorf("retry after %v and reset %v, want 2s and 4s", denied.RetryAfter, denied.Reset)
+	}
+
+	if limiter.Allow(key, now.Add(time.Second)).Allowed {
+		t.Error("allowed before a whole token was refilled")
+	}
+	if !limiter.Allow(key, now.Add(2*time.Second)).Allowed {
+		t.Error("denied after a token was refilled")
+	}
+	if full := limiter.Allow(key, now.Add(time.Hour)); !full.Allowed || full.Remaining != 1 {
+		t.Errorf("decision after an hour = %+v, want the bucket capped at its burst", full)
+	}
+}
+
+func TestRateLimiterFractionalRateKeepsHeaders(t *testing.T) {
+	limiter := testRateLimiter()
+	decision := limiter.Allow(&APIKey{Name: "batch", RateLimitPerMinute: 0.5, Burst: 3}, time.Now())
+	if decision.Limit != 3 || decision.Remaining != 2 {
+		t.Errorf("decision = %+v, want limit 3 and 2 remaining", decision)
+	}
+}
+
+func TestRateLimiterDailyQuotaResets(t *testing.T) {
+	limiter := testRateLimiter()
+	key := &APIKey{Name: "partner", DailyQuota: 2}
+	now := time.Date(2026, 1, 1, 23, 59, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if decision := limiter.Allow(key, now); !decision.Allowed || decision.QuotaLeft != 1-i {
+			t.Fatalf("request %d = %+v, want allowed with %d left", i+1, decision, 1-i)
+		}
+	}
+	denied := limiter.Allow(key, now)
+	if denied.Allowed || denied.Reason != "daily_quota" || denied.RetryAfter != time.Minute {
+		t.Fatalf("decision = %+v, want denied by the quota until midnight UTC", denied)
+	}
+	if denied.Limit != 0 {
+		t.Errorf("limit = %d for a key without rate limit, want 0", denied.Limit)
+	}
+
+	next := limiter.Allow(key, now.Add(time.Minute))
+	if !next.Allowed || next.QuotaLeft != 1 {
+		t.Errorf("decision after midnight = %+v, want the quota reset", next)
+	}
+}
+
+func TestRateLimitedResponse(t *testing.T) {
+	t.Setenv("API_KEYS", `[{"name":"mobile-app","key":"secret","scopes":["search"],"rate_limit_per_minute":6,"burst":1}]`)
+	t.Setenv("API_KEY", "")
+	t.Setenv("API_KEY_HASH", "")
+	t.Setenv("API_KEYS_FILE", "")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apiKeyAuthMiddleware())
+	router.GET("/productos", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/productos?q=leche", nil)
+		req.Header.Set("X-API-Key", "secret")
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	first := get()
+	if first.Code != http.StatusOK || first.Header().Get("X-RateLimit-Limit") != "1" || first.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first response = %d with headers %v, want 200 with limit 1 and 0 remaining", first.Code, first.Header())
+	}
+
+	limited := get()
+	if limited.Code != http.StatusTooManyRequests || limited.Header().Get("Retry-After") != "10" {
+		t.Fatalf("second response = %d with Retry-After %q, want 429 with 10", limited.Code, limited.Header().Get("Retry-After"))
+	}
+	var body struct {
+		Error      string `json:"error"`
+		Code       string `json:"code"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.Unmarshal(limited.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != "rate_limited" || body.RetryAfter != 10 || body.Error == "" {
+		t.Errorf("body = %+v, want code rate_limited and retry_after 10", body)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy define cuántas veces y con qué esperas se repite una petición a Lider que falló
+type RetryPolicy struct {
+	MaxAttempts int           // intentos en total, incluido el primero
+	BaseDelay   time.Duration // techo de la espera antes del primer reintento; se duplica en cada uno
+	MaxDelay    time.Duration // techo máximo de la espera (0: reintentar sin esperar)
+}
+
+// defaultRetryPolicies son las políticas por tipo de endpoint. Las sugerencias tienen un
+// deadline corto, así que se reintentan una sola vez y casi sin esperar.
+var defaultRetryPolicies = map[string]RetryPolicy{
+	endpointSearch:      {MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 15 * time.Second},
+	endpointDetail:      {MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 15 * time.Second},
+	endpointSuggestions: {MaxAttempts: 2, BaseDelay: 250 * time.Millisecond, MaxDelay: time.Second},
+	endpointPromotions:  {MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 15 * time.Second},
+	endpointCategory:    {MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 15 * time.Second},
+}
+
+// defaultRetryStatuses son los status de Lider que se reintentan; el resto se entrega al llamador
+var defaultRetryStatuses = []int{408, 429, 500, 502, 503, 504}
+
+// RetryPolicies agrupa la política de reintentos de cada tipo de endpoint
+type RetryPolicies struct {
+	policies map[string]RetryPolicy
+	statuses map[int]bool
+}
+
+// Global retry policies instance
+var (
+	retryPolicies     *RetryPolicies
+	retryPoliciesOnce sync.Once
+)
+
+// getRetryPolicies returns the singleton retry policies configured from RETRY_MAX_ATTEMPTS_<ENDPOINT>,
+// RETRY_BASE_DELAY_<ENDPOINT>, RETRY_MAX_DELAY_<ENDPOINT> and RETRY_STATUSES
+func getRetryPolicies() *RetryPolicies {
+	retryPoliciesOnce.Do(func() {
+		baseDelays := make(map[string]time.Duration, len(defaultRetryPolicies))
+		maxDelays := make(map[string]time.Duration, len(defaultRetryPolicies))
+		for endpoint, policy := range defaultRetryPolicies {
+			baseDelays[endpoint] = policy.BaseDelay
+			maxDelays[endpoint] = policy.MaxDelay
+		}
+		baseDelays = loadEndpointDurations("RETRY_BASE_DELAY_", baseDelays)
+		maxDelays = loadEndpointDurations("RETRY_MAX_DELAY_", maxDelays)
+
+		policies := make(map[string]RetryPolicy, len(defaultRetryPolicies))
+		for endpoint, policy := range defaultRetryPolicies {
+			envName := "RETRY_MAX_ATTEMPTS_" + strings.ToUpper(endpoint)
+			if raw := os.Getenv(envName); raw != "" {
+				if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+					policy.MaxAttempts = n
+				} else {
+					slog.Warn("Invalid retry attempts setting, using default", "name", envName, "value", raw, "default", policy.MaxAttempts)
+				}
+			}
+			policy.BaseDelay = baseDelays[endpoint]
+			policy.MaxDelay = maxDelays[endpoint]
+			if cassetteReplaying() {
+				// Reproduciendo cassettes no hay servidor que proteger: mismos intentos, sin esperas
+				policy.BaseDelay, policy.MaxDelay = 0, 0
+			}
+			policies[endpoint] = policy
+		}
+
+		retryPolicies = &RetryPolicies{policies: policies, statuses: loadRetryStatuses()}
+	})
+	return retryPolicies
+}
+
+// loadRetryStatuses lee RETRY_STATUSES; si la lista tiene un valor inválido usa los defaults
+func loadRetryStatuses() map[int]bool {
+	codes := defaultRetryStatuses
+	if raw := os.Getenv("RETRY_STATUSES"); raw != "" {
+		codes = nil
+		for _, part := range strings.Split(raw, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || code < 100 || code > 599 {
+				slog.Warn("Invalid RETRY_STATUSES, using default", "value", raw, "default", defaultRetryStatuses)
+				codes = defaultRetryStatuses
+				break
+			}
+			codes = append(codes, code)
+		}
+	}
+
+	statuses := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		statuses[code] = true
+	}
+	return statuses
+}
+
+// For retorna la política de la operación, o la de búsqueda si la operación no tiene una
+func (p *RetryPolicies) For(operation string) RetryPolicy {
+	if policy, ok := p.policies[operation]; ok {
+		return policy
+	}
+	return p.policies[endpointSearch]
+}
+
+// RetryStatus indica si un status de Lider se reintenta (RETRY_STATUSES)
+func (p *RetryPolicies) RetryStatus(status int) bool {
+	return p.statuses[status]
+}
+
+// retryPolicyKey es la clave de contexto de withRetryPolicy
+type retryPolicyKey struct{}
+
+// withRetryPolicy fija la política de reintentos de las peticiones hechas con ctx, en vez de
+// la configurada para su operación
+func withRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// retryPolicyFrom retorna la política fijada con withRetryPolicy o la de la operación
+// registrada en el contexto
+func retryPolicyFrom(ctx context.Context) RetryPolicy {
+	if policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy); ok {
+		return policy
+	}
+	return getRetryPolicies().For(upstreamOperation(ctx))
+}
+
+// Backoff retorna la espera antes del reintento número retry (1 es el primero) con full
+// jitter: un valor al azar entre 0 y min(MaxDelay, BaseDelay*2^(retry-1)), para que las
+// solicitudes concurrentes no reintenten al mismo tiempo. Si Lider indicó Retry-After en
+// el error, se espera al menos eso, pero nunca más de MaxDelay.
+func (p RetryPolicy) Backoff(retry int, err error) time.Duration {
+	if p.MaxDelay <= 0 {
+		return 0
+	}
+	ceiling := p.MaxDelay
+	if retry <= 31 {
+		if exp := p.BaseDelay << (retry - 1); exp > 0 && exp < ceiling {
+			ceiling = exp
+		}
+	}
+	delay := time.Duration(rand.Int63n(int64(ceiling) + 1))
+	if wait := retryAfterOf(err); wait > delay {
+		delay = min(wait, p.MaxDelay)
+	}
+	return delay
+}
+
+// retryable indica si vale la pena repetir una petición que falló con err. Los errores de red,
+// los status reintentables y los bloqueos se reintentan; un 404, una respuesta que no se pudo
+// interpretar o un error interno fallarían igual en el siguiente intento.
+func retryable(err error) bool {
+	switch errorKindOf(err) {
+	case ErrUpstreamFailure, ErrUpstreamBlocked, ErrUpstreamQueued, ErrUpstreamRateLimited, ErrUpstreamTimeout:
+		return true
+	}
+	return false
+}
+
+// exceedsDeadline indica si esperar delay deja la solicitud sin tiempo para otro intento
+func exceedsDeadline(ctx context.Context, delay time.Duration) bool {
+	deadline, ok := upstreamDeadline(ctx)
+	return ok && time.Until(deadline) < delay
+}
+
+// retryUpstream ejecuta attempt con la política de la operación del contexto. Lo usan las
+// consultas que van directo al cliente HTTP, sin las sesiones ni el rate limiter de makeRequest.
+func retryUpstream(ctx context.Context, host string, attempt func() error) error {
+	policy := retryPolicyFrom(ctx)
+	var err error
+	for try := 1; try <= policy.MaxAttempts; try++ {
+		if try > 1 {
+			delay := policy.Backoff(try-1, err)
+			if exceedsDeadline(ctx, delay) {
+				return err
+			}
+			upstreamRetriesTotal.WithLabelValues(host).Inc()
+			loggerFrom(ctx).Debug("Retrying upstream request", "host", host, "attempt", try, "delay", delay, "last_error", err)
+			if sleepErr := sleepContext(ctx, delay); sleepErr != nil {
+				return err
+			}
+		}
+		if err = attempt(); err == nil || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
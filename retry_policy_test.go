@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	rateLimited := func(wait time.Duration) error {
+		err := newScraperError(ErrUpstreamRateLimited, "API returned status 429")
+		err.RetryAfter = wait
+		return err
+	}
+
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		retry    int
+		err      error
+		min, max time.Duration
+	}{
+		{"first retry is capped by the base delay", policy, 1, nil, 0, time.Second},
+		{"the cap doubles on each retry", policy, 2, nil, 0, 2 * time.Second},
+		{"the cap doubles again", policy, 3, nil, 0, 4 * time.Second},
+		{"the cap never exceeds the max delay", policy, 4, nil, 0, 5 * time.Second},
+		{"large retries do not overflow", policy, 40, nil, 0, 5 * time.Second},
+		{"retry-after raises the wait", policy, 1, rateLimited(3 * time.Second), 3 * time.Second, 3 * time.Second},
+		{"retry-after below the jitter keeps the jitter", policy, 4, rateLimited(time.Millisecond), time.Millisecond, 5 * time.Second},
+		{"retry-after is clamped to the max delay", policy, 1, rateLimited(time.Hour), 5 * time.Second, 5 * time.Second},
+		{"zero max delay never waits", RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}, 2, rateLimited(time.Minute), 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 200; i++ {
+				if delay := tt.policy.Backoff(tt.retry, tt.err); delay < tt.min || delay > tt.max {
+					t.Fatalf("Backoff(%d) = %v, want between %v and %v", tt.retry, delay, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{newScraperError(ErrUpstreamFailure, "API returned status 500"), true},
+		{newScraperError(ErrUpstreamBlocked, "API returned status 503"), true},
+		{queuedError(time.Second), true},
+		{newScraperError(ErrUpstreamRateLimited, "API returned status 429"), true},
+		{wrapScraperError(ErrUpstreamTimeout, context.DeadlineExceeded, "upstream deadline exceeded"), true},
+		{newScraperError(ErrNotFound, "API returned status 404"), false},
+		{newScraperError(ErrParseFailure, "failed to parse JSON response"), false},
+		{newScraperError(ErrInvalidInput, "se requiere parámetro 'q'"), false},
+		{errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%s) = %v, want %v", errorKindOf(tt.err), got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{" 120 ", 2 * time.Minute},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLoadRetryStatuses(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{"", defaultRetryStatuses},
+		{"429, 503", []int{429, 503}},
+		{"429,abc", defaultRetryStatuses},
+		{"429,700", defaultRetryStatuses},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("RETRY_STATUSES", tt.value)
+			got := loadRetryStatuses()
+			if len(got) != len(tt.want) {
+				t.Fatalf("statuses = %v, want %v", got, tt.want)
+			}
+			for _, code := range tt.want {
+				if !got[code] {
+					t.Errorf("statuses = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeRequestHonorsRetryAfter(t *testing.T) {
+	var mu sync.Mutex
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		hits++
+		if hits == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	scraper := NewAdvancedScraper(http.DefaultTransport)
+	scraper.sessions = NewSessionPool(scraper.client, 1, false, 3)
+	policy := RetryPolicy{MaxAttempts: 2, BaseDelay: 10 * time.Millisecond, MaxDelay: 5 * time.Second}
+
+	t.Run("waits at least the announced time", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(withRetryPolicy(context.Background(), policy), 10*time.Second)
+		defer cancel()
+		start := time.Now()
+		resp, _, err := scraper.makeRequest(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); resp.StatusCode != http.StatusOK || elapsed < 2*time.Second {
+			t.Errorf("status %d after %v, want 200 after at least 2s", resp.StatusCode, elapsed)
+		}
+	})
+
+	t.Run("gives up when the deadline cannot cover it", func(t *testing.T) {
+		mu.Lock()
+		hits = 0
+		mu.Unlock()
+		ctx, cancel := context.WithTimeout(withRetryPolicy(context.Background(), policy), time.Second)
+		defer cancel()
+		start := time.Now()
+		_, _, err := scraper.makeRequest(ctx, http.MethodGet, server.URL, nil)
+		if errorKindOf(err) != ErrUpstreamRateLimited || retryAfterOf(err) != 2*time.Second {
+			t.Errorf("error = %v, want upstream_rate_limited with Retry-After 2s", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond || hits != 1 {
+			t.Errorf("gave up after %v and %d attempts, want right after the first", elapsed, hits)
+		}
+	})
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/html"
+	"gopkg.in/yaml.v3"
+)
+
+// supportedRulesVersion es la versión del formato de reglas que entiende este binario
+const supportedRulesVersion = 1
+
+//go:embed default_rules.yaml
+var defaultRulesYAML []byte
+
+// ExtractionRules es el archivo de reglas de extracción (YAML o JSON)
+type ExtractionRules struct {
+	Version  int        `json:"version" yaml:"version"`
+	Revision string     `json:"revision,omitempty" yaml:"revision,omitempty"`
+	Search   *PageRules `json:"search,omitempty" yaml:"search,omitempty"`
+	Detail   *PageRules `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// PageRules describe cómo extraer los datos de un tipo de página
+type PageRules struct {
+	Strategies []string                   `json:"strategies" yaml:"strategies"`
+	StatePaths []string                   `json:"state_paths" yaml:"state_paths"`
+	Fields     map[string][]string        `json:"fields" yaml:"fields"`
+	Item       string                     `json:"item,omitempty" yaml:"item,omitempty"`
+	Selectors  map[string][]FieldSelector `json:"selectors" yaml:"selectors"`
+}
+
+// FieldSelector es una forma alternativa de leer un campo desde el DOM
+type FieldSelector struct {
+	CSS         string `json:"css,omitempty" yaml:"css,omitempty"`
+	Attr        string `json:"attr,omitempty" yaml:"attr,omitempty"`
+	All         bool   `json:"all,omitempty" yaml:"all,omitempty"`
+	ScriptField string `json:"script_field,omitempty" yaml:"script_field,omitempty"`
+}
+
+// Campos y estrategias válidos por tipo de página
+var (
+	searchRuleFields     = []string{"id", "brand", "description", "display_name", "price_reference", "price_sales", "image_default", "image_medium"}
+	detailRuleFields     = []string{"sku", "name", "brand", "description", "price_current", "price_original", "currency", "images", "availability", "stock", "rating", "category"}
+	searchRuleStrategies = []string{"initial_state", "next_data", "json_ld", "dom"}
+	detailRuleStrategies = []string{"initial_state", "next_data", "json_ld", "opengraph", "dom"}
+)
+
+// compiledSelector es un FieldSelector con su selector CSS ya compilado
+type compiledSelector struct {
+	FieldSelector
+	sel cascadia.Matcher
+}
+
+// compiledPageRules son las reglas de una página listas para usar
+type compiledPageRules struct {
+	PageRules
+	statePaths [][]string
+	fieldPaths map[string][][]string
+	item       cascadia.Matcher
+	selectors  map[string][]compiledSelector
+}
+
+// compiledRules es el conjunto de reglas activo
+type compiledRules struct {
+	Version  int
+	Revision string
+	Source   string
+	LoadedAt time.Time
+	Search   *compiledPageRules
+	Detail   *compiledPageRules
+}
+
+// parseExtractionRules interpreta el archivo según su extensión (.json o YAML)
+func parseExtractionRules(data []byte, path string) (*ExtractionRules, error) {
+	var rules ExtractionRules
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("failed to parse rules JSON: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &rules); err != nil {
+			return nil, fmt.Errorf("failed to parse rules YAML: %w", err)
+		}
+	}
+	return &rules, nil
+}
+
+// compileExtractionRules valida las reglas y compila los selectores. Las secciones
+// ausentes se toman de defaults (nil solo al compilar las reglas por defecto).
+func compileExtractionRules(rules *ExtractionRules, defaults *ExtractionRules, source string) (*compiledRules, error) {
+	if rules.Version != supportedRulesVersion {
+		return nil, fmt.Errorf("unsupported rules version %d (expected %d)", rules.Version, supportedRulesVersion)
+	}
+
+	search, detail := rules.Search, rules.Detail
+	if defaults != nil {
+		if search == nil {
+			search = defaults.Search
+		}
+		if detail == nil {
+			detail = defaults.Detail
+		}
+	}
+	if search == nil || detail == nil {
+		return nil, fmt.Errorf("rules must define both search and detail sections")
+	}
+
+	compiledSearch, err := compilePageRules("search", search, searchRuleFields, searchRuleStrategies)
+	if err != nil {
+		return nil, err
+	}
+	if compiledSearch.Item == "" {
+		return nil, fmt.Errorf("search: item selector is required")
+	}
+	compiledDetail, err := compilePageRules("detail", detail, detailRuleFields, detailRuleStrategies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compiledRules{
+		Version:  rules.Version,
+		Revision: rules.Revision,
+		Source:   source,
+		LoadedAt: time.Now(),
+		Search:   compiledSearch,
+		Detail:   compiledDetail,
+	}, nil
+}
+
+// compilePageRules valida campos y estrategias de una sección y compila sus selectores
+func compilePageRules(section string, rules *PageRules, fields, strategies []string) (*compiledPageRules, error) {
+	compiled := &compiledPageRules{
+		PageRules:  *rules,
+		fieldPaths: make(map[string][][]string),
+		selectors:  make(map[string][]compiledSelector),
+	}
+
+	if len(rules.Strategies) == 0 {
+		return nil, fmt.Errorf("%s: at least one strategy is required", section)
+	}
+	for _, strategy := range rules.Strategies {
+		if !containsString(strategies, strategy) {
+			return nil, fmt.Errorf("%s: unknown strategy '%s' (valid: %s)", section, strategy, strings.Join(strategies, ", "))
+		}
+	}
+
+	for _, path := range rules.StatePaths {
+		compiled.statePaths = append(compiled.statePaths, splitRulePath(path))
+	}
+
+	for field, paths := range rules.Fields {
+		if !containsString(fields, field) {
+			return nil, fmt.Errorf("%s: unknown field '%s' (valid: %s)", section, field, strings.Join(fields, ", "))
+		}
+		for _, path := range paths {
+			compiled.fieldPaths[field] = append(compiled.fieldPaths[field], splitRulePath(path))
+		}
+	}
+
+	if rules.Item != "" {
+		sel, err := cascadia.ParseGroup(rules.Item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid item selector '%s': %w", section, rules.Item, err)
+		}
+		compiled.item = sel
+	}
+
+	for field, selectors := range rules.Selectors {
+		if !containsString(fields, field) {
+			return nil, fmt.Errorf("%s: unknown selector field '%s' (valid: %s)", section, field, strings.Join(fields, ", "))
+		}
+		for i, selector := range selectors {
+			if (selector.CSS == "") == (selector.ScriptField == "") {
+				return nil, fmt.Errorf("%s: selector #%d of '%s' must set exactly one of css or script_field", section, i+1, field)
+			}
+			item := compiledSelector{FieldSelector: selector}
+			if selector.CSS != "" {
+				sel, err := cascadia.ParseGroup(selector.CSS)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid selector '%s' for '%s': %w", section, selector.CSS, field, err)
+				}
+				item.sel = sel
+			}
+			compiled.selectors[field] = append(compiled.selectors[field], item)
+		}
+	}
+
+	return compiled, nil
+}
+
+// splitRulePath separa una ruta "a.b.0" en sus claves
+func splitRulePath(path string) []string {
+	return strings.Split(strings.TrimSpace(path), ".")
+}
+
+// containsString indica si values contiene value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stateValue retorna el valor ubicado en la primera state_path presente
+func (r *compiledPageRules) stateValue(state map[string]interface{}) interface{} {
+	if state == nil {
+		return nil
+	}
+	for _, path := range r.statePaths {
+		if value := lookupRulePath(state, path); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// field retorna el valor de un campo dentro de un objeto del estado (primera ruta presente)
+func (r *compiledPageRules) field(data map[string]interface{}, name string) interface{} {
+	for _, path := range r.fieldPaths[name] {
+		if value := lookupRulePath(data, path); value != nil {
+			return value
+		}
+	}
+	return nil
+}
+
+// lookupRulePath recorre mapas por clave y listas por índice numérico
+func lookupRulePath(value interface{}, path []string) interface{} {
+	current := value
+	for _, key := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			current = node[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil
+			}
+			current = node[index]
+		default:
+			return nil
+		}
+		if current == nil {
+			return nil
+		}
+	}
+	return current
+}
+
+// selectText retorna el primer valor no vacío que producen los selectores del campo
+func (r *compiledPageRules) selectText(doc *htmlDocument, scope *html.Node, field string) string {
+	for _, selector := range r.selectors[field] {
+		if values := selector.values(doc, scope); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// selectAll junta los valores de todos los selectores del campo (sin duplicados)
+func (r *compiledPageRules) selectAll(doc *htmlDocument, scope *html.Node, field string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, selector := range r.selectors[field] {
+		for _, value := range selector.values(doc, scope) {
+			if !seen[value] {
+				seen[value] = true
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// values aplica el selector dentro de scope (incluido el propio nodo).
+// Sin all solo retorna el valor del primer elemento que coincide.
+func (s compiledSelector) values(doc *htmlDocument, scope *html.Node) []string {
+	if s.ScriptField != "" {
+		if value := scriptStringField(doc, s.ScriptField); value != "" {
+			return []string{value}
+		}
+		return nil
+	}
+
+	var nodes []*html.Node
+	if s.sel.Match(scope) {
+		nodes = append(nodes, scope)
+	}
+	nodes = append(nodes, cascadia.QueryAll(scope, s.sel)...)
+
+	var values []string
+	for _, node := range nodes {
+		value := nodeText(node)
+		if s.Attr != "" {
+			value = strings.TrimSpace(nodeAttr(node, s.Attr))
+		}
+		if value == "" {
+			continue
+		}
+		values = append(values, value)
+		if !s.All {
+			break
+		}
+	}
+	return values
+}
+
+// valueString convierte un valor del estado en texto (acepta números)
+func valueString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+	return ""
+}
+
+// valueNumber convierte un valor del estado en número (acepta strings con formato de precio)
+func valueNumber(v interface{}) float64 {
+	return jsonLDNumber(v)
+}
+
+// valueBool convierte un valor del estado en booleano
+func valueBool(v interface{}) (bool, bool) {
+	switch value := v.(type) {
+	case bool:
+		return value, true
+	case string:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b, true
+		}
+		return isInStock(value), true
+	}
+	return false, false
+}
+
+// valueStrings convierte un valor del estado en lista de textos
+func valueStrings(v interface{}) []string {
+	var values []string
+	switch value := v.(type) {
+	case string:
+		values = append(values, value)
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// rulesStore mantiene las reglas activas y las recarga cuando cambia el archivo
+type rulesStore struct {
+	current  atomic.Pointer[compiledRules]
+	defaults *ExtractionRules
+	path     string
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// Global extraction rules instance
+var (
+	extractionRules     *rulesStore
+	extractionRulesOnce sync.Once
+)
+
+// getExtractionRules retorna las reglas de extracción activas
+func getExtractionRules() *compiledRules {
+	return getRulesStore().current.Load()
+}
+
+// getRulesStore returns the singleton rules store. The embedded defaults are always valid;
+// EXTRACTION_RULES_PATH overrides them, is checked for changes every
+// EXTRACTION_RULES_RELOAD_INTERVAL (0 disables polling) and is reloaded on SIGHUP.
+func getRulesStore() *rulesStore {
+	extractionRulesOnce.Do(func() {
+		defaults, err := parseExtractionRules(defaultRulesYAML, "default_rules.yaml")
+		if err != nil {
+			slog.Error("Invalid embedded extraction rules", "error", err)
+			os.Exit(1)
+		}
+		compiled, err := compileExtractionRules(defaults, nil, "default")
+		if err != nil {
+			slog.Error("Invalid embedded extraction rules", "error", err)
+			os.Exit(1)
+		}
+
+		store := &rulesStore{
+			defaults: defaults,
+			path:     os.Getenv("EXTRACTION_RULES_PATH"),
+		}
+		store.current.Store(compiled)
+		extractionRules = store
+
+		if store.path == "" {
+			return
+		}
+		if _, err := store.Reload(); err != nil {
+			slog.Error("Failed to load extraction rules, using defaults", "path", store.path, "error", err)
+		}
+
+		interval := 30 * time.Second
+		if raw := os.Getenv("EXTRACTION_RULES_RELOAD_INTERVAL"); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d >= 0 {
+				interval = d
+			} else {
+				slog.Warn("Invalid EXTRACTION_RULES_RELOAD_INTERVAL, using default", "value", raw, "default", interval)
+			}
+		}
+		go store.watch(interval)
+	})
+	return extractionRules
+}
+
+// Reload vuelve a leer el archivo de reglas. Si es inválido se mantienen las reglas activas.
+// Un archivo que no se puede leer retorna ErrUnavailable; uno con contenido inválido,
+// ErrInvalidInput.
+func (s *rulesStore) Reload() (*compiledRules, error) {
+	if s.path == "" {
+		return s.current.Load(), fmt.Errorf("EXTRACTION_RULES_PATH is not set")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, wrapScraperError(ErrUnavailable, err, "failed to stat rules file")
+	}
+	// Se registra aunque el archivo sea inválido para no reintentar hasta que vuelva a cambiar
+	s.modTime = info.ModTime()
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, wrapScraperError(ErrUnavailable, err, "failed to read rules file")
+	}
+	rules, err := parseExtractionRules(data, s.path)
+	if err != nil {
+		return nil, wrapScraperError(ErrInvalidInput, err, "invalid rules file")
+	}
+	compiled, err := compileExtractionRules(rules, s.defaults, s.path)
+	if err != nil {
+		return nil, wrapScraperError(ErrInvalidInput, err, "invalid rules file")
+	}
+
+	s.current.Store(compiled)
+	slog.Info("Extraction rules loaded", "path", s.path, "version", compiled.Version, "revision", compiled.Revision)
+	return compiled, nil
+}
+
+// watch recarga las reglas cuando cambia la fecha de modificación del archivo o al recibir SIGHUP
+func (s *rulesStore) watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			if _, err := s.Reload(); err != nil {
+				slog.Error("Failed to reload extraction rules", "path", s.path, "error", err)
+			}
+		case <-tick:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				continue
+			}
+			s.mu.Lock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mu.Unlock()
+			if changed {
+				if _, err := s.Reload(); err != nil {
+					slog.Error("Failed to reload extraction rules", "path", s.path, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// rulesSummary describe las reglas activas para los endpoints de administración
+func rulesSummary(rules *compiledRules) gin.H {
+	return gin.H{
+		"version":   rules.Version,
+		"revision":  rules.Revision,
+		"source":    rules.Source,
+		"loaded_at": rules.LoadedAt,
+		"search":    rules.Search.PageRules,
+		"detail":    rules.Detail.PageRules,
+	}
+}
+
+// handleGetExtractionRules muestra las reglas de extracción activas
+// GET /admin/extraction-rules
+func handleGetExtractionRules(c *gin.Context) {
+	c.JSON(http.StatusOK, rulesSummary(getExtractionRules()))
+}
+
+// handleReloadExtractionRules vuelve a leer EXTRACTION_RULES_PATH sin reiniciar el servidor.
+// Si el archivo es inválido se mantienen las reglas activas y se responde 400 con el motivo;
+// si no se puede leer (no existe, sin permisos) se responde 503.
+// POST /admin/extraction-rules/reload
+func handleReloadExtractionRules(c *gin.Context) {
+	store := getRulesStore()
+	if store.path == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "la recarga requiere configurar EXTRACTION_RULES_PATH",
+			"code":  ErrUnavailable,
+		})
+		return
+	}
+
+	rules, err := store.Reload()
+	if err != nil {
+		loggerFrom(c.Request.Context()).Warn("Failed to reload extraction rules", "path", store.path, "error", err)
+		if errorKindOf(err) == ErrUnavailable {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": fmt.Sprintf("no se pudo leer EXTRACTION_RULES_PATH, se mantienen las reglas anteriores: %v", err),
+				"code":  ErrUnavailable,
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("reglas inválidas, se mantienen las anteriores: %v", err),
+			"code":  ErrInvalidInput,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, rulesSummary(rules))
+}
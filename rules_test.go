@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// customSearchRules solo redefine search; detail se toma de las reglas por defecto
+const customSearchRules = `version: 1
+revision: custom-1
+search:
+  strategies: [dom]
+  item: 'li.product'
+  selectors:
+    display_name:
+      - {css: h2}
+`
+
+// testRulesStore crea un store con las reglas por defecto que lee path
+func testRulesStore(t *testing.T, path string) *rulesStore {
+	t.Helper()
+	defaults, err := parseExtractionRules(defaultRulesYAML, "default_rules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compileExtractionRules(defaults, nil, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &rulesStore{defaults: defaults, path: path}
+	store.current.Store(compiled)
+	return store
+}
+
+func writeRules(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCustomRulesMergeWithDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, customSearchRules)
+	store := testRulesStore(t, path)
+	defaultDetail := store.current.Load().Detail
+
+	rules, err := store.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.Revision != "custom-1" || rules.Source != path {
+		t.Errorf("revision %q from %q, want custom-1 from %s", rules.Revision, rules.Source, path)
+	}
+	if got := rules.Search.Strategies; len(got) != 1 || got[0] != "dom" || rules.Search.Item != "li.product" {
+		t.Errorf("search rules = %+v, want the custom dom rules", rules.Search.PageRules)
+	}
+	if len(rules.Detail.Strategies) != len(defaultDetail.Strategies) || len(rules.Detail.Fields) != len(defaultDetail.Fields) {
+		t.Errorf("detail rules = %+v, want the defaults", rules.Detail.PageRules)
+	}
+	if store.current.Load() != rules {
+		t.Error("the reloaded rules are not active")
+	}
+}
+
+func TestCustomRulesJSON(t *testing.T) {
+	data := []byte(`{"version": 1, "revision": "json", "detail": {"strategies": ["json_ld"], "fields": {"sku": ["sku"]}}}`)
+	rules, err := parseExtractionRules(data, "rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults, _ := parseExtractionRules(defaultRulesYAML, "default_rules.yaml")
+	compiled, err := compileExtractionRules(rules, defaults, "rules.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compiled.Revision != "json" || compiled.Detail.Strategies[0] != "json_ld" || compiled.Search.Item == "" {
+		t.Errorf("compiled rules = %+v, want the JSON detail and the default search", compiled)
+	}
+}
+
+func TestInvalidRulesAreRejected(t *testing.T) {
+	defaults, _ := parseExtractionRules(defaultRulesYAML, "default_rules.yaml")
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unsupported version", "version: 2\n", "unsupported rules version 2"},
+		{"invalid selector", "version: 1\nsearch:\n  strategies: [dom]\n  item: 'li['\n", "invalid item selector"},
+		{"invalid field selector", "version: 1\ndetail:\n  strategies: [dom]\n  selectors:\n    name:\n      - {css: 'h1:nope'}\n", "invalid selector 'h1:nope'"},
+		{"unknown field", "version: 1\ndetail:\n  strategies: [dom]\n  fields:\n    colour: [colour]\n", "unknown field 'colour'"},
+		{"unknown strategy", "version: 1\ndetail:\n  strategies: [xpath]\n", "unknown strategy 'xpath'"},
+		{"search without item", "version: 1\nsearch:\n  strategies: [dom]\n", "item selector is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := parseExtractionRules([]byte(tt.content), "rules.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = compileExtractionRules(rules, defaults, "rules.yaml")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseExtractionRules([]byte("version: [1"), "rules.yaml"); err == nil {
+		t.Error("malformed YAML was accepted")
+	}
+}
+
+func TestRulesReloadWhenModTimeChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	writeRules(t, path, customSearchRules)
+	store := testRulesStore(t, path)
+	if _, err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	go store.watch(10 * time.Millisecond)
+
+	// Un archivo inválido no reemplaza las reglas activas
+	writeRules(t, path, "version: 2\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the invalid file to be checked", func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return store.modTime.Equal(later)
+	})
+	if got := store.current.Load().Revision; got != "custom-1" {
+		t.Fatalf("revision after an invalid file = %q, want custom-1", got)
+	}
+
+	writeRules(t, path, strings.Replace(customSearchRules, "custom-1", "custom-2", 1))
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the changed file to be reloaded", func() bool {
+		return store.current.Load().Revision == "custom-2"
+	})
+}
+
+func TestReloadExtractionRulesEndpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	store := testRulesStore(t, path)
+	getRulesStore()
+	previous := extractionRules
+	extractionRules = store
+	t.Cleanup(func() { extractionRules = previous })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/admin/extraction-rules/reload", handleReloadExtractionRules)
+	reload := func() (int, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("POST", "/admin/extraction-rules/reload", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return recorder.Code, body
+	}
+
+	// Sin archivo no hay nada inválido que corregir: el problema es del servidor
+	if status, body := reload(); status != http.StatusServiceUnavailable || body["code"] != string(ErrUnavailable) {
+		t.Errorf("missing file: status %d %v, want 503 %s", status, body, ErrUnavailable)
+	}
+
+	writeRules(t, path, customSearchRules)
+	if status, body := reload(); status != http.StatusOK || body["revision"] != "custom-1" {
+		t.Errorf("valid file: status %d %v, want 200 with revision custom-1", status, body)
+	}
+
+	writeRules(t, path, "version: 1\nsearch:\n  strategies: [dom]\n  item: 'li['\n")
+	if status, body := reload(); status != http.StatusBadRequest || body["code"] != string(ErrInvalidInput) {
+		t.Errorf("invalid file: status %d %v, want 400 %s", status, body, ErrInvalidInput)
+	}
+	if got := getExtractionRules().Revision; got != "custom-1" {
+		t.Errorf("active revision = %q, want custom-1 after a rejected reload", got)
+	}
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -82,42 +81,40 @@ type SuggestionResponse struct {
 // httpClient es un cliente HTTP configurado con timeout
 var httpClient = &http.Client{
 	Timeout: 30 * time.Second,
-	Transport: &http.Transport{
-		MaxIdleConns:       10,
-		IdleConnTimeout:    30 * time.Second,
-		DisableCompression: false,
+	Transport: &instrumentedTransport{
+		base: upstreamTransport(&http.Transport{
+			MaxIdleConns:       10,
+			IdleConnTimeout:    30 * time.Second,
+			DisableCompression: false,
+		}),
 	},
+	CheckRedirect: queueItCheckRedirect,
 }
 
 // fetchProductDetail obtiene detalles completos de un producto por SKU
-func fetchProductDetail(sku string) (*ProductDetail, error) {
+func fetchProductDetail(ctx context.Context, sku string) (*ProductDetail, error) {
 	if sku == "" {
 		return nil, fmt.Errorf("SKU parameter cannot be empty")
 	}
 
 	// Primero intentamos obtener el producto via API interna
-	detail, err := fetchProductDetailViaAPI(sku)
+	detail, err := fetchProductDetailViaAPI(ctx, sku)
 	if err == nil {
 		return detail, nil
 	}
 
-	log.Printf("API method failed for SKU %s, trying web scraping: %v", sku, err)
+	loggerFrom(ctx).Warn("API method failed, trying web scraping", "sku", sku, "error", err)
 
 	// Si falla la API, intentamos web scraping
-	return fetchProductDetailViaScraping(sku)
+	return fetchProductDetailViaScraping(ctx, sku)
 }
 
 // fetchProductDetailViaAPI intenta obtener datos via API interna
-func fetchProductDetailViaAPI(sku string) (*ProductDetail, error) {
-	// Intentar diferentes endpoints de API que podrían existir
-	endpoints := []string{
-		fmt.Sprintf("https://apps.lider.cl/supermercado/product/%s", sku),
-		fmt.Sprintf("https://www.lider.cl/catalogo/api/products/%s", sku),
-		fmt.Sprintf("https://api.lider.cl/v1/products/%s", sku),
-	}
-
-	for _, endpoint := range endpoints {
-		req, err := http.NewRequest("GET", endpoint, nil)
+func fetchProductDetailViaAPI(ctx context.Context, sku string) (*ProductDetail, error) {
+	// Intentar los endpoints de API del catálogo, en orden
+	for _, target := range getUpstreamEndpoints().targetsOfKind(endpointDetail, upstreamKindAPI, sku, PageRequest{}) {
+		endpoint := target.URL
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
 		if err != nil {
 			continue
 		}
@@ -141,7 +138,7 @@ func fetchProductDetailViaAPI(sku string) (*ProductDetail, error) {
 
 			var detail ProductDetail
 			if err := json.Unmarshal(body, &detail); err == nil {
-				log.Printf("Successfully fetched product detail via API for SKU: %s", sku)
+				loggerFrom(ctx).Info("Fetched product detail via API", "sku", sku, "endpoint", endpoint)
 				return &detail, nil
 			}
 		}
@@ -152,11 +149,15 @@ func fetchProductDetailViaAPI(sku string) (*ProductDetail, error) {
 }
 
 // fetchProductDetailViaScraping obtiene datos mediante web scraping
-func fetchProductDetailViaScraping(sku string) (*ProductDetail, error) {
-	// Construir URL del producto
-	productURL := fmt.Sprintf("https://www.lider.cl/supermercado/product/sku/%s", sku)
+func fetchProductDetailViaScraping(ctx context.Context, sku string) (*ProductDetail, error) {
+	// Construir URL del producto con la primera página de detalle del catálogo
+	pages := getUpstreamEndpoints().targetsOfKind(endpointDetail, upstreamKindPage, sku, PageRequest{})
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no product page endpoint configured")
+	}
+	productURL := pages[0].URL
 
-	req, err := http.NewRequest("GET", productURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", productURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -170,100 +171,55 @@ func fetchProductDetailViaScraping(sku string) (*ProductDetail, error) {
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("Upgrade-Insecure-Requests", "1")
 
-	log.Printf("Scraping product detail for SKU: %s", sku)
+	loggerFrom(ctx).Debug("Scraping product detail", "sku", sku)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("scraping failed with status %d", resp.StatusCode)
+		return nil, statusError("product page", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, wrapScraperError(ErrUpstreamFailure, err, "failed to read response body")
 	}
 
-	html := string(body)
-
-	// Extraer datos usando expresiones regulares
-	detail := &ProductDetail{
-		SKU: sku,
-		URL: productURL,
-		Price: DetailPrice{
-			Currency: "CLP",
-		},
+	detail, err := productDetailFromPage(ctx, sku, body)
+	if err != nil {
+		return nil, err
 	}
 
-	// Extraer nombre del producto
-	if name := extractWithRegex(html, `<h1[^>]*class="[^"]*product-title[^"]*"[^>]*>([^<]+)</h1>`); name != "" {
-		detail.Name = strings.TrimSpace(name)
-	}
+	loggerFrom(ctx).Info("Scraped product detail", "sku", sku)
+	return detail, nil
+}
 
-	// Extraer marca
-	if brand := extractWithRegex(html, `<span[^>]*class="[^"]*brand[^"]*"[^>]*>([^<]+)</span>`); brand != "" {
-		detail.Brand = strings.TrimSpace(brand)
+// productDetailFromPage extrae el detalle del HTML de la página del producto y completa
+// SKU, URL, moneda y descuento
+func productDetailFromPage(ctx context.Context, sku string, body []byte) (*ProductDetail, error) {
+	// Extraer datos con el parser DOM (estado embebido, JSON-LD, OpenGraph y markup)
+	detail := getAdvancedScraper().extractProductDetailFromHTML(ctx, string(body))
+	if detail == nil || (detail.Name == "" && detail.Price.Current == 0) {
+		return nil, newScraperError(ErrParseFailure, "could not extract product data - page structure may have changed")
 	}
 
-	// Extraer precio actual
-	if priceStr := extractWithRegex(html, `<span[^>]*class="[^"]*price[^"]*"[^>]*>\$?([0-9,.]+)</span>`); priceStr != "" {
-		if price := parsePrice(priceStr); price > 0 {
-			detail.Price.Current = price
-		}
+	if detail.SKU == "" {
+		detail.SKU = sku
 	}
-
-	// Extraer precio original
-	if originalPriceStr := extractWithRegex(html, `<span[^>]*class="[^"]*original-price[^"]*"[^>]*>\$?([0-9,.]+)</span>`); originalPriceStr != "" {
-		if price := parsePrice(originalPriceStr); price > 0 {
-			detail.Price.Original = price
-		}
+	detail.URL = getUpstreamEndpoints().productURL(sku)
+	if detail.Price.Currency == "" {
+		detail.Price.Currency = "CLP"
 	}
 
 	// Calcular descuento
 	if detail.Price.Original > 0 && detail.Price.Current > 0 {
 		detail.Price.Discount = ((detail.Price.Original - detail.Price.Current) / detail.Price.Original) * 100
 	}
-
-	// Extraer disponibilidad
-	if strings.Contains(html, "en-stock") || strings.Contains(html, "available") {
-		detail.Availability = true
-	}
-
-	// Extraer rating
-	if ratingStr := extractWithRegex(html, `"rating"\s*:\s*([0-9.]+)`); ratingStr != "" {
-		fmt.Sscanf(ratingStr, "%f", &detail.Rating)
-	}
-
-	// Extraer imágenes
-	imageRegex := regexp.MustCompile(`<img[^>]+src="([^"]+)"[^>]*(?:class="[^"]*product-image[^"]*"|alt="[^"]*product[^"]*")`)
-	matches := imageRegex.FindAllStringSubmatch(html, -1)
-	for _, match := range matches {
-		if len(match) > 1 {
-			detail.Images = append(detail.Images, match[1])
-		}
-	}
-
-	// Si no encontramos datos básicos, la página podría haber cambiado
-	if detail.Name == "" && detail.Price.Current == 0 {
-		return nil, fmt.Errorf("could not extract product data - page structure may have changed")
-	}
-
-	log.Printf("Successfully scraped product detail for SKU: %s", sku)
 	return detail, nil
 }
 
-// extractWithRegex extrae texto usando expresión regular
-func extractWithRegex(html, pattern string) string {
-	re := regexp.MustCompile(pattern)
-	matches := re.FindStringSubmatch(html)
-	if len(matches) > 1 {
-		return matches[1]
-	}
-	return ""
-}
-
 // parsePrice convierte string de precio a float64
 func parsePrice(priceStr string) float64 {
 	// Remover puntos de miles y reemplazar coma decimal
@@ -277,6 +233,24 @@ func parsePrice(priceStr string) float64 {
 	return price
 }
 
+// decodeResponse parsea la respuesta JSON de /search, /promotions y /category
+func decodeResponse(body []byte) (*Response, error) {
+	var r Response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	return &r, nil
+}
+
+// decodeSuggestions parsea la respuesta JSON de /suggestions
+func decodeSuggestions(body []byte) (*SuggestionResponse, error) {
+	var sr SuggestionResponse
+	if err := json.Unmarshal(body, &sr); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+	return &sr, nil
+}
+
 // extractSKUFromURL extrae SKU de una URL de producto de Lider
 func extractSKUFromURL(productURL string) string {
 	re := regexp.MustCompile(`/sku/(\d+)/`)
@@ -288,175 +262,228 @@ func extractSKUFromURL(productURL string) string {
 }
 
 // fetchProducts usa GET al endpoint público de búsqueda
-func fetchProducts(query string) ([]Product, error) {
+func fetchProducts(ctx context.Context, query string) ([]Product, error) {
 	if query == "" {
-		return nil, fmt.Errorf("query parameter cannot be empty")
+		return nil, newScraperError(ErrInvalidInput, "se requiere parámetro 'q'")
 	}
 
-	endpoint := "https://apps.lider.cl/supermercado/search"
-	u := fmt.Sprintf("%s?query=%s", endpoint, url.QueryEscape(query))
+	endpoints := getUpstreamEndpoints().targetsOfKind(endpointSearch, upstreamKindAPI, query, PageRequest{})
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no search API endpoint configured")
+	}
+	u := endpoints[0].URL
 
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, wrapScraperError(ErrInternal, err, "failed to create request")
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; LiderAPI/1.0)")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Accept-Language", "es-CL,es;q=0.9")
 
-	log.Printf("Fetching products for query: %s", query)
+	loggerFrom(ctx).Debug("Fetching products", "query", query)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, wrapScraperError(ErrUpstreamFailure, err, "failed to read response body")
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("search failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError("search", resp.StatusCode)
 	}
 
-	var r Response
-	if err := json.Unmarshal(body, &r); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	r, err := decodeResponse(body)
+	if err != nil {
+		return nil, wrapScraperError(ErrParseFailure, err, "failed to decode search results")
 	}
 
-	log.Printf("Successfully fetched %d products for query: %s", len(r.Products), query)
+	loggerFrom(ctx).Info("Fetched products via API", "query", query, "count", len(r.Products))
 	return r.Products, nil
 }
 
-// fetchSuggestions usa GET al endpoint de sugerencias
-func fetchSuggestions(term string) ([]string, error) {
+// fetchSuggestions usa GET a los endpoints de sugerencias del catálogo, en orden
+func fetchSuggestions(ctx context.Context, term string) ([]string, error) {
 	if term == "" {
 		return nil, fmt.Errorf("term parameter cannot be empty")
 	}
 
-	endpoint := "https://apps.lider.cl/supermercado/suggestions"
-	u := fmt.Sprintf("%s?term=%s", endpoint, url.QueryEscape(term))
-
-	req, err := http.NewRequest("GET", u, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	breakers := getCircuitBreakers()
+	var skipped []string
+	var failure error
+	for _, target := range getUpstreamEndpoints().targets(endpointSuggestions, term, PageRequest{}) {
+		if !breakers.Allow(ctx, target.Name) {
+			skipped = append(skipped, target.Name)
+			continue
+		}
+		suggestions, err := fetchSuggestionsFrom(ctx, target.URL, term)
+		breakers.Record(ctx, target.Name, err)
+		if err == nil {
+			return suggestions, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		failure = moreSpecific(failure, err)
 	}
-
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; LiderAPI/1.0)")
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Accept-Language", "es-CL,es;q=0.9")
-
-	log.Printf("Fetching suggestions for term: %s", term)
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+	if failure == nil {
+		return nil, circuitOpenError(skipped)
 	}
-	defer resp.Body.Close()
+	return nil, failure
+}
 
-	body, err := io.ReadAll(resp.Body)
+// fetchSuggestionsFrom consulta un endpoint de sugerencias
+func fetchSuggestionsFrom(ctx context.Context, u, term string) ([]string, error) {
+	loggerFrom(ctx).Debug("Fetching suggestions", "term", term)
+	resp, body, err := getJSONWithRetries(ctx, u)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("suggestions failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError("suggestions", resp.StatusCode)
 	}
 
-	var sr SuggestionResponse
-	if err := json.Unmarshal(body, &sr); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	sr, err := decodeSuggestions(body)
+	if err != nil {
+		return nil, wrapScraperError(ErrParseFailure, err, "failed to decode suggestions")
 	}
 
-	log.Printf("Successfully fetched %d suggestions for term: %s", len(sr.Suggestions), term)
+	loggerFrom(ctx).Info("Fetched suggestions", "term", term, "count", len(sr.Suggestions))
 	return sr.Suggestions, nil
 }
 
-// fetchPromotions usa GET al endpoint de promociones
-func fetchPromotions(promoType string) ([]Product, error) {
-	if promoType == "" {
-		return nil, fmt.Errorf("promoType parameter cannot be empty")
+// getJSONWithRetries hace un GET a una API de Lider con el cliente HTTP simple, reintentando
+// los errores de red y los status reintentables (RETRY_STATUSES) según la política de la
+// operación. Los demás status se retornan en la respuesta, como en makeRequest. Igual que
+// makeRequest respeta la compuerta de queue-it y la cierra si recibe la sala de espera.
+func getJSONWithRetries(ctx context.Context, u string) (resp *http.Response, body []byte, err error) {
+	if _, err := queueItDelay(ctx, 0); err != nil {
+		return nil, nil, err
+	}
+	err = retryUpstream(ctx, requestHost(u), func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return wrapScraperError(ErrInternal, err, "failed to create request")
+		}
+
+		req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; LiderAPI/1.0)")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Accept-Language", "es-CL,es;q=0.9")
+
+		resp, err = httpClient.Do(req)
+		if err != nil {
+			return requestError(ctx, err)
+		}
+		defer resp.Body.Close()
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return wrapScraperError(ErrUpstreamFailure, err, "failed to read response body")
+		}
+
+		if isQueueItPage(body) {
+			queueItBlocksTotal.WithLabelValues(resp.Request.URL.Host, "body").Inc()
+			return queuedError(getQueueItGate().Block(ctx, queueItWait(resp.Header, body), "body"))
+		}
+		if getRetryPolicies().RetryStatus(resp.StatusCode) {
+			statusErr := statusError("upstream", resp.StatusCode)
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			return statusErr
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return resp, body, nil
+}
 
-	endpoint := "https://apps.lider.cl/supermercado/promotions"
-	u := fmt.Sprintf("%s?type=%s", endpoint, url.QueryEscape(promoType))
+// fetchPromotions usa GET a un endpoint de promociones del catálogo (u ya incluye
+// el tipo y la página solicitada)
+func fetchPromotions(ctx context.Context, u, promoType string) (*Response, error) {
+	if promoType == "" {
+		return nil, newScraperError(ErrInvalidInput, "se requiere parámetro 'type'")
+	}
 
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, wrapScraperError(ErrInternal, err, "failed to create request")
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; LiderAPI/1.0)")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Accept-Language", "es-CL,es;q=0.9")
 
-	log.Printf("Fetching promotions for type: %s", promoType)
+	loggerFrom(ctx).Debug("Fetching promotions", "type", promoType)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, wrapScraperError(ErrUpstreamFailure, err, "failed to read response body")
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("promotions failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError("promotions", resp.StatusCode)
 	}
 
-	var r Response
-	if err := json.Unmarshal(body, &r); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	r, err := decodeResponse(body)
+	if err != nil {
+		return nil, wrapScraperError(ErrParseFailure, err, "failed to decode promotions")
 	}
 
-	log.Printf("Successfully fetched %d promotions for type: %s", len(r.Products), promoType)
-	return r.Products, nil
+	loggerFrom(ctx).Info("Fetched promotions via API", "type", promoType, "count", len(r.Products))
+	return r, nil
 }
 
-// fetchCategory usa GET al endpoint de categoría
-func fetchCategory(categoryID string) ([]Product, error) {
+// fetchCategory usa GET a un endpoint de categoría del catálogo (u ya incluye
+// la categoría y la página solicitada)
+func fetchCategory(ctx context.Context, u, categoryID string) (*Response, error) {
 	if categoryID == "" {
-		return nil, fmt.Errorf("categoryID parameter cannot be empty")
+		return nil, newScraperError(ErrInvalidInput, "se requiere parámetro 'id'")
 	}
 
-	endpoint := "https://apps.lider.cl/supermercado/category"
-	u := fmt.Sprintf("%s?id=%s", endpoint, url.QueryEscape(categoryID))
-
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, wrapScraperError(ErrInternal, err, "failed to create request")
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; LiderAPI/1.0)")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Accept-Language", "es-CL,es;q=0.9")
 
-	log.Printf("Fetching category for ID: %s", categoryID)
+	loggerFrom(ctx).Debug("Fetching category", "category", categoryID)
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, wrapScraperError(ErrUpstreamFailure, err, "failed to read response body")
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("category failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, statusError("category", resp.StatusCode)
 	}
 
-	var r Response
-	if err := json.Unmarshal(body, &r); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	r, err := decodeResponse(body)
+	if err != nil {
+		return nil, wrapScraperError(ErrParseFailure, err, "failed to decode category")
 	}
 
-	log.Printf("Successfully fetched %d products for category: %s", len(r.Products), categoryID)
-	return r.Products, nil
+	loggerFrom(ctx).Info("Fetched category via API", "category", categoryID, "count", len(r.Products))
+	return r, nil
 }
 
 // fetchProductDetailViaAPI obtiene el detalle completo de un producto por SKU
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
 	"sync"
 )
 
@@ -16,138 +19,250 @@ var (
 // getAdvancedScraper returns the singleton advanced scraper instance
 func getAdvancedScraper() *AdvancedScraper {
 	scraperOnce.Do(func() {
-		advancedScraper = NewAdvancedScraper()
-		log.Println("Advanced scraper initialized with anti-bot protection")
+		advancedScraper = NewAdvancedScraper(scraperTransport())
+		slog.Info("Advanced scraper initialized with anti-bot protection")
 	})
 	return advancedScraper
 }
 
 // fetchProductsAdvanced replaces the original fetchProducts function
-func fetchProductsAdvanced(query string) ([]Product, error) {
+func fetchProductsAdvanced(ctx context.Context, query string, page PageRequest) (*ProductPage, FetchMeta, error) {
 	if query == "" {
-		return nil, fmt.Errorf("query parameter cannot be empty")
+		return nil, FetchMeta{}, newScraperError(ErrInvalidInput, "se requiere parámetro 'q'")
 	}
 
+	key := cacheKey(endpointSearch, append([]string{cacheQuery(query)}, page.cacheParts()...)...)
+	if entry, ok := cacheLookup(endpointSearch, key); ok {
+		loggerFrom(ctx).Debug("Cache hit", "endpoint", endpointSearch, "query", query, "page", page.Page, "age", entry.Age())
+		recordFetchResult(endpointSearch, "cache")
+		return entry.Value.(*ProductPage), FetchMeta{Source: "cache", CacheAge: entry.Age()}, nil
+	}
+
+	ctx, cancel := withUpstreamDeadline(ctx, endpointSearch)
+	defer cancel()
+
 	scraper := getAdvancedScraper()
-	result := scraper.FetchProductsAdvanced(query)
+	result := scraper.FetchProductsAdvanced(ctx, query, page)
+	recordFetchResult(endpointSearch, result.Source)
 
 	if !result.Success {
-		return nil, fmt.Errorf("search failed: %s", result.Error)
+		return nil, FetchMeta{}, fmt.Errorf("search failed: %w", result.failure())
 	}
 
 	// Convert result data to []Product
 	products, err := convertToProducts(result.Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert search results: %w", err)
+		return nil, FetchMeta{}, wrapScraperError(ErrParseFailure, err, "failed to convert search results")
 	}
 
-	log.Printf("Successfully fetched %d products for query '%s' using %s", len(products), query, result.Source)
-	return products, nil
+	total, pages := pageInfoFromData(result.Data)
+	productPage := newProductPage(products, total, pages, page)
+	cacheStore(endpointSearch, key, productPage)
+
+	loggerFrom(ctx).Info("Fetched products", "query", query, "page", page.Page, "count", len(products), "source", result.Source)
+	return productPage, FetchMeta{Source: result.Source}, nil
 }
 
 // fetchProductDetailAdvanced replaces the original fetchProductDetail function
-func fetchProductDetailAdvanced(sku string) (*ProductDetail, error) {
+func fetchProductDetailAdvanced(ctx context.Context, sku string) (*ProductDetail, FetchMeta, error) {
 	if sku == "" {
-		return nil, fmt.Errorf("SKU parameter cannot be empty")
+		return nil, FetchMeta{}, newScraperError(ErrInvalidInput, "se requiere parámetro 'sku'")
 	}
 
+	key := cacheKey(endpointDetail, sku)
+	if entry, ok := cacheLookup(endpointDetail, key); ok {
+		loggerFrom(ctx).Debug("Cache hit", "endpoint", endpointDetail, "sku", sku, "age", entry.Age())
+		recordFetchResult(endpointDetail, "cache")
+		return entry.Value.(*ProductDetail), FetchMeta{Source: "cache", CacheAge: entry.Age()}, nil
+	}
+
+	ctx, cancel := withUpstreamDeadline(ctx, endpointDetail)
+	defer cancel()
+
 	scraper := getAdvancedScraper()
-	result := scraper.FetchProductDetailAdvanced(sku)
+	result := scraper.FetchProductDetailAdvanced(ctx, sku)
+	recordFetchResult(endpointDetail, result.Source)
 
 	if !result.Success {
-		return nil, fmt.Errorf("product detail fetch failed: %s", result.Error)
+		return nil, FetchMeta{}, fmt.Errorf("product detail fetch failed: %w", result.failure())
 	}
 
 	// Convert result data to ProductDetail
 	detail, err := convertToProductDetail(result.Data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert product detail: %w", err)
+		return nil, FetchMeta{}, wrapScraperError(ErrParseFailure, err, "failed to convert product detail")
 	}
 
-	log.Printf("Successfully fetched product detail for SKU '%s' using %s", sku, result.Source)
-	return detail, nil
+	cacheStore(endpointDetail, key, detail)
+
+	loggerFrom(ctx).Info("Fetched product detail", "sku", sku, "source", result.Source)
+	return detail, FetchMeta{Source: result.Source}, nil
 }
 
 // fetchSuggestionsAdvanced provides suggestions with fallback
-func fetchSuggestionsAdvanced(term string) ([]string, error) {
+func fetchSuggestionsAdvanced(ctx context.Context, term string) ([]string, error) {
 	if term == "" {
-		return nil, fmt.Errorf("term parameter cannot be empty")
+		return nil, newScraperError(ErrInvalidInput, "se requiere parámetro 'term'")
 	}
 
+	ctx, cancel := withUpstreamDeadline(ctx, endpointSuggestions)
+	defer cancel()
+
 	// Try original method first (it might work for suggestions)
-	suggestions, err := fetchSuggestions(term)
+	suggestions, err := fetchSuggestions(ctx, term)
 	if err == nil && len(suggestions) > 0 {
 		return suggestions, nil
 	}
+	if suggestionsUnavailable(err) {
+		// Lider no está atendiendo: se informa (y se puede estacionar) en vez de inventar sugerencias
+		return nil, fmt.Errorf("suggestions failed: %w", err)
+	}
 
 	// Fallback: generate suggestions based on common search terms
 	fallbackSuggestions := generateFallbackSuggestions(term)
 	if len(fallbackSuggestions) > 0 {
-		log.Printf("Using fallback suggestions for term '%s'", term)
+		loggerFrom(ctx).Info("Using fallback suggestions", "term", term, "error", err)
 		return fallbackSuggestions, nil
 	}
 
-	return nil, fmt.Errorf("suggestions failed: %s", err.Error())
+	if err == nil {
+		err = newScraperError(ErrNotFound, "no suggestions for term '%s'", term)
+	}
+	return nil, fmt.Errorf("suggestions failed: %w", err)
+}
+
+// suggestionsUnavailable indica si la falla de las sugerencias viene de que Lider no atiende
+// (fila de queue-it, bloqueo, rate limit o todos los circuitos abiertos). Las demás fallas
+// (sin resultados, respuesta ilegible, error puntual) usan las sugerencias de respaldo.
+func suggestionsUnavailable(err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return true
+	}
+	switch errorKindOf(err) {
+	case ErrUpstreamQueued, ErrUpstreamBlocked, ErrUpstreamRateLimited:
+		return true
+	}
+	return false
 }
 
 // fetchPromotionsAdvanced handles promotions with advanced scraping
-func fetchPromotionsAdvanced(promoType string) ([]Product, error) {
+func fetchPromotionsAdvanced(ctx context.Context, promoType string, page PageRequest) (*ProductPage, FetchMeta, error) {
 	if promoType == "" {
-		return nil, fmt.Errorf("promoType parameter cannot be empty")
+		return nil, FetchMeta{}, newScraperError(ErrInvalidInput, "se requiere parámetro 'type'")
 	}
 
-	// Try original method first
-	products, err := fetchPromotions(promoType)
-	if err == nil && len(products) > 0 {
-		return products, nil
+	key := cacheKey(endpointPromotions, append([]string{promoType}, page.cacheParts()...)...)
+	if entry, ok := cacheLookup(endpointPromotions, key); ok {
+		loggerFrom(ctx).Debug("Cache hit", "endpoint", endpointPromotions, "type", promoType, "age", entry.Age())
+		recordFetchResult(endpointPromotions, "cache")
+		return entry.Value.(*ProductPage), FetchMeta{Source: "cache", CacheAge: entry.Age()}, nil
 	}
 
-	// Fallback: try to scrape promotions page
-	scraper := getAdvancedScraper()
-	promoURL := fmt.Sprintf("https://www.lider.cl/supermercado/ofertas?type=%s", promoType)
-	result := scraper.scrapeSearchPage(promoURL)
+	ctx, cancel := withUpstreamDeadline(ctx, endpointPromotions)
+	defer cancel()
 
-	if !result.Success {
-		return nil, fmt.Errorf("promotions failed: original API error: %s, scraping error: %s", err.Error(), result.Error)
-	}
-
-	products, convErr := convertToProducts(result.Data)
-	if convErr != nil {
-		return nil, fmt.Errorf("failed to convert promotion results: %w", convErr)
+	// Try the catalogue endpoints in order (internal API first, then the offers page)
+	productPage, source, err := fetchListing(ctx, endpointPromotions, promoType, page, func(ctx context.Context, u string) (*Response, error) {
+		return fetchPromotions(ctx, u, promoType)
+	})
+	recordFetchResult(endpointPromotions, source)
+	if err != nil {
+		return nil, FetchMeta{}, fmt.Errorf("promotions failed: %w", err)
 	}
+	cacheStore(endpointPromotions, key, productPage)
 
-	log.Printf("Successfully fetched %d promotions for type '%s' using scraping", len(products), promoType)
-	return products, nil
+	loggerFrom(ctx).Info("Fetched promotions", "type", promoType, "page", page.Page, "count", len(productPage.Products), "source", source)
+	return productPage, FetchMeta{Source: source}, nil
 }
 
 // fetchCategoryAdvanced handles category products with advanced scraping
-func fetchCategoryAdvanced(categoryID string) ([]Product, error) {
+func fetchCategoryAdvanced(ctx context.Context, categoryID string, page PageRequest) (*ProductPage, FetchMeta, error) {
 	if categoryID == "" {
-		return nil, fmt.Errorf("categoryID parameter cannot be empty")
+		return nil, FetchMeta{}, newScraperError(ErrInvalidInput, "se requiere parámetro 'id'")
 	}
 
-	// Try original method first
-	products, err := fetchCategory(categoryID)
-	if err == nil && len(products) > 0 {
-		return products, nil
+	key := cacheKey(endpointCategory, append([]string{categoryID}, page.cacheParts()...)...)
+	if entry, ok := cacheLookup(endpointCategory, key); ok {
+		loggerFrom(ctx).Debug("Cache hit", "endpoint", endpointCategory, "category", categoryID, "age", entry.Age())
+		recordFetchResult(endpointCategory, "cache")
+		return entry.Value.(*ProductPage), FetchMeta{Source: "cache", CacheAge: entry.Age()}, nil
 	}
 
-	// Fallback: try to scrape category page
-	scraper := getAdvancedScraper()
-	categoryURL := fmt.Sprintf("https://www.lider.cl/supermercado/category/%s", categoryID)
-	result := scraper.scrapeSearchPage(categoryURL)
+	ctx, cancel := withUpstreamDeadline(ctx, endpointCategory)
+	defer cancel()
 
-	if !result.Success {
-		return nil, fmt.Errorf("category failed: original API error: %s, scraping error: %s", err.Error(), result.Error)
+	// Try the catalogue endpoints in order (internal API first, then the category page)
+	productPage, source, err := fetchListing(ctx, endpointCategory, categoryID, page, func(ctx context.Context, u string) (*Response, error) {
+		return fetchCategory(ctx, u, categoryID)
+	})
+	recordFetchResult(endpointCategory, source)
+	if err != nil {
+		return nil, FetchMeta{}, fmt.Errorf("category failed: %w", err)
 	}
+	cacheStore(endpointCategory, key, productPage)
 
-	products, convErr := convertToProducts(result.Data)
-	if convErr != nil {
-		return nil, fmt.Errorf("failed to convert category results: %w", convErr)
+	loggerFrom(ctx).Info("Fetched category", "category", categoryID, "page", page.Page, "count", len(productPage.Products), "source", source)
+	return productPage, FetchMeta{Source: source}, nil
+}
+
+// fetchListing tries the promotions or category endpoints of the catalogue in order:
+// api endpoints through fetchAPI and pages through the search results scraper.
+// It returns the page, the source that answered ("api", "scraping" or "none") and
+// every failure joined, wrapping the last one.
+func fetchListing(ctx context.Context, operation, value string, page PageRequest, fetchAPI func(ctx context.Context, u string) (*Response, error)) (*ProductPage, string, error) {
+	breakers := getCircuitBreakers()
+	var failures, skipped []string
+	var failure error
+	for _, target := range getUpstreamEndpoints().targets(operation, value, page) {
+		if !breakers.Allow(ctx, target.Name) {
+			skipped = append(skipped, target.Name)
+			continue
+		}
+		if target.Kind == upstreamKindAPI {
+			response, err := fetchAPI(ctx, target.URL)
+			breakers.Record(ctx, target.Name, err)
+			if err == nil && len(response.Products) > 0 {
+				recordProductCoverage(ctx, operation, "api", response.Products)
+				return newProductPage(response.Products, response.NbHits, response.NbPages, page), "api", nil
+			}
+			failures = append(failures, fmt.Sprintf("%s error: %s", target.Name, errorString(err)))
+			if err == nil {
+				// La API respondió sin productos
+				err = newScraperError(ErrNotFound, "no results")
+			}
+			failure = moreSpecific(failure, err)
+		} else {
+			result := getAdvancedScraper().scrapeSearchPage(ctx, target.URL)
+			breakers.Record(ctx, target.Name, result.err())
+			if result.Success {
+				products, err := convertToProducts(result.Data)
+				if err != nil {
+					return nil, "scraping", wrapScraperError(ErrParseFailure, err, "failed to convert "+operation+" results")
+				}
+				recordProductCoverage(ctx, operation, "scraping", products)
+				return newProductPage(products, 0, 0, page), "scraping", nil
+			}
+			failures = append(failures, fmt.Sprintf("%s error: %s", target.Name, result.Error))
+			failure = moreSpecific(failure, result.failure())
+		}
+		if ctx.Err() != nil {
+			failure = contextError(ctx)
+			break
+		}
 	}
 
-	log.Printf("Successfully fetched %d products for category '%s' using scraping", len(products), categoryID)
-	return products, nil
+	if len(failures) == 0 {
+		return nil, "none", circuitOpenError(skipped)
+	}
+	return nil, "none", fmt.Errorf("%s: %w", strings.Join(failures, ", "), failure)
+}
+
+// errorString returns err.Error() or a placeholder when the original call returned no error
+func errorString(err error) string {
+	if err == nil {
+		return "no results"
+	}
+	return err.Error()
 }
 
 // convertToProducts converts interface{} to []Product
@@ -372,7 +487,7 @@ func mapInterfaceToProductDetail(data map[string]interface{}) *ProductDetail {
 
 	// Generate URL if SKU is available
 	if detail.SKU != "" && detail.URL == "" {
-		detail.URL = fmt.Sprintf("https://www.lider.cl/supermercado/product/sku/%s", detail.SKU)
+		detail.URL = getUpstreamEndpoints().productURL(detail.SKU)
 	}
 
 	return detail
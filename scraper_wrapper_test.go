@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSuggestionsUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"queued", queuedError(0), true},
+		{"blocked", newScraperError(ErrUpstreamBlocked, "suggestions returned status 403"), true},
+		{"rate limited", newScraperError(ErrUpstreamRateLimited, "suggestions returned status 429"), true},
+		{"every circuit open", circuitOpenError([]string{"apps_suggestions"}), true},
+		{"wrapped circuit open", fmt.Errorf("suggestions: %w", circuitOpenError(nil)), true},
+		{"not found", newScraperError(ErrNotFound, "suggestions returned status 404"), false},
+		{"parse failure", newScraperError(ErrParseFailure, "failed to decode suggestions"), false},
+		{"upstream failure", newScraperError(ErrUpstreamFailure, "suggestions returned status 500"), false},
+		{"untyped error", errors.New("boom"), false},
+		{"no error", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := suggestionsUnavailable(tt.err); got != tt.want {
+				t.Errorf("suggestionsUnavailable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// browserProfile es la huella de un navegador: su User-Agent y los headers que lo acompañan.
+// Una sesión usa siempre el mismo perfil para no mezclar, por ejemplo, un User-Agent de
+// Firefox con los headers Sec-Ch-Ua de Chrome.
+type browserProfile struct {
+	Name      string
+	UserAgent string
+	Headers   map[string]string
+}
+
+// Headers Accept de cada familia de navegadores
+const (
+	chromeAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8"
+	firefoxAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	safariAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+	chromeSecChUa = `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`
+)
+
+// browserProfiles son los perfiles que se asignan a las sesiones nuevas, por turnos
+var browserProfiles = []browserProfile{
+	{
+		Name:      "chrome-windows",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		Headers:   map[string]string{"Accept": chromeAccept, "Sec-Ch-Ua": chromeSecChUa, "Sec-Ch-Ua-Mobile": "?0", "Sec-Ch-Ua-Platform": `"Windows"`},
+	},
+	{
+		Name:      "chrome-macos",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		Headers:   map[string]string{"Accept": chromeAccept, "Sec-Ch-Ua": chromeSecChUa, "Sec-Ch-Ua-Mobile": "?0", "Sec-Ch-Ua-Platform": `"macOS"`},
+	},
+	{
+		Name:      "chrome-linux",
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		Headers:   map[string]string{"Accept": chromeAccept, "Sec-Ch-Ua": chromeSecChUa, "Sec-Ch-Ua-Mobile": "?0", "Sec-Ch-Ua-Platform": `"Linux"`},
+	},
+	{
+		Name:      "firefox-windows",
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		Headers:   map[string]string{"Accept": firefoxAccept},
+	},
+	{
+		Name:      "firefox-macos",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:121.0) Gecko/20100101 Firefox/121.0",
+		Headers:   map[string]string{"Accept": firefoxAccept},
+	},
+	{
+		Name:      "safari-macos",
+		UserAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+		Headers:   map[string]string{"Accept": safariAccept},
+	},
+}
+
+// scraperSession es una sesión de navegador independiente: su propio cookie jar y un perfil fijo
+type scraperSession struct {
+	id        int
+	client    *http.Client
+	profile   browserProfile
+	warmedUp  bool
+	inUse     bool
+	createdAt time.Time
+	lastUsed  time.Time
+	requests  int64
+	blocks    int // bloqueos seguidos; una petición no bloqueada lo vuelve a 0
+}
+
+// SessionPool reparte las peticiones del AdvancedScraper entre sesiones independientes. Cada
+// petición saca una sesión del pool y la devuelve al terminar; una sesión bloqueada por Lider se
+// retira y se reemplaza por una nueva sin afectar a las demás.
+type SessionPool struct {
+	mu          sync.Mutex
+	base        *http.Client // transport, timeout y redirecciones que comparten las sesiones
+	size        int
+	warmUp      bool
+	retireAfter int
+	idle        chan *scraperSession
+	sessions    map[int]*scraperSession
+	open        int
+	nextID      int
+	retired     int64
+}
+
+// NewSessionPool crea un pool de hasta size sesiones. Las sesiones se crean al pedirlas.
+func NewSessionPool(base *http.Client, size int, warmUp bool, retireAfter int) *SessionPool {
+	return &SessionPool{
+		base:        base,
+		size:        size,
+		warmUp:      warmUp,
+		retireAfter: retireAfter,
+		idle:        make(chan *scraperSession, size),
+		sessions:    make(map[int]*scraperSession),
+	}
+}
+
+// newSessionPool crea el pool del AdvancedScraper según SCRAPER_SESSIONS, SCRAPER_SESSION_WARMUP
+// y SCRAPER_SESSION_RETIRE_AFTER
+func newSessionPool(base *http.Client) *SessionPool {
+	size := 4
+	if raw := os.Getenv("SCRAPER_SESSIONS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			size = n
+		} else {
+			slog.Warn("Invalid SCRAPER_SESSIONS, using default", "value", raw, "default", size)
+		}
+	}
+
+	retireAfter := 3
+	if raw := os.Getenv("SCRAPER_SESSION_RETIRE_AFTER"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			retireAfter = n
+		} else {
+			slog.Warn("Invalid SCRAPER_SESSION_RETIRE_AFTER, using default", "value", raw, "default", retireAfter)
+		}
+	}
+
+	// Reproduciendo cassettes no hay página de inicio grabada que visitar
+	warmUp := !strings.EqualFold(os.Getenv("SCRAPER_SESSION_WARMUP"), "false") && !cassetteReplaying()
+
+	return NewSessionPool(base, size, warmUp, retireAfter)
+}
+
+// newSession crea una sesión con un cookie jar vacío y el siguiente perfil de navegador. Requiere mu.
+func (p *SessionPool) newSession() *scraperSession {
+	jar, _ := cookiejar.New(nil)
+	client := *p.base
+	client.Jar = jar
+
+	p.nextID++
+	session := &scraperSession{
+		id:        p.nextID,
+		client:    &client,
+		profile:   browserProfiles[(p.nextID-1)%len(browserProfiles)],
+		createdAt: time.Now(),
+	}
+	p.sessions[session.id] = session
+	scraperSessionsActive.Set(float64(len(p.sessions)))
+	return session
+}
+
+// Acquire saca una sesión libre del pool, crea una si todavía hay cupo o espera a que se
+// libere una. La sesión se debe devolver con Release.
+func (p *SessionPool) Acquire(ctx context.Context) (*scraperSession, error) {
+	var session *scraperSession
+	select {
+	case session = <-p.idle:
+	default:
+		p.mu.Lock()
+		if p.open < p.size {
+			p.open++
+			session = p.newSession()
+		}
+		p.mu.Unlock()
+	}
+
+	if session == nil {
+		select {
+		case session = <-p.idle:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("request cancelled while waiting for a scraper session: %w", contextError(ctx))
+		}
+	}
+
+	p.mu.Lock()
+	session.inUse = true
+	session.lastUsed = time.Now()
+	session.requests++
+	p.mu.Unlock()
+	return session, nil
+}
+
+// Release devuelve la sesión al pool. Si Lider la bloqueó (queue-it, 429 o 503) y ya acumula
+// SCRAPER_SESSION_RETIRE_AFTER bloqueos seguidos, se retira con sus cookies y se reemplaza por
+// una nueva. Una petición no bloqueada reinicia la cuenta: los bloqueos sueltos de una sesión
+// de larga vida no la retiran.
+func (p *SessionPool) Release(ctx context.Context, session *scraperSession, blocked bool) {
+	p.mu.Lock()
+	session.inUse = false
+	if blocked {
+		session.blocks++
+	} else {
+		session.blocks = 0
+	}
+	if session.blocks < p.retireAfter {
+		p.mu.Unlock()
+		p.idle <- session
+		return
+	}
+
+	delete(p.sessions, session.id)
+	p.retired++
+	replacement := p.newSession()
+	p.mu.Unlock()
+
+	scraperSessionRetirementsTotal.Inc()
+	if pool := getProxyPool(); pool != nil {
+		pool.Release(session)
+	}
+	loggerFrom(ctx).Warn("Scraper session retired after being blocked", "session", session.id,
+		"profile", session.profile.Name, "blocks", session.blocks, "requests", session.requests, "replacement", replacement.id)
+	p.idle <- replacement
+}
+
+// setHeaders agrega a la petición el User-Agent y los headers del perfil de la sesión
+func (s *scraperSession) setHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", s.profile.UserAgent)
+	for key, value := range s.profile.Headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// warmUpSession visita la página de inicio con una sesión nueva, como haría un navegador antes
+// de buscar, para que las siguientes peticiones lleven las cookies que entrega Lider. Si la
+// visita falla se vuelve a intentar la próxima vez que se use la sesión.
+func (s *AdvancedScraper) warmUpSession(ctx context.Context, session *scraperSession) {
+	if !s.sessions.warmUp || session.warmedUp || getQueueItGate().Remaining() > 0 {
+		return
+	}
+	if err := s.waitRateLimit(ctx); err != nil {
+		return
+	}
+
+	home := getUpstreamEndpoints().Referer
+	req, err := http.NewRequestWithContext(withProxySession(ctx, session), http.MethodGet, home, nil)
+	if err != nil {
+		return
+	}
+	session.setHeaders(req)
+	req.Header.Set("Accept-Language", "es-CL,es;q=0.9,en;q=0.8")
+	req.Header.Set("Sec-Fetch-Dest", "document")
+	req.Header.Set("Sec-Fetch-Mode", "navigate")
+	req.Header.Set("Sec-Fetch-Site", "none")
+	req.Header.Set("Sec-Fetch-User", "?1")
+	req.Header.Set("Upgrade-Insecure-Requests", "1")
+
+	logger := loggerFrom(ctx).With("session", session.id, "profile", session.profile.Name)
+	resp, err := session.client.Do(req)
+	if err != nil {
+		scraperSessionWarmupsTotal.WithLabelValues("error").Inc()
+		if ctx.Err() == nil {
+			logger.Warn("Scraper session warm-up failed", "url", home, "error", err)
+		}
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err == nil && isQueueItPage(body) {
+		// La fila de queue-it ya está activa: makeRequest esperará lo que indicó
+		scraperSessionWarmupsTotal.WithLabelValues("error").Inc()
+		queueItBlocksTotal.WithLabelValues(req.URL.Host, "body").Inc()
+		getQueueItGate().Block(ctx, queueItWait(resp.Header, body), "body")
+		return
+	}
+	if err != nil || resp.StatusCode >= 400 {
+		scraperSessionWarmupsTotal.WithLabelValues("error").Inc()
+		logger.Warn("Scraper session warm-up failed", "url", home, "status", resp.StatusCode)
+		return
+	}
+	s.sessions.mu.Lock()
+	session.warmedUp = true
+	s.sessions.mu.Unlock()
+	scraperSessionWarmupsTotal.WithLabelValues("success").Inc()
+	logger.Debug("Scraper session warmed up", "url", home, "cookies", len(session.client.Jar.Cookies(req.URL)))
+}
+
+// sessionStatus es el estado de una sesión que se muestra en /admin/sessions
+type sessionStatus struct {
+	ID        int       `json:"id"`
+	Profile   string    `json:"profile"`
+	State     string    `json:"state"`
+	WarmedUp  bool      `json:"warmed_up"`
+	Requests  int64     `json:"requests"`
+	Blocks    int       `json:"blocks"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+// Snapshot retorna el estado de cada sesión abierta ordenado por ID
+func (p *SessionPool) Snapshot() []sessionStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]sessionStatus, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		state := "idle"
+		if session.inUse {
+			state = "in_use"
+		}
+		statuses = append(statuses, sessionStatus{
+			ID:        session.id,
+			Profile:   session.profile.Name,
+			State:     state,
+			WarmedUp:  session.warmedUp,
+			Requests:  session.requests,
+			Blocks:    session.blocks,
+			CreatedAt: session.createdAt,
+			LastUsed:  session.lastUsed,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// handleSessions muestra las sesiones del scraper
+// GET /admin/sessions
+func handleSessions(c *gin.Context) {
+	pool := getAdvancedScraper().sessions
+	pool.mu.Lock()
+	retired := pool.retired
+	pool.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{
+		"size":         pool.size,
+		"warm_up":      pool.warmUp,
+		"retire_after": pool.retireAfter,
+		"retired":      retired,
+		"sessions":     pool.Snapshot(),
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionPoolRetiresAfterRepeatedBlocks(t *testing.T) {
+	pool := NewSessionPool(&http.Client{}, 1, false, 3)
+	ctx := context.Background()
+
+	first, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		pool.Release(ctx, first, true)
+		session, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if session != first {
+			t.Fatalf("session %d replaced after %d blocks, want it kept until 3", first.id, i+1)
+		}
+	}
+
+	pool.Release(ctx, first, true)
+	replacement, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacement == first || replacement.blocks != 0 || pool.retired != 1 {
+		t.Errorf("after 3 blocks got session %d with %d blocks and %d retired, want a new session", replacement.id, replacement.blocks, pool.retired)
+	}
+	if _, ok := pool.sessions[first.id]; ok {
+		t.Error("retired session is still in the pool")
+	}
+}
+
+func TestSessionPoolResetsBlocksAfterSuccess(t *testing.T) {
+	pool := NewSessionPool(&http.Client{}, 1, false, 3)
+	ctx := context.Background()
+
+	// Bloqueos sueltos entre peticiones sanas no retiran la sesión
+	session, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, blocked := range []bool{true, true, false, true, true, false} {
+		pool.Release(ctx, session, blocked)
+		if session, err = pool.Acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if session.blocks != 0 || pool.retired != 0 {
+		t.Errorf("session has %d blocks and %d retired, want 0 and 0", session.blocks, pool.retired)
+	}
+}
+
+func TestMakeRequestReturnsSessionBetweenAttempts(t *testing.T) {
+	scraper := NewAdvancedScraper(http.DefaultTransport)
+	scraper.sessions = NewSessionPool(scraper.client, 1, false, 3)
+
+	// Cada intento devuelve la sesión con sus bloqueos antes de reintentar; con un pool de una
+	// sesión, retenerla entre intentos dejaría al reintento esperando una sesión libre
+	var mu sync.Mutex
+	hits := 0
+	statuses := []int{http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		status := statuses[hits]
+		hits++
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = withRetryPolicy(ctx, RetryPolicy{MaxAttempts: 5, BaseDelay: 0, MaxDelay: 0})
+	resp, _, err := scraper.makeRequest(ctx, http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || hits != 3 {
+		t.Fatalf("status = %d after %d attempts, want 200 after 3", resp.StatusCode, hits)
+	}
+
+	// Los dos bloqueos no alcanzan para retirarla y la respuesta 200 reinicia la cuenta
+	session := <-scraper.sessions.idle
+	if session.requests != 3 || session.blocks != 0 || scraper.sessions.retired != 0 {
+		t.Errorf("session has %d requests, %d blocks and %d retired, want 3, 0 and 0", session.requests, session.blocks, scraper.sessions.retired)
+	}
+}
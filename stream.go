@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Límites del modo streaming
+const (
+	defaultStreamMaxItems = 1000
+	maxStreamMaxItems     = 10000
+	maxStreamPages        = 200
+)
+
+// pageFetcher obtiene una página de productos para el modo streaming
+type pageFetcher func(ctx context.Context, page PageRequest) (*ProductPage, FetchMeta, error)
+
+// streamCursor indica desde dónde retomar un stream interrumpido
+type streamCursor struct {
+	Page    int `json:"page"`
+	Offset  int `json:"offset"`
+	PerPage int `json:"per_page,omitempty"`
+}
+
+// encode serializa el cursor como base64 URL-safe
+func (c streamCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeStreamCursor interpreta un cursor entregado por un stream anterior
+func decodeStreamCursor(raw string) (streamCursor, error) {
+	var cursor streamCursor
+
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, fmt.Errorf("cursor inválido")
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Page < 1 || cursor.Offset < 0 {
+		return cursor, fmt.Errorf("cursor inválido")
+	}
+
+	return cursor, nil
+}
+
+// streamLine es cada línea (NDJSON) o evento (SSE) emitido por el stream
+type streamLine struct {
+	Type     string   `json:"type"` // "product" o "end"
+	Page     int      `json:"page,omitempty"`
+	Product  *Product `json:"product,omitempty"`
+	Items    int      `json:"items,omitempty"`
+	Pages    int      `json:"pages,omitempty"`
+	Complete bool     `json:"complete,omitempty"`
+	Cursor   string   `json:"cursor,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Code     string   `json:"code,omitempty"`
+}
+
+// handleSearchStream recorre todas las páginas de una búsqueda y las transmite a medida que llegan
+func handleSearchStream(c *gin.Context) {
+	q := c.Query("q")
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "se requiere parámetro 'q'",
+			"code":    ErrInvalidInput,
+			"example": "/productos/stream?q=bebidas&max_items=500",
+		})
+		return
+	}
+
+	streamProductPages(c, "query '"+q+"'", func(ctx context.Context, page PageRequest) (*ProductPage, FetchMeta, error) {
+		return fetchProductsAdvanced(ctx, q, page)
+	}, false)
+}
+
+// handleCategoryStream recorre todas las páginas de una categoría y las transmite a medida que llegan
+func handleCategoryStream(c *gin.Context) {
+	cat := c.Query("id")
+	if cat == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "se requiere parámetro 'id'",
+			"code":    ErrInvalidInput,
+			"example": "/categories/stream?id=123&max_items=500",
+		})
+		return
+	}
+
+	// fetchCategoryAdvanced consulta primero la API pública sin pasar por el
+	// rate limiter del AdvancedScraper, así que el stream espera turno entre páginas
+	streamProductPages(c, "category '"+cat+"'", func(ctx context.Context, page PageRequest) (*ProductPage, FetchMeta, error) {
+		return fetchCategoryAdvanced(ctx, cat, page)
+	}, true)
+}
+
+// streamProductPages itera las páginas usando fetch y emite cada producto como NDJSON
+// (por defecto) o SSE (format=sse). La última línea incluye un cursor para retomar.
+func streamProductPages(c *gin.Context, label string, fetch pageFetcher, waitBetweenPages bool) {
+	page, err := parsePageRequest(c)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	maxItems := defaultStreamMaxItems
+	if raw := c.Query("max_items"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 1 || value > maxStreamMaxItems {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("parámetro 'max_items' inválido: debe ser un entero entre 1 y %d", maxStreamMaxItems),
+				"code":  ErrInvalidInput,
+			})
+			return
+		}
+		maxItems = value
+	}
+
+	offset := 0
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := decodeStreamCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": ErrInvalidInput})
+			return
+		}
+		page = PageRequest{Page: cursor.Page, PerPage: cursor.PerPage}
+		offset = cursor.Offset
+	}
+
+	sse := c.Query("format") == "sse"
+	if sse {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Status(http.StatusOK)
+
+	emit := func(line streamLine) error {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		if sse {
+			_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", line.Type, data)
+		} else {
+			_, err = fmt.Fprintf(c.Writer, "%s\n", data)
+		}
+		c.Writer.Flush()
+		return err
+	}
+
+	ctx := c.Request.Context()
+	logger := loggerFrom(ctx).With("stream", label)
+	end := streamLine{Type: "end"}
+	scraper := getAdvancedScraper()
+	seen := make(map[string]bool) // productos ya vistos, para detectar páginas repetidas
+
+	for fetched := 0; fetched < maxStreamPages; fetched++ {
+		if fetched > 0 && waitBetweenPages {
+			if err := scraper.waitRateLimit(ctx); err != nil {
+				logger.Info("Stream cancelled", "page", page.Page)
+				return
+			}
+		}
+
+		result, _, err := fetch(ctx, page)
+		if err != nil {
+			if ctx.Err() != nil {
+				logger.Info("Stream cancelled", "page", page.Page)
+				return
+			}
+			logger.Warn("Stream stopped", "page", page.Page, "error", err)
+			_, kind, message := publicError(err)
+			end.Error = message
+			end.Code = string(kind)
+			end.Cursor = streamCursor{Page: page.Page, Offset: offset, PerPage: page.PerPage}.encode()
+			break
+		}
+		end.Pages++
+
+		products := result.Products
+		unseen := 0
+		for _, product := range products {
+			key := product.ID
+			if key == "" {
+				key = product.DisplayName
+			}
+			if !seen[key] {
+				seen[key] = true
+				unseen++
+			}
+		}
+		if result.Pages == 0 && len(products) > 0 && unseen == 0 {
+			// Sin totales, una página que solo repite productos ya enviados significa que el
+			// destino ignora page: no hay más páginas
+			logger.Info("Stream page repeats previous products, stopping", "page", page.Page)
+			end.Complete = true
+			break
+		}
+		if offset > len(products) {
+			offset = len(products)
+		}
+		for i := offset; i < len(products); i++ {
+			if end.Items >= maxItems {
+				// Se alcanzó el máximo: el cursor apunta al primer producto no enviado
+				end.Cursor = streamCursor{Page: page.Page, Offset: i, PerPage: page.PerPage}.encode()
+				break
+			}
+			if err := emit(streamLine{Type: "product", Page: page.Page, Product: &products[i]}); err != nil {
+				logger.Info("Stream aborted by client", "error", err)
+				return
+			}
+			end.Items++
+		}
+		offset = 0
+
+		if end.Cursor != "" {
+			break
+		}
+
+		hasNext := result.Page < result.Pages
+		if result.Pages == 0 {
+			// Sin totales se sigue mientras la página venga completa
+			hasNext = len(products) > 0 && !result.partial()
+		}
+		if !hasNext {
+			end.Complete = true
+			break
+		}
+
+		page.Page++
+		if end.Items >= maxItems {
+			end.Cursor = streamCursor{Page: page.Page, PerPage: page.PerPage}.encode()
+			break
+		}
+	}
+
+	if !end.Complete && end.Cursor == "" {
+		end.Cursor = streamCursor{Page: page.Page, PerPage: page.PerPage}.encode()
+	}
+
+	logger.Info("Stream finished", "items", end.Items, "pages", end.Pages, "complete", end.Complete)
+	emit(end)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamLines ejecuta streamProductPages con fetch y retorna las líneas NDJSON emitidas
+func streamLines(t *testing.T, target string, fetch pageFetcher) []streamLine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("GET", target, nil)
+
+	streamProductPages(c, "test", fetch, false)
+
+	var lines []streamLine
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var line streamLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestStreamStopsWhenPagesRepeat(t *testing.T) {
+	// Un destino sin totales que ignora page entrega siempre los mismos productos
+	calls := 0
+	fetch := func(ctx context.Context, page PageRequest) (*ProductPage, FetchMeta, error) {
+		calls++
+		products := []Product{{ID: "1"}, {ID: "2"}}
+		return newProductPage(products, 0, 0, page), FetchMeta{}, nil
+	}
+
+	lines := streamLines(t, "/productos/stream?q=leche", fetch)
+	end := lines[len(lines)-1]
+	if calls != 2 {
+		t.Errorf("fetched %d pages, want 2", calls)
+	}
+	if end.Type != "end" || !end.Complete || end.Items != 2 {
+		t.Errorf("end = %+v, want complete with 2 items", end)
+	}
+}
+
+func TestStreamStopsOnPartialPage(t *testing.T) {
+	calls := 0
+	fetch := func(ctx context.Context, page PageRequest) (*ProductPage, FetchMeta, error) {
+		calls++
+		products := []Product{{ID: "a"}, {ID: "b"}}
+		if page.Page == 2 {
+			products = []Product{{ID: "c"}}
+		}
+		return newProductPage(products, 0, 0, page), FetchMeta{}, nil
+	}
+
+	lines := streamLines(t, "/productos/stream?q=leche&per_page=2", fetch)
+	end := lines[len(lines)-1]
+	if calls != 2 {
+		t.Errorf("fetched %d pages, want 2", calls)
+	}
+	if !end.Complete || end.Items != 3 {
+		t.Errorf("end = %+v, want complete with 3 items", end)
+	}
+}
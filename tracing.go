@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifica los spans creados por la API
+const tracerName = "lider-api"
+
+// tracer es el tracer de la API; sin exporter configurado usa el provider no-op global
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing configura el exporter según OTEL_TRACES_EXPORTER:
+//   - "otlp": OTLP/HTTP (endpoint y headers vía OTEL_EXPORTER_OTLP_* estándar)
+//   - "stdout": imprime los spans en stdout, para pruebas locales
+//   - vacío o "none": tracing desactivado
+//
+// Retorna una función que vacía y cierra el exporter.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch kind := strings.ToLower(strings.TrimSpace(os.Getenv("OTEL_TRACES_EXPORTER"))); kind {
+	case "", "none":
+		return noop, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return noop, fmt.Errorf("unknown OTEL_TRACES_EXPORTER '%s' (expected otlp, stdout or none)", kind)
+	}
+	if err != nil {
+		return noop, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "lider-api"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return noop, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	slog.Info("Tracing enabled", "exporter", os.Getenv("OTEL_TRACES_EXPORTER"), "service", serviceName)
+	return provider.Shutdown, nil
+}
+
+// endSpan registra el error (si hay) en el span y lo cierra
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endResultSpan cierra un span a partir del resultado de una operación del scraper
+func endResultSpan(span trace.Span, result *ScrapingResult) {
+	span.SetAttributes(
+		attribute.Bool("scraper.success", result.Success),
+		attribute.String("scraper.source", result.Source),
+	)
+	if !result.Success {
+		span.SetStatus(codes.Error, result.Error)
+	}
+	span.End()
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// supportedEndpointsVersion es la versión del formato del catálogo que entiende este binario
+const supportedEndpointsVersion = 1
+
+//go:embed default_endpoints.yaml
+var defaultEndpointsYAML []byte
+
+// Tipos de endpoint de Lider
+const (
+	upstreamKindAPI  = "api"  // responde JSON
+	upstreamKindPage = "page" // página HTML que se extrae con las reglas de extracción
+)
+
+// upstreamOperationParams es el parámetro que reciben las rutas de cada operación
+var upstreamOperationParams = map[string]string{
+	endpointSearch:      "query",
+	endpointDetail:      "sku",
+	endpointSuggestions: "term",
+	endpointPromotions:  "type",
+	endpointCategory:    "id",
+}
+
+// upstreamPlaceholder encuentra los parámetros {nombre} de una ruta
+var upstreamPlaceholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// UpstreamCatalog es el archivo del catálogo de endpoints de Lider (YAML o JSON)
+type UpstreamCatalog struct {
+	Version    int                         `json:"version" yaml:"version"`
+	Hosts      map[string]string           `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+	Referer    string                      `json:"referer,omitempty" yaml:"referer,omitempty"`
+	ProductURL string                      `json:"product_url,omitempty" yaml:"product_url,omitempty"`
+	Endpoints  map[string]UpstreamEndpoint `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+	Order      map[string][]string         `json:"order,omitempty" yaml:"order,omitempty"`
+}
+
+// UpstreamEndpoint es un endpoint de Lider: host, ruta con parámetros y tipo de respuesta
+type UpstreamEndpoint struct {
+	Kind         string           `json:"kind" yaml:"kind"`
+	Host         string           `json:"host" yaml:"host"`
+	Path         string           `json:"path" yaml:"path"`
+	PerPageParam string           `json:"per_page_param,omitempty" yaml:"per_page_param,omitempty"`
+	FirstPage    *int             `json:"first_page,omitempty" yaml:"first_page,omitempty"` // default: 0 en api, 1 en page
+	Circuit      *UpstreamCircuit `json:"circuit,omitempty" yaml:"circuit,omitempty"`
+}
+
+// firstPage retorna el número que usa el endpoint para su primera página
+func (e UpstreamEndpoint) firstPage() int {
+	if e.FirstPage != nil {
+		return *e.FirstPage
+	}
+	if e.Kind == upstreamKindAPI {
+		return 0
+	}
+	return 1
+}
+
+// UpstreamCircuit reemplaza la configuración del circuit breaker de un endpoint
+type UpstreamCircuit struct {
+	FailureThreshold int    `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty"`
+	Cooldown         string `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+}
+
+// upstreamTarget es una URL concreta a consultar en una operación
+type upstreamTarget struct {
+	Name string
+	Kind string
+	URL  string
+}
+
+// source retorna el origen que se reporta cuando el target responde ("api" o "scraping")
+func (t upstreamTarget) source() string {
+	if t.Kind == upstreamKindPage {
+		return "scraping"
+	}
+	return "api"
+}
+
+// resolvedEndpoint es un endpoint del catálogo con su URL base ya resuelta
+type resolvedEndpoint struct {
+	Name         string           `json:"name"`
+	Kind         string           `json:"kind"`
+	URL          string           `json:"url"`
+	PerPageParam string           `json:"per_page_param,omitempty"`
+	FirstPage    int              `json:"first_page"`
+	Circuit      *UpstreamCircuit `json:"circuit,omitempty"`
+}
+
+// upstreamEndpoints es el catálogo activo
+type upstreamEndpoints struct {
+	Source     string                        `json:"source"`
+	LoadedAt   time.Time                     `json:"loaded_at"`
+	Hosts      map[string]string             `json:"hosts"`
+	Referer    string                        `json:"referer"`
+	ProductURL string                        `json:"product_url"`
+	Order      map[string][]resolvedEndpoint `json:"order"`
+}
+
+// parseUpstreamCatalog interpreta el archivo según su extensión (.json o YAML)
+func parseUpstreamCatalog(data []byte, path string) (*UpstreamCatalog, error) {
+	var catalog UpstreamCatalog
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("failed to parse endpoints JSON: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("failed to parse endpoints YAML: %w", err)
+		}
+	}
+	return &catalog, nil
+}
+
+// mergeUpstreamCatalog combina el catálogo con los valores por defecto: hosts y endpoints
+// se reemplazan por nombre, el orden por operación
+func mergeUpstreamCatalog(catalog, defaults *UpstreamCatalog) *UpstreamCatalog {
+	merged := &UpstreamCatalog{
+		Version:    catalog.Version,
+		Hosts:      make(map[string]string),
+		Referer:    firstNonEmpty(catalog.Referer, defaults.Referer),
+		ProductURL: firstNonEmpty(catalog.ProductURL, defaults.ProductURL),
+		Endpoints:  make(map[string]UpstreamEndpoint),
+		Order:      make(map[string][]string),
+	}
+	for _, source := range []*UpstreamCatalog{defaults, catalog} {
+		for name, base := range source.Hosts {
+			merged.Hosts[name] = base
+		}
+		for name, endpoint := range source.Endpoints {
+			merged.Endpoints[name] = endpoint
+		}
+		for operation, names := range source.Order {
+			merged.Order[operation] = names
+		}
+	}
+	return merged
+}
+
+// compileUpstreamCatalog valida el catálogo y resuelve la URL de cada endpoint
+func compileUpstreamCatalog(catalog *UpstreamCatalog, source string) (*upstreamEndpoints, error) {
+	if catalog.Version != supportedEndpointsVersion {
+		return nil, fmt.Errorf("unsupported endpoints version %d (expected %d)", catalog.Version, supportedEndpointsVersion)
+	}
+
+	hosts := make(map[string]string, len(catalog.Hosts))
+	for name, base := range catalog.Hosts {
+		if err := validateUpstreamBase(base); err != nil {
+			return nil, fmt.Errorf("host '%s': %w", name, err)
+		}
+		hosts[name] = strings.TrimRight(base, "/")
+	}
+	if err := validateUpstreamBase(catalog.Referer); err != nil {
+		return nil, fmt.Errorf("referer: %w", err)
+	}
+	if err := validateUpstreamBase(catalog.ProductURL); err != nil {
+		return nil, fmt.Errorf("product_url: %w", err)
+	}
+	if err := validateUpstreamPlaceholders(catalog.ProductURL, "sku"); err != nil {
+		return nil, fmt.Errorf("product_url: %w", err)
+	}
+
+	compiled := &upstreamEndpoints{
+		Source:     source,
+		LoadedAt:   time.Now(),
+		Hosts:      hosts,
+		Referer:    catalog.Referer,
+		ProductURL: catalog.ProductURL,
+		Order:      make(map[string][]resolvedEndpoint),
+	}
+
+	for operation := range catalog.Order {
+		if _, ok := upstreamOperationParams[operation]; !ok {
+			return nil, fmt.Errorf("unknown operation '%s' in order (valid: %s)", operation, strings.Join(upstreamOperations(), ", "))
+		}
+	}
+	for _, operation := range upstreamOperations() {
+		names := catalog.Order[operation]
+		if len(names) == 0 {
+			return nil, fmt.Errorf("order: at least one endpoint is required for %s", operation)
+		}
+		for _, name := range names {
+			endpoint, ok := catalog.Endpoints[name]
+			if !ok {
+				return nil, fmt.Errorf("order %s: unknown endpoint '%s'", operation, name)
+			}
+			if endpoint.Kind != upstreamKindAPI && endpoint.Kind != upstreamKindPage {
+				return nil, fmt.Errorf("endpoint '%s': unknown kind '%s' (valid: api, page)", name, endpoint.Kind)
+			}
+			if operation == endpointSuggestions && endpoint.Kind != upstreamKindAPI {
+				return nil, fmt.Errorf("order %s: endpoint '%s' must be of kind api", operation, name)
+			}
+			base, ok := hosts[endpoint.Host]
+			if !ok {
+				return nil, fmt.Errorf("endpoint '%s': unknown host '%s'", name, endpoint.Host)
+			}
+			if !strings.HasPrefix(endpoint.Path, "/") {
+				return nil, fmt.Errorf("endpoint '%s': path must start with /", name)
+			}
+			if err := validateUpstreamPlaceholders(endpoint.Path, upstreamOperationParams[operation]); err != nil {
+				return nil, fmt.Errorf("endpoint '%s' in %s: %w", name, operation, err)
+			}
+			if first := endpoint.firstPage(); first != 0 && first != 1 {
+				return nil, fmt.Errorf("endpoint '%s': first_page must be 0 or 1", name)
+			}
+			if circuit := endpoint.Circuit; circuit != nil {
+				if circuit.FailureThreshold < 0 {
+					return nil, fmt.Errorf("endpoint '%s': circuit failure_threshold must be positive", name)
+				}
+				if circuit.Cooldown != "" {
+					if d, err := time.ParseDuration(circuit.Cooldown); err != nil || d <= 0 {
+						return nil, fmt.Errorf("endpoint '%s': invalid circuit cooldown '%s'", name, circuit.Cooldown)
+					}
+				}
+			}
+			compiled.Order[operation] = append(compiled.Order[operation], resolvedEndpoint{
+				Name:         name,
+				Kind:         endpoint.Kind,
+				URL:          base + endpoint.Path,
+				PerPageParam: endpoint.PerPageParam,
+				FirstPage:    endpoint.firstPage(),
+				Circuit:      endpoint.Circuit,
+			})
+		}
+	}
+
+	return compiled, nil
+}
+
+// upstreamOperations retorna las operaciones del catálogo en orden alfabético
+func upstreamOperations() []string {
+	operations := make([]string, 0, len(upstreamOperationParams))
+	for operation := range upstreamOperationParams {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// validateUpstreamBase revisa que la URL tenga esquema y host
+func validateUpstreamBase(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("'%s' must be an absolute URL (e.g. https://apps.lider.cl)", raw)
+	}
+	return nil
+}
+
+// validateUpstreamPlaceholders revisa que la plantilla solo use el parámetro de su operación
+func validateUpstreamPlaceholders(template, param string) error {
+	for _, match := range upstreamPlaceholder.FindAllStringSubmatch(template, -1) {
+		if match[1] != param {
+			return fmt.Errorf("unknown parameter {%s} (expected {%s})", match[1], param)
+		}
+	}
+	return nil
+}
+
+// expandUpstreamURL reemplaza el parámetro de la plantilla, escapándolo según vaya
+// en la ruta o en la query
+func expandUpstreamURL(template, param, value string) string {
+	placeholder := "{" + param + "}"
+	path, query, hasQuery := strings.Cut(template, "?")
+	path = strings.ReplaceAll(path, placeholder, url.PathEscape(value))
+	if !hasQuery {
+		return path
+	}
+	return path + "?" + strings.ReplaceAll(query, placeholder, url.QueryEscape(value))
+}
+
+// targets retorna las URLs a probar en la operación, en el orden configurado
+func (e *upstreamEndpoints) targets(operation, value string, page PageRequest) []upstreamTarget {
+	param := upstreamOperationParams[operation]
+	endpoints := e.Order[operation]
+	targets := make([]upstreamTarget, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		targets = append(targets, upstreamTarget{
+			Name: endpoint.Name,
+			Kind: endpoint.Kind,
+			URL:  withPageParams(expandUpstreamURL(endpoint.URL, param, value), page, endpoint.PerPageParam, endpoint.FirstPage),
+		})
+	}
+	return targets
+}
+
+// targetsOfKind retorna solo los targets de un tipo (api o page)
+func (e *upstreamEndpoints) targetsOfKind(operation, kind, value string, page PageRequest) []upstreamTarget {
+	var targets []upstreamTarget
+	for _, target := range e.targets(operation, value, page) {
+		if target.Kind == kind {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// circuitOverrides retorna la configuración del circuit breaker de los endpoints que la
+// reemplazan en el catálogo, completada con los valores por defecto
+func (e *upstreamEndpoints) circuitOverrides(defaults CircuitBreakerConfig) map[string]CircuitBreakerConfig {
+	overrides := make(map[string]CircuitBreakerConfig)
+	for _, endpoints := range e.Order {
+		for _, endpoint := range endpoints {
+			if endpoint.Circuit == nil {
+				continue
+			}
+			config := defaults
+			if endpoint.Circuit.FailureThreshold > 0 {
+				config.FailureThreshold = endpoint.Circuit.FailureThreshold
+			}
+			if d, err := time.ParseDuration(endpoint.Circuit.Cooldown); err == nil {
+				config.Cooldown = d
+			}
+			overrides[endpoint.Name] = config
+		}
+	}
+	return overrides
+}
+
+// productURL retorna la URL pública del producto
+func (e *upstreamEndpoints) productURL(sku string) string {
+	return expandUpstreamURL(e.ProductURL, "sku", sku)
+}
+
+// Global upstream endpoint catalogue
+var (
+	upstreamCatalog     *upstreamEndpoints
+	upstreamCatalogOnce sync.Once
+)
+
+// getUpstreamEndpoints returns the endpoint catalogue: the embedded defaults merged with
+// UPSTREAM_ENDPOINTS_PATH and the UPSTREAM_<HOST>_URL overrides
+func getUpstreamEndpoints() *upstreamEndpoints {
+	upstreamCatalogOnce.Do(func() {
+		catalog, source, err := loadUpstreamCatalog(os.Getenv("UPSTREAM_ENDPOINTS_PATH"))
+		if err != nil {
+			slog.Error("Failed to load upstream endpoints", "error", err)
+			os.Exit(1)
+		}
+		compiled, err := compileUpstreamCatalog(catalog, source)
+		if err != nil {
+			slog.Error("Invalid upstream endpoints", "source", source, "error", err)
+			os.Exit(1)
+		}
+		upstreamCatalog = compiled
+		if source != "default" {
+			slog.Info("Upstream endpoints loaded", "source", source, "hosts", compiled.Hosts)
+		}
+	})
+	return upstreamCatalog
+}
+
+// loadUpstreamCatalog lee el catálogo por defecto, el archivo opcional y los hosts de las
+// variables de entorno, y retorna el resultado con una descripción de su origen.
+// UPSTREAM_MOCK_URL no se aplica aquí sino en el transport, después: solo redirige al mock
+// las peticiones que sigan apuntando a *.lider.cl, así que un UPSTREAM_<HOST>_URL hacia
+// otro servidor tiene prioridad para ese host.
+func loadUpstreamCatalog(path string) (*UpstreamCatalog, string, error) {
+	catalog, err := parseUpstreamCatalog(defaultEndpointsYAML, "default_endpoints.yaml")
+	if err != nil {
+		return nil, "", err
+	}
+	source := "default"
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := parseUpstreamCatalog(data, path)
+		if err != nil {
+			return nil, "", err
+		}
+		catalog = mergeUpstreamCatalog(file, catalog)
+		source = path
+	}
+
+	var overrides []string
+	for name := range catalog.Hosts {
+		envName := "UPSTREAM_" + strings.ToUpper(name) + "_URL"
+		if raw := os.Getenv(envName); raw != "" {
+			if err := validateUpstreamBase(raw); err != nil {
+				return nil, "", fmt.Errorf("invalid %s: %w", envName, err)
+			}
+			catalog.Hosts[name] = raw
+			overrides = append(overrides, envName)
+		}
+	}
+	if len(overrides) > 0 {
+		sort.Strings(overrides)
+		source += " + " + strings.Join(overrides, ", ")
+	}
+	return catalog, source, nil
+}
+
+// handleGetUpstreamEndpoints muestra el catálogo activo y el orden de los endpoints por operación
+// GET /admin/upstream-endpoints
+func handleGetUpstreamEndpoints(c *gin.Context) {
+	c.JSON(http.StatusOK, getUpstreamEndpoints())
+}
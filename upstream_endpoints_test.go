@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// targetNames retorna los nombres de los targets en el orden en que se prueban
+func targetNames(targets []upstreamTarget) []string {
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+// loadTestCatalog carga y compila el catálogo con el archivo opcional en path
+func loadTestCatalog(t *testing.T, path string) *upstreamEndpoints {
+	t.Helper()
+	catalog, source, err := loadUpstreamCatalog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled, err := compileUpstreamCatalog(catalog, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled
+}
+
+func TestDefaultCatalogTargetOrder(t *testing.T) {
+	endpoints := loadTestCatalog(t, "")
+
+	want := map[string][]string{
+		endpointSearch:      {"apps_search", "www_search_page"},
+		endpointDetail:      {"apps_product_query", "apps_product", "www_catalog_product", "www_product_page"},
+		endpointSuggestions: {"apps_suggestions"},
+		endpointPromotions:  {"apps_promotions", "www_promotions_page"},
+		endpointCategory:    {"apps_category", "www_category_page"},
+	}
+	for operation, names := range want {
+		if got := targetNames(endpoints.targets(operation, "x", PageRequest{})); !reflect.DeepEqual(got, names) {
+			t.Errorf("%s order = %v, want %v", operation, got, names)
+		}
+	}
+
+	targets := endpoints.targets(endpointDetail, "45 22", PageRequest{})
+	if targets[0].URL != "https://apps.lider.cl/supermercado/product?sku=45+22" ||
+		targets[1].URL != "https://apps.lider.cl/supermercado/product/45%2022" {
+		t.Errorf("detail URLs = %s, %s; want the SKU escaped for the query and the path", targets[0].URL, targets[1].URL)
+	}
+	if pages := endpoints.targetsOfKind(endpointSearch, upstreamKindPage, "leche", PageRequest{}); len(pages) != 1 || pages[0].source() != "scraping" {
+		t.Errorf("page targets = %+v, want the search page", pages)
+	}
+}
+
+func TestCatalogFileMergesWithDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	content := `version: 1
+hosts:
+  www: https://staging.lider.cl/
+endpoints:
+  apps_search:
+    kind: api
+    host: apps
+    path: /v2/search?q={query}
+order:
+  detail: [www_product_page, apps_product_query]
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	endpoints := loadTestCatalog(t, path)
+
+	if endpoints.Source != path {
+		t.Errorf("source = %q, want %q", endpoints.Source, path)
+	}
+	// El orden se reemplaza por operación: detail cambia y search queda como estaba
+	if got := targetNames(endpoints.targets(endpointDetail, "1", PageRequest{})); !reflect.DeepEqual(got, []string{"www_product_page", "apps_product_query"}) {
+		t.Errorf("detail order = %v, want the file order", got)
+	}
+	search := endpoints.targets(endpointSearch, "leche", PageRequest{})
+	if got := targetNames(search); !reflect.DeepEqual(got, []string{"apps_search", "www_search_page"}) {
+		t.Errorf("search order = %v, want the default order", got)
+	}
+	// Hosts y endpoints se reemplazan por nombre; los demás vienen del catálogo por defecto
+	if search[0].URL != "https://apps.lider.cl/v2/search?q=leche" {
+		t.Errorf("apps_search URL = %s, want the file's path", search[0].URL)
+	}
+	if search[1].URL != "https://staging.lider.cl/supermercado/search?query=leche" {
+		t.Errorf("www_search_page URL = %s, want the file's www host without the trailing slash", search[1].URL)
+	}
+	if endpoints.ProductURL != "https://www.lider.cl/supermercado/product/sku/{sku}" {
+		t.Errorf("product_url = %s, want the default", endpoints.ProductURL)
+	}
+}
+
+func TestCatalogHostEnvOverrides(t *testing.T) {
+	t.Setenv("UPSTREAM_APPS_URL", "http://localhost:9090/apps")
+	endpoints := loadTestCatalog(t, "")
+
+	if !strings.HasSuffix(endpoints.Source, "UPSTREAM_APPS_URL") {
+		t.Errorf("source = %q, want it to name the override", endpoints.Source)
+	}
+	search := endpoints.targets(endpointSearch, "leche", PageRequest{})
+	if search[0].URL != "http://localhost:9090/apps/supermercado/search?query=leche" {
+		t.Errorf("apps_search URL = %s, want the overridden host", search[0].URL)
+	}
+	if search[1].URL != "https://www.lider.cl/supermercado/search?query=leche" {
+		t.Errorf("www_search_page URL = %s, want the default host", search[1].URL)
+	}
+
+	for _, raw := range []string{"localhost:9090", "/apps", "http://"} {
+		t.Run(raw, func(t *testing.T) {
+			t.Setenv("UPSTREAM_APPS_URL", raw)
+			_, _, err := loadUpstreamCatalog("")
+			if err == nil || !strings.Contains(err.Error(), "UPSTREAM_APPS_URL") {
+				t.Errorf("error = %v, want UPSTREAM_APPS_URL rejected", err)
+			}
+		})
+	}
+}